@@ -71,7 +71,7 @@ func (r *botContext) taskAvailable(task *botTask, helpSystem, verboseOnly bool)
 	}
 	if len(task.Channels) > 0 {
 		for _, pchannel := range task.Channels {
-			if pchannel == r.Channel {
+			if r.inChannel(pchannel) {
 				return true
 			}
 		}
@@ -86,3 +86,13 @@ func (r *botContext) taskAvailable(task *botTask, helpSystem, verboseOnly bool)
 	r.debug(fmt.Sprintf(nvmsg+"; channel '%s' is not on the list of allowed channels: %s", r.Channel, strings.Join(task.Channels, ", ")), verboseOnly)
 	return false
 }
+
+// inChannel checks whether the message came from the given channel; a channel
+// given as "protocol:channel" only matches on that protocol.
+func (r *botContext) inChannel(channel string) bool {
+	protocol, ch := splitChannel(channel)
+	if ch != r.Channel {
+		return false
+	}
+	return len(protocol) == 0 || protocol == r.protocol
+}
@@ -113,49 +113,59 @@ func teardown(t *testing.T, done <-chan struct{}, conn *testc.TestConnector) {
 func testcases(t *testing.T, conn *testc.TestConnector, tests []testItem) {
 	for _, test := range tests {
 		conn.SendBotMessage(&testc.TestMessage{test.user, test.channel, test.message})
-		for _, want := range test.replies {
-			if re, err := regexp.Compile(want.Message); err != nil {
-				t.Errorf("FAILED: regex \"%s\" didn't compile: %v", want.Message, err)
+		checkReplies(t, conn, test.replies)
+		checkEvents(t, test.events)
+		if test.pause > 0 {
+			time.Sleep(time.Millisecond * time.Duration(test.pause))
+		}
+	}
+}
+
+// checkReplies checks the next replies from the robot, in order
+func checkReplies(t *testing.T, conn *testc.TestConnector, replies []testc.TestMessage) {
+	for _, want := range replies {
+		if re, err := regexp.Compile(want.Message); err != nil {
+			t.Errorf("FAILED: regex \"%s\" didn't compile: %v", want.Message, err)
+		} else {
+			got, err := conn.GetBotMessage()
+			if err != nil {
+				t.Errorf("FAILED timeout waiting for reply from robot; want: \"%s\"", want.Message)
 			} else {
-				got, err := conn.GetBotMessage()
-				if err != nil {
-					t.Errorf("FAILED timeout waiting for reply from robot; want: \"%s\"", want.Message)
+				if !re.MatchString(got.Message) {
+					t.Errorf("FAILED message regex match; want: \"%s\", got: \"%s\"", want.Message, got.Message)
 				} else {
-					if !re.MatchString(got.Message) {
-						t.Errorf("FAILED message regex match; want: \"%s\", got: \"%s\"", want.Message, got.Message)
-					} else {
-						if got.User != want.User || got.Channel != want.Channel {
-							t.Errorf("FAILED user/channel match; want u:%s, c:%s; got u:%s,c:%s", want.User, want.Channel, got.User, got.Channel)
-						}
+					if got.User != want.User || got.Channel != want.Channel {
+						t.Errorf("FAILED user/channel match; want u:%s, c:%s; got u:%s,c:%s", want.User, want.Channel, got.User, got.Channel)
 					}
 				}
 			}
 		}
-		ev := GetEvents()
-		evOk := true
-		if len(*ev) != len(test.events) {
-			evOk = false
-		} else {
-			for i, e := range *ev {
-				if e != test.events[i] {
-					evOk = false
-				}
+	}
+}
+
+// checkEvents checks the events emitted since the last check
+func checkEvents(t *testing.T, want []Event) {
+	ev := GetEvents()
+	evOk := true
+	if len(*ev) != len(want) {
+		evOk = false
+	} else {
+		for i, e := range *ev {
+			if e != want[i] {
+				evOk = false
 			}
 		}
-		if !evOk {
-			wevs := make([]string, len(test.events))
-			for i, e := range test.events {
-				wevs[i] = e.String()
-			}
-			gevs := make([]string, len(*ev))
-			for i, e := range *ev {
-				gevs[i] = e.String()
-			}
-			t.Errorf("FAILED emitted events; want: \"%s\"; got: %s\n", strings.Join(wevs, ", "), strings.Join(gevs, ", "))
+	}
+	if !evOk {
+		wevs := make([]string, len(want))
+		for i, e := range want {
+			wevs[i] = e.String()
 		}
-		if test.pause > 0 {
-			time.Sleep(time.Millisecond * time.Duration(test.pause))
+		gevs := make([]string, len(*ev))
+		for i, e := range *ev {
+			gevs[i] = e.String()
 		}
+		t.Errorf("FAILED emitted events; want: \"%s\"; got: %s\n", strings.Join(wevs, ", "), strings.Join(gevs, ", "))
 	}
 }
 
@@ -340,8 +350,8 @@ func TestHelp(t *testing.T) {
 	done, conn := setup("cfg/test/membrain", "/tmp/bottest.log", t)
 
 	tests := []testItem{
-		// Took a while to get the regex right - exactly 12 lines of output (11 + [^\n]*)
-		{alice, deadzone, ";help", []testc.TestMessage{{null, deadzone, `(?s:^Command(?:[^\n]*\n){11}[^\n]*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		// Took a while to get the regex right - exactly 28 lines of output (27 + [^\n]*)
+		{alice, deadzone, ";help", []testc.TestMessage{{null, deadzone, `(?s:^Command(?:[^\n]*\n){27}[^\n]*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
 		{alice, deadzone, ";help help", []testc.TestMessage{{null, deadzone, `(?s:^Command(?:[^\n]*\n){3}[^\n]*$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
 	}
 	testcases(t, conn, tests)
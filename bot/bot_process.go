@@ -46,43 +46,53 @@ func RegisterConnector(name string, connstarter func(Handler, *log.Logger) Conne
 // robot holds all the interal data relevant to the Bot. Most of it is populated
 // by loadConfig, other stuff is populated by the connector.
 var robot struct {
-	Connector                             // Connector interface, implemented by each specific protocol
-	adminUsers           []string         // List of users with access to administrative commands
-	alias                rune             // single-char alias for addressing the bot
-	name                 string           // e.g. "Gort"
-	fullName             string           // e.g. "Robbie Robot"
-	adminContact         string           // who to contact for problems with the robot.
-	email                string           // the from: when the robot sends email
-	mailConf             botMailer        // configuration to use when sending email
-	ignoreUsers          []string         // list of users to never listen to, like other bots
-	preRegex             *regexp.Regexp   // regex for matching prefixed commands, e.g. "Gort, drop your weapon"
-	postRegex            *regexp.Regexp   // regex for matching, e.g. "open the pod bay doors, hal"
-	bareRegex            *regexp.Regexp   // regex for matching the robot's bare name, if you forgot it in the previous command
-	joinChannels         []string         // list of channels to join
-	defaultAllowDirect   bool             // whether plugins are available in DM by default
-	defaultMessageFormat MessageFormat    // Raw unless set to Variable or Fixed
-	plugChannels         []string         // list of channels where plugins are available by default
-	protocol             string           // Name of the protocol, e.g. "slack"
-	brainProvider        string           // Type of Brain provider to use
-	brain                SimpleBrain      // Interface for robot to Store and Retrieve data
-	brainKey             string           // Configured brain key
-	historyProvider      string           // Name of the history provider to use
-	history              HistoryProvider  // Provider for storing and retrieving job / plugin histories
-	defaultElevator      string           // Plugin name for performing elevation
-	defaultAuthorizer    string           // Plugin name for performing authorization
-	externalPlugins      []externalPlugin // List of external plugins to load
-	externalJobs         []externalJob    // List of external jobs to load
-	scheduledTasks       []scheduledTask  // List of scheduled tasks
-	port                 string           // Localhost port to listen on
-	stop                 chan struct{}    // stop channel for stopping the connector
-	done                 chan struct{}    // channel closed when robot finishes shutting down
-	timeZone             *time.Location   // for forcing the TimeZone, Unix only
-	defaultJobChannel    string           // where job statuses will post if not otherwise specified
-	shuttingDown         bool             // to prevent new plugins from starting
-	pluginsRunning       int              // a count of how many plugins are currently running
-	paused               bool             // it's a Windows thing
-	sync.WaitGroup                        // for keeping track of running plugins
-	sync.RWMutex                          // for safe updating of bot data structures
+	Connector                                       // Connector interface, implemented by each specific protocol; the connector for the primary protocol
+	protocolConnectors   map[string]Connector       // Connectors for all protocols, by protocol name
+	adminUsers           []string                   // List of users with access to administrative commands
+	alias                rune                       // single-char alias for addressing the bot
+	name                 string                     // e.g. "Gort"
+	fullName             string                     // e.g. "Robbie Robot"
+	adminContact         string                     // who to contact for problems with the robot.
+	email                string                     // the from: when the robot sends email
+	mailConf             botMailer                  // configuration to use when sending email
+	ignoreUsers          []string                   // list of users to never listen to, like other bots
+	selfNames            map[string]string          // The robot's own user name on each protocol, never listened to
+	userRoster           userRoster                 // user attributes from configuration, supplementing the connector
+	preRegex             *regexp.Regexp             // regex for matching prefixed commands, e.g. "Gort, drop your weapon"
+	postRegex            *regexp.Regexp             // regex for matching, e.g. "open the pod bay doors, hal"
+	bareRegex            *regexp.Regexp             // regex for matching the robot's bare name, if you forgot it in the previous command
+	protocolNames        map[string]string          // The robot's name on secondary protocols, by protocol name
+	protocolRegexes      map[string]nameRegexes     // pre, post and bare regexes for each name in protocolNames
+	joinChannels         []string                   // list of channels to join
+	defaultAllowDirect   bool                       // whether plugins are available in DM by default
+	defaultMessageFormat MessageFormat              // Raw unless set to Variable or Fixed
+	plugChannels         []string                   // list of channels where plugins are available by default
+	protocol             string                     // Name of the primary protocol, e.g. "slack"
+	protocols            []protocolSpec             // All configured protocols, primary first
+	brainProvider        string                     // Type of Brain provider to use
+	brain                SimpleBrain                // Interface for robot to Store and Retrieve data
+	brainKey             string                     // Configured brain key
+	historyProvider      string                     // Name of the history provider to use
+	history              HistoryProvider            // Provider for storing and retrieving job / plugin histories
+	defaultElevator      string                     // Plugin name for performing elevation
+	defaultAuthorizer    string                     // Plugin name for performing authorization
+	externalPlugins      []externalPlugin           // List of external plugins to load
+	externalJobs         []externalJob              // List of external jobs to load
+	scheduledTasks       []scheduledTask            // List of scheduled tasks
+	port                 string                     // Localhost port to listen on
+	webhookAddress       string                     // Address for the webhook listener, if any
+	webhooks             map[string]webhookEndpoint // Configured webhooks, by endpoint
+	stop                 chan struct{}              // stop channel for stopping the connector
+	done                 chan struct{}              // channel closed when robot finishes shutting down
+	timeZone             *time.Location             // for forcing the TimeZone, Unix only
+	defaultTaskTimeout   time.Duration              // how long external tasks can run when no Timeout is configured
+	replyTimeout         time.Duration              // how long prompts wait for a reply when the task doesn't say otherwise
+	defaultJobChannel    string                     // where job statuses will post if not otherwise specified
+	shuttingDown         bool                       // to prevent new plugins from starting
+	pluginsRunning       int                        // a count of how many plugins are currently running
+	paused               bool                       // it's a Windows thing
+	sync.WaitGroup                                  // for keeping track of running plugins
+	sync.RWMutex                                    // for safe updating of bot data structures
 }
 
 var listening bool // for tests where initBot runs multiple times
@@ -148,7 +158,46 @@ func initBot(cpath, epath string, logger *log.Logger) {
 			http.Handle("/json", h)
 			Log(Fatal, http.ListenAndServe(robot.port, nil))
 		}()
+		if len(robot.webhookAddress) > 0 {
+			go serveWebhooks(robot.webhookAddress)
+		}
+	}
+}
+
+// initializeConnectors initializes a connector for each configured protocol,
+// each with a handler for it's own protocol, and returns the connector for the
+// primary protocol.
+func initializeConnectors(logger *log.Logger) Connector {
+	robot.Lock()
+	protocols := robot.protocols
+	robot.protocolConnectors = make(map[string]Connector)
+	robot.Unlock()
+	var primary Connector
+	for i, p := range protocols {
+		initializeConnector, ok := connectors[p.Protocol]
+		if !ok {
+			Log(Fatal, fmt.Sprintf("No connector registered with name: %s", p.Protocol))
+		}
+		conn := initializeConnector(handler{p.Protocol}, logger)
+		robot.Lock()
+		robot.protocolConnectors[p.Protocol] = conn
+		robot.Unlock()
+		if i == 0 {
+			primary = conn
+		}
 	}
+	return primary
+}
+
+// getConnector returns the connector for a protocol, or the connector for
+// the primary protocol if protocol is empty or not running
+func getConnector(protocol string) Connector {
+	robot.RLock()
+	defer robot.RUnlock()
+	if conn, ok := robot.protocolConnectors[protocol]; ok {
+		return conn
+	}
+	return robot.Connector
 }
 
 // set connector sets the connector, which should already be initialized
@@ -175,10 +224,19 @@ func run() <-chan struct{} {
 	var cl []string
 	robot.RLock()
 	cl = append(cl, robot.joinChannels...)
+	protocols := robot.protocols
 	robot.RUnlock()
 	for _, channel := range cl {
 		robot.JoinChannel(channel)
 	}
+	for _, p := range protocols {
+		conn := getConnector(p.Protocol)
+		for _, channel := range p.JoinChannels {
+			conn.JoinChannel(channel)
+		}
+	}
+	catchUpTasks()
+	loadDelayedTasks()
 
 	// signal handler
 	go func() {
@@ -210,12 +268,20 @@ func run() <-chan struct{} {
 		}
 	}()
 
-	// connector loop
+	// connector loops; the robot is done when they've all stopped
 	robot.RLock()
-	go func(conn Connector, stop <-chan struct{}, done chan<- struct{}) {
-		conn.Run(stop)
+	var running sync.WaitGroup
+	for _, conn := range robot.protocolConnectors {
+		running.Add(1)
+		go func(conn Connector, stop <-chan struct{}) {
+			conn.Run(stop)
+			running.Done()
+		}(conn, robot.stop)
+	}
+	go func(done chan<- struct{}) {
+		running.Wait()
 		close(done)
-	}(robot.Connector, robot.stop, robot.done)
+	}(robot.done)
 	robot.RUnlock()
 
 	initializePlugins()
@@ -233,6 +299,7 @@ func stop() {
 	stop := robot.stop
 	robot.RUnlock()
 	Log(Debug, fmt.Sprintf("stop called with %d plugins running", pr))
+	stopDelayedTasks()
 	robot.Wait()
 	brainQuit()
 	close(stop)
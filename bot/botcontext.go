@@ -6,6 +6,7 @@ import (
 	"os/exec"
 	"strconv"
 	"sync"
+	"time"
 )
 
 /* robot.go - internal methods on the Robot object */
@@ -52,17 +53,23 @@ func getBotContextInt(idx int) *botContext {
 // robots. Should be called before running plugins
 func (c *botContext) registerActive() {
 	robot.RLock()
-	c.Protocol = setProtocol(robot.protocol)
+	if len(c.protocol) == 0 {
+		c.protocol = robot.protocol
+	}
+	c.Protocol = setProtocol(c.protocol)
 	c.Format = robot.defaultMessageFormat
 	c.environment["GOPHER_HTTP_POST"] = "http://" + robot.port
 	robot.RUnlock()
 	c.nextTasks = make([]taskSpec, 0)
+	c.failTasks = make([]taskSpec, 0)
+	c.finalTasks = make([]taskSpec, 0)
 	botRunID.Lock()
 	botRunID.idx++
 	if botRunID.idx == 0 {
 		botRunID.idx = 1
 	}
 	c.id = botRunID.idx
+	c.startTime = time.Now()
 	c.environment["GOPHER_INSTALLDIR"] = installPath
 	if len(configPath) > 0 {
 		c.environment["GOPHER_CONFIGDIR"] = configPath
@@ -81,18 +88,31 @@ func (c *botContext) deregister() {
 	activeRobots.Lock()
 	delete(activeRobots.i, c.id)
 	activeRobots.Unlock()
+	c.cancelPrompt()
 }
 
 // makeRobot returns
 func (c *botContext) makeRobot() *Robot {
 	return &Robot{
-		User:     c.User,
-		Channel:  c.Channel,
-		Format:   c.Format,
-		Protocol: c.Protocol,
-		RawMsg:   c.RawMsg,
-		id:       c.id,
+		User:            c.User,
+		Channel:         c.Channel,
+		ThreadID:        c.ThreadID,
+		ThreadedMessage: c.ThreadedMessage,
+		Format:          c.Format,
+		Protocol:        c.Protocol,
+		RawMsg:          c.RawMsg,
+		protocol:        c.protocol,
+		id:              c.id,
+	}
+}
+
+// replyThread returns the thread a reply to a prompt would come in, or "" if
+// the message wasn't in a thread
+func (c *botContext) replyThread() string {
+	if c.ThreadedMessage {
+		return c.ThreadID
 	}
+	return ""
 }
 
 // botContext is created for each incoming message, in a separate goroutine that
@@ -102,7 +122,10 @@ func (c *botContext) makeRobot() *Robot {
 type botContext struct {
 	User                 string            // The user who sent the message; this can be modified for replying to an arbitrary user
 	Channel              string            // The channel where the message was received, or "" for a direct message. This can be modified to send a message to an arbitrary channel.
+	ThreadID             string            // The thread the message was received in, or the ID of a thread started from it
+	ThreadedMessage      bool              // Set when the message was received in a thread
 	Protocol             Protocol          // slack, terminal, test, others; used for interpreting rawmsg or sending messages with Format = 'Raw'
+	protocol             string            // name of the connector protocol the pipeline originated from, e.g. "slack"
 	RawMsg               interface{}       // raw struct of message sent by connector; interpret based on protocol. For Slack this is a *slack.MessageEvent
 	Format               MessageFormat     // robot's default message format
 	NameSpace            string            // memory namespace for this pipeline
@@ -115,12 +138,20 @@ type botContext struct {
 	elevated             bool              // set when required elevation succeeds
 	environment          map[string]string // environment vars set for each job/plugin in the pipeline
 	pipeStarting         bool              // to prevent re-loading environment of first task in pipeline
+	taskParameters       map[string]string // stored parameters for the running Go job only, read by GetParameter
 	nextTasks            []taskSpec        // tasks in the pipeline
+	failTasks            []taskSpec        // tasks to run if the pipeline fails
+	finalTasks           []taskSpec        // tasks to run when the pipeline finishes
+	parallelTask         bool              // set for a task in a parallel group, which can't add tasks
 	logger               HistoryLogger     // where to send stdout / stderr
 	pipeName, pipeDesc   string            // name and description of task that started pipeline
 	currentTask          interface{}       // pointer to currently executing task
+	startTime            time.Time         // when the context was registered, for listing pipelines
 	sync.Mutex                             // Protects access to the items below
 	taskName             string            // name of current task
 	taskDesc             string            // description for same
 	osCmd                *exec.Cmd         // running Command, for aborting a pipeline
+	killedBy             string            // user that killed the pipeline, if killed
+	parallel             []*botContext     // contexts for tasks added with AddParallelTasks while they run
+	pendingPrompt        *pendingPrompt    // an external task's prompt still waiting for a reply
 }
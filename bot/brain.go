@@ -1,11 +1,15 @@
 package bot
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"log"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"sync"
 	"time"
 
@@ -24,6 +28,40 @@ type SimpleBrain interface {
 	Retrieve(key string) (blob *[]byte, exists bool, err error)
 }
 
+// BrainDeleter is an optional interface for brains that can remove memories;
+// the robot checks for it with a type assertion, so brains that only
+// implement SimpleBrain keep working.
+type BrainDeleter interface {
+	// Delete removes the datum with the given key; deleting a key that
+	// doesn't exist isn't an error.
+	Delete(key string) error
+}
+
+// BrainLister is an optional interface for brains that can list the keys they
+// hold.
+type BrainLister interface {
+	// List returns all the keys that start with prefix, in no particular
+	// order.
+	List(prefix string) ([]string, error)
+}
+
+// BrainLocker is an optional interface for brains shared by more than one
+// robot process, e.g. for HA. Datum locks are normally only held in the brain
+// loop of a single process; when the brain implements BrainLocker, the robot
+// also takes a lock in the brain for every read-write checkout, so
+// CheckoutDatum / UpdateDatum semantics hold across processes.
+type BrainLocker interface {
+	// Lock takes the lock for key with the given token, or renews it if it's
+	// already held with the same token, expiring after ttl; it returns false
+	// if the lock is held with a different token.
+	Lock(key, token string, ttl time.Duration) (bool, error)
+	// Unlock releases the lock for key, only if it's still held by token.
+	Unlock(key, token string) error
+	// StoreLocked stores the blob and releases the lock, only if the lock for
+	// key is still held by token; it returns false if the lock expired.
+	StoreLocked(key, token string, blob *[]byte) (bool, error)
+}
+
 // Map of registered brains
 var brains = make(map[string]func(Handler, *log.Logger) SimpleBrain)
 
@@ -65,6 +103,10 @@ const paramPrefix = "bot:parameters:"
 
 const histPrefix = "bot:histories:"
 
+const schedulesKey = "bot:schedules"
+
+const delayedTasksKey = "bot:delayedtasks"
+
 const shortTermDuration = 7 * time.Minute
 
 type brainOpType int
@@ -73,6 +115,9 @@ const (
 	checkOutBytes brainOpType = iota
 	checkInBytes
 	updateBytes
+	deleteBytes
+	listKeys
+	importBytes
 	quit
 )
 
@@ -99,6 +144,32 @@ type updateRequest struct {
 	reply chan RetVal
 }
 
+type deleteRequest struct {
+	key   string
+	reply chan RetVal
+}
+
+type listRequest struct {
+	prefix string
+	reply  chan listReply
+}
+
+type listReply struct {
+	keys   []string
+	retval RetVal
+}
+
+type importRequest struct {
+	key   string
+	datum *[]byte
+	reply chan importReply
+}
+
+type importReply struct {
+	stored bool
+	err    error
+}
+
 type checkOutReply struct {
 	token  string
 	bytes  *[]byte
@@ -130,13 +201,36 @@ var brainChanEvents = make(chan brainOp)
 // a value of time.Second means a lock will last between 1 and 2 seconds
 const memCycle = time.Second
 
-func replyToWaiter(m *memstatus) {
-	creq := m.waiters[0]
-	m.waiters = m.waiters[1:]
-	lt, d, e, r := getDatum(creq.key, true)
-	m.state = newMemory
-	m.token = lt
-	creq.reply <- checkOutReply{lt, d, e, r}
+// For a BrainLocker, locks in the brain expire after lockTTL, and are renewed
+// every memCycle until the checkout expires in memory and the lock is
+// released; so other robots can take a datum when a robot in this process
+// could, e.g. after a task died without checking in. A checkout waits for a
+// lock held by another process for up to lockWait, checking every lockRetry.
+const lockTTL = 2 * memCycle
+const lockWait = lockTTL + memCycle
+const lockRetry = 50 * time.Millisecond
+
+// datumLockedElsewhere is returned by getDatum when another robot holds the
+// lock in the brain; checkout retries until lockWait. It's never returned to
+// tasks.
+const datumLockedElsewhere RetVal = -1
+
+// replyToWaiter gives the memory to the next waiter that can check it out,
+// returning false if none could, e.g. when another robot holds the lock in
+// the brain; those waiters get the error.
+func replyToWaiter(m *memstatus) bool {
+	for len(m.waiters) > 0 {
+		creq := m.waiters[0]
+		m.waiters = m.waiters[1:]
+		lt, d, e, r := getDatum(creq.key, true)
+		creq.reply <- checkOutReply{lt, d, e, r}
+		if r == Ok {
+			m.state = newMemory
+			m.token = lt
+			return true
+		}
+	}
+	return false
 }
 
 // When EncryptBrain is true, the brain needs to be initialized.
@@ -166,7 +260,7 @@ func initializeEncryption(key string) bool {
 	cryptBrain.initializing = true
 	cryptBrain.Unlock()
 	// retrieve the 'real' key
-	_, rk, exists, ret := getDatum(botBrainKey, true)
+	_, rk, exists, ret := getDatum(botBrainKey, false)
 	if ret != Ok {
 		cryptBrain.Lock()
 		cryptBrain.initializing = false
@@ -216,13 +310,82 @@ func initializeEncryption(key string) bool {
 	return true
 }
 
-// Most likely used when switching from configured to interactively-provided
-// brainKey
+// reKey encrypts the 'real' brain key with a new user-supplied key, e.g. when
+// rotating the passphrase or switching from a configured to an
+// interactively-provided brainKey. Memories are encrypted with the 'real' key,
+// so they don't need to be re-stored.
 func reKey(newkey string) bool {
-	// NOTE: this function should temporarily set initialized = false
+	kbytes := []byte(newkey)
+	if len(kbytes) < 32 {
+		Log(Error, "Failed to re-key brain, provided brain key < 32 bytes")
+		return false
+	}
+	cryptBrain.Lock()
+	defer cryptBrain.Unlock()
+	if !cryptBrain.initialized {
+		Log(Error, "Failed to re-key brain, brain encryption isn't initialized")
+		return false
+	}
+	encrypted, err := encrypt(cryptBrain.key, kbytes[0:32])
+	memguard.WipeBytes(kbytes)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Failed to re-key brain, error encrypting brain key: %v", err))
+		return false
+	}
+	// Stored directly; storeDatum would encrypt with the 'real' key
+	if err := robot.brain.Store(botBrainKey, &encrypted); err != nil {
+		Log(Error, fmt.Sprintf("Failed to re-key brain, error storing brain key: %v", err))
+		return false
+	}
 	return true
 }
 
+// encryptMemories checks out and re-stores every memory, so a brain that
+// existed before EncryptBrain was turned on is fully encrypted in one go,
+// rather than as memories are read. Memories that can't be converted are
+// logged and skipped; returns the number stored and the keys that failed.
+func encryptMemories() (int, []string, RetVal) {
+	failed := make([]string, 0)
+	cryptBrain.RLock()
+	initialized := cryptBrain.initialized
+	cryptBrain.RUnlock()
+	if !encryptBrain || !initialized {
+		return 0, failed, BrainFailed
+	}
+	keys, ret := listKeysWithPrefix("")
+	if ret != Ok {
+		return 0, failed, ret
+	}
+	stored := 0
+	for _, key := range keys {
+		if key == botBrainKey {
+			continue
+		}
+		if keyRe.FindString(key) != key {
+			Log(Warn, fmt.Sprintf("Skipping conversion of '%s', not a valid memory key", key))
+			failed = append(failed, key)
+			continue
+		}
+		lt, datum, exists, ret := checkout(key, true)
+		if ret != Ok {
+			Log(Error, fmt.Sprintf("Converting memory '%s', checkout failed: %s", key, ret))
+			failed = append(failed, key)
+			continue
+		}
+		if !exists {
+			checkinDatum(key, lt)
+			continue
+		}
+		if ret := update(key, lt, datum); ret != Ok {
+			Log(Error, fmt.Sprintf("Converting memory '%s', update failed: %s", key, ret))
+			failed = append(failed, key)
+			continue
+		}
+		stored++
+	}
+	return stored, failed, Ok
+}
+
 // getDatum retrieves a blob of bytes from the brain provider and optionally
 // decrypts it
 func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists bool, ret RetVal) {
@@ -242,6 +405,9 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 		ltb := make([]byte, 8)
 		rand.Read(ltb)
 		token = fmt.Sprintf("%x", ltb)
+		if ret := lockDatum(dkey, token); ret != Ok {
+			return "", nil, false, ret
+		}
 	} else {
 		token = ""
 	}
@@ -292,6 +458,82 @@ func getDatum(dkey string, rw bool) (token string, databytes *[]byte, exists boo
 	return token, db, true, Ok
 }
 
+// lockDatum takes the lock for a datum in a BrainLocker brain, returning
+// datumLockedElsewhere if another process holds it. It's called from the
+// brain loop, so it doesn't wait; see checkout.
+func lockDatum(dkey, token string) RetVal {
+	locker, ok := robot.brain.(BrainLocker)
+	if !ok {
+		return Ok
+	}
+	locked, err := locker.Lock(dkey, token, lockTTL)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Locking datum %s: %v", dkey, err))
+		return BrainFailed
+	}
+	if !locked {
+		return datumLockedElsewhere
+	}
+	return Ok
+}
+
+// renewLocks renews the locks in a BrainLocker brain for memories that are
+// still checked out, until they're available to other owners.
+func renewLocks(memories map[string]*memstatus) {
+	locker, ok := robot.brain.(BrainLocker)
+	if !ok {
+		return
+	}
+	for k, m := range memories {
+		if m.state == available || len(m.token) == 0 {
+			continue
+		}
+		locked, err := locker.Lock(k, m.token, lockTTL)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Renewing lock for datum %s: %v", k, err))
+			continue
+		}
+		if !locked {
+			Log(Warn, fmt.Sprintf("Lost the lock in the brain for datum %s to another robot", k))
+		}
+	}
+}
+
+// unlockDatum releases the lock for a datum in a BrainLocker brain.
+func unlockDatum(dkey, token string) {
+	if len(token) == 0 {
+		return
+	}
+	if locker, ok := robot.brain.(BrainLocker); ok {
+		if err := locker.Unlock(dkey, token); err != nil {
+			Log(Error, fmt.Sprintf("Unlocking datum %s: %v", dkey, err))
+		}
+	}
+}
+
+// storeLockedDatum stores a checked-out datum; for a BrainLocker brain, the
+// store only succeeds if the lock in the brain is still held.
+func storeLockedDatum(dkey, token string, datum *[]byte) RetVal {
+	locker, ok := robot.brain.(BrainLocker)
+	if !ok {
+		return storeDatum(dkey, datum)
+	}
+	datum, ret := encryptDatum(dkey, datum)
+	if ret != Ok {
+		return ret
+	}
+	stored, err := locker.StoreLocked(dkey, token, datum)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Storing datum %s: %v", dkey, err))
+		return BrainFailed
+	}
+	if !stored {
+		Log(Warn, fmt.Sprintf("Lock in the brain expired for datum %s, not updated", dkey))
+		return DatumLockExpired
+	}
+	return Ok
+}
+
 // storeDatum takes a blob of bytes and optionally encrypts it before sending it
 // to the brain provider
 func storeDatum(dkey string, datum *[]byte) RetVal {
@@ -300,6 +542,20 @@ func storeDatum(dkey string, datum *[]byte) RetVal {
 		Log(Error, "Brain function called with no brain configured")
 		return BrainFailed
 	}
+	datum, ret := encryptDatum(dkey, datum)
+	if ret != Ok {
+		return ret
+	}
+	err := brain.Store(dkey, datum)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Storing datum %s: %v", dkey, err))
+		return BrainFailed
+	}
+	return Ok
+}
+
+// encryptDatum encrypts a blob of bytes for storage when EncryptBrain is set
+func encryptDatum(dkey string, datum *[]byte) (*[]byte, RetVal) {
 	if encryptBrain {
 		cryptBrain.RLock()
 		initialized := cryptBrain.initialized
@@ -310,24 +566,131 @@ func storeDatum(dkey string, datum *[]byte) RetVal {
 			// When re-keying, we store the 'real' key while uninitialized with a new key
 			if !(initializing && dkey == botBrainKey) {
 				Log(Error, "storeDatum called for '%s' with encryptBrain true, but brain not initialized", key)
-				return BrainFailed
+				return nil, BrainFailed
 			}
 		}
 		encrypted, err := encrypt(*datum, key)
 		if err != nil {
 			Log(Error, fmt.Sprintf("Failed encrypting '%s': %v", dkey, err))
-			return BrainFailed
+			return nil, BrainFailed
 		}
 		datum = &encrypted
 	}
-	err := robot.brain.Store(dkey, datum)
-	if err != nil {
-		Log(Error, fmt.Sprintf("Storing datum %s: %v", dkey, err))
+	return datum, Ok
+}
+
+// deleteDatum removes a datum with the brain provider, if the brain supports
+// it
+func deleteDatum(dkey string) RetVal {
+	brain := robot.brain
+	if brain == nil {
+		Log(Error, "Brain function called with no brain configured")
+		return BrainFailed
+	}
+	deleter, ok := brain.(BrainDeleter)
+	if !ok {
+		Log(Warn, fmt.Sprintf("Unable to delete '%s', configured brain doesn't support Delete", dkey))
+		return BrainNotSupported
+	}
+	if err := deleter.Delete(dkey); err != nil {
+		Log(Error, fmt.Sprintf("Deleting datum %s: %v", dkey, err))
 		return BrainFailed
 	}
 	return Ok
 }
 
+// listDatumKeys returns the keys matching a prefix from the brain provider,
+// if the brain supports it
+func listDatumKeys(prefix string) ([]string, RetVal) {
+	brain := robot.brain
+	if brain == nil {
+		Log(Error, "Brain function called with no brain configured")
+		return nil, BrainFailed
+	}
+	lister, ok := brain.(BrainLister)
+	if !ok {
+		Log(Warn, fmt.Sprintf("Unable to list keys with prefix '%s', configured brain doesn't support List", prefix))
+		return nil, BrainNotSupported
+	}
+	keys, err := lister.List(prefix)
+	if err != nil {
+		Log(Error, fmt.Sprintf("Listing keys with prefix %s: %v", prefix, err))
+		return nil, BrainFailed
+	}
+	sort.Strings(keys)
+	return keys, Ok
+}
+
+// importDatum stores raw bytes for a key that doesn't already exist,
+// bypassing encryption; see importBrainDirectory.
+func importDatum(dkey string, datum *[]byte) (bool, error) {
+	brain := robot.brain
+	if brain == nil {
+		return false, fmt.Errorf("no brain configured")
+	}
+	current, exists, err := brain.Retrieve(dkey)
+	if err != nil {
+		return false, err
+	}
+	if exists {
+		if dkey == botBrainKey && !bytes.Equal(*current, *datum) {
+			return false, fmt.Errorf("the brain already has a different '%s'; import before enabling EncryptBrain", botBrainKey)
+		}
+		return false, nil
+	}
+	if err := brain.Store(dkey, datum); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// importBrainDirectory copies memories from the BrainDirectory of a 'file'
+// brain in to the configured brain, e.g. when migrating to a single-file
+// brain. Memories are copied as-is, so an encrypted brain stays encrypted
+// with the same key; keys that already exist are skipped.
+func importBrainDirectory(dir string) (imported, skipped int, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("reading brain directory '%s': %v", dir, err)
+	}
+	keys := make([]string, 0, len(files))
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		key := f.Name()
+		if keyRe.FindString(key) != key {
+			Log(Warn, fmt.Sprintf("Skipping import of '%s', not a valid memory key", key))
+			continue
+		}
+		// The brain key has to be imported first, since everything else might
+		// be encrypted with it.
+		if key == botBrainKey {
+			keys = append([]string{key}, keys...)
+		} else {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		datum, err := ioutil.ReadFile(filepath.Join(dir, key))
+		if err != nil {
+			return imported, skipped, fmt.Errorf("reading memory '%s': %v", key, err)
+		}
+		reply := make(chan importReply)
+		brainChanEvents <- brainOp{importBytes, importRequest{key, &datum, reply}}
+		rep := <-reply
+		if rep.err != nil {
+			return imported, skipped, fmt.Errorf("importing memory '%s': %v", key, rep.err)
+		}
+		if rep.stored {
+			imported++
+		} else {
+			skipped++
+		}
+	}
+	return imported, skipped, nil
+}
+
 var brLock sync.RWMutex
 
 // runBrain is the select loop that serializes access to brain
@@ -371,7 +734,14 @@ loop:
 				} // read-write request below
 				// if state is available, there are no waiters
 				if memStat.state == available {
+					// the previous owner's lock expired
+					unlockDatum(creq.key, memStat.token)
 					lt, d, e, r := getDatum(creq.key, creq.rw)
+					if r != Ok {
+						delete(memories, creq.key)
+						creq.reply <- checkOutReply{lt, d, e, r}
+						break
+					}
 					memStat.state = newMemory
 					memStat.token = lt // this memory has a new owner now
 					memories[creq.key] = memStat
@@ -386,15 +756,15 @@ loop:
 				if !ok {
 					break
 				}
-				// memory expired and somebody else owns it
-				if ci.token != m.token {
+				// memory expired and somebody else owns it, or the lock in
+				// the brain was released
+				if ci.token != m.token || len(m.token) == 0 {
 					break
 				}
-				if len(m.waiters) > 0 {
-					replyToWaiter(m)
-					break
+				unlockDatum(ci.key, ci.token)
+				if !replyToWaiter(m) {
+					delete(memories, ci.key)
 				}
-				delete(memories, ci.key)
 			case updateBytes:
 				ur := evt.opData.(updateRequest)
 				m, ok := memories[ur.key]
@@ -402,16 +772,27 @@ loop:
 					ur.reply <- DatumNotFound
 					break
 				}
-				if ur.token != m.token {
+				if ur.token != m.token || len(m.token) == 0 {
 					ur.reply <- DatumLockExpired
 					break
 				}
-				ur.reply <- storeDatum(ur.key, ur.datum)
-				if len(m.waiters) > 0 {
-					replyToWaiter(m)
-					break
+				ur.reply <- storeLockedDatum(ur.key, ur.token, ur.datum)
+				if !replyToWaiter(m) {
+					delete(memories, ur.key)
 				}
-				delete(memories, ur.key)
+			case deleteBytes:
+				// NOTE: a datum that's checked out when deleted will be
+				// re-created if the owner updates it.
+				dr := evt.opData.(deleteRequest)
+				dr.reply <- deleteDatum(dr.key)
+			case listKeys:
+				lr := evt.opData.(listRequest)
+				keys, ret := listDatumKeys(lr.prefix)
+				lr.reply <- listReply{keys, ret}
+			case importBytes:
+				ir := evt.opData.(importRequest)
+				stored, err := importDatum(ir.key, ir.datum)
+				ir.reply <- importReply{stored, err}
 			case quit:
 				qr := evt.opData.(quitRequest)
 				qr.reply <- struct{}{}
@@ -426,18 +807,29 @@ loop:
 				}
 			}
 			shortTermMemories.Unlock()
-			for _, m := range memories {
+			for k, m := range memories {
 				switch m.state {
 				case newMemory:
 					m.state = seen
 				case seen:
 					if len(m.waiters) > 0 {
-						replyToWaiter(m)
+						unlockDatum(k, m.token)
+						if !replyToWaiter(m) {
+							delete(memories, k)
+						}
 						break
 					}
 					m.state = available
+					// The checkout expired; for a BrainLocker, release the
+					// lock so other robots can take the datum too, and the
+					// owner's late update fails like it would there.
+					if _, ok := robot.brain.(BrainLocker); ok {
+						unlockDatum(k, m.token)
+						m.token = ""
+					}
 				}
 			}
+			renewLocks(memories)
 		}
 	}
 }
@@ -460,10 +852,24 @@ func checkout(d string, rw bool) (string, *[]byte, bool, RetVal) {
 		Log(Error, err)
 		return "", nil, false, InvalidDatumKey
 	}
-	reply := make(chan checkOutReply)
-	creq := checkOutRequest{d, rw, reply}
-	brainChanEvents <- brainOp{checkOutBytes, creq}
-	rep := <-reply
+	// When another robot holds the lock in the brain, wait for it here
+	// rather than in the brain loop.
+	giveUp := time.Now().Add(lockWait)
+	var rep checkOutReply
+	for {
+		reply := make(chan checkOutReply)
+		creq := checkOutRequest{d, rw, reply}
+		brainChanEvents <- brainOp{checkOutBytes, creq}
+		rep = <-reply
+		if rep.retval != datumLockedElsewhere {
+			break
+		}
+		if time.Now().After(giveUp) {
+			Log(Warn, fmt.Sprintf("Timed out waiting for lock on datum %s held by another robot", d))
+			return "", nil, false, BrainFailed
+		}
+		time.Sleep(lockRetry)
+	}
 	Log(Trace, fmt.Sprintf("Brain datum checkout for %s, rw: %t - token: %s, exists: %t, ret: %d",
 		d, rw, rep.token, rep.exists, rep.retval))
 	return rep.token, rep.bytes, rep.exists, rep.retval
@@ -482,6 +888,28 @@ func update(d, lt string, datum *[]byte) (ret RetVal) {
 	return <-reply
 }
 
+// remove asks the brain loop to delete a datum
+func remove(d string) RetVal {
+	if !keyRe.MatchString(d) {
+		err := fmt.Errorf("Invalid key supplied to delete: %s", d)
+		Log(Error, err)
+		return InvalidDatumKey
+	}
+	reply := make(chan RetVal)
+	Log(Trace, fmt.Sprintf("Deleting datum %s", d))
+	brainChanEvents <- brainOp{deleteBytes, deleteRequest{d, reply}}
+	return <-reply
+}
+
+// listKeysWithPrefix asks the brain loop for a sorted list of keys starting
+// with prefix
+func listKeysWithPrefix(prefix string) ([]string, RetVal) {
+	reply := make(chan listReply)
+	brainChanEvents <- brainOp{listKeys, listRequest{prefix, reply}}
+	rep := <-reply
+	return rep.keys, rep.retval
+}
+
 // checkinDatum is the internal version of CheckinDatum that uses the key as-is
 func checkinDatum(key, locktoken string) {
 	if locktoken == "" {
@@ -557,6 +985,19 @@ func (r *Robot) UpdateDatum(key, locktoken string, datum interface{}) (ret RetVa
 	return updateDatum(key, locktoken, datum)
 }
 
+// DeleteDatum removes a datum from the robot's brain. It returns
+// BrainNotSupported if the configured brain can't delete memories.
+func (r *Robot) DeleteDatum(key string) (ret RetVal) {
+	c := r.getContext()
+	task, _, _ := getTask(c.currentTask)
+	if task.PrivateNameSpace {
+		key = task.NameSpace + ":" + key
+	} else {
+		key = c.NameSpace + ":" + key
+	}
+	return remove(key)
+}
+
 // Remember adds a short-term memory (with no backing store) to the robot's
 // brain. This is used internally for resolving the meaning of "it", but can
 // be used by plugins to remember other contextual facts. Since memories are
@@ -0,0 +1,189 @@
+package bot
+
+/* brain_test.go - tests for datum locking with a BrainLocker brain, using a
+fake shared brain in place of e.g. redis, where the "other robot" is the test
+taking locks directly.
+*/
+
+import (
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeLock struct {
+	token   string
+	expires time.Time
+}
+
+// fakeLocker is a SimpleBrain and BrainLocker that keeps everything in memory
+type fakeLocker struct {
+	mu       sync.Mutex
+	memories map[string][]byte
+	locks    map[string]fakeLock
+}
+
+func (fl *fakeLocker) Store(k string, b *[]byte) error {
+	fl.mu.Lock()
+	fl.memories[k] = *b
+	fl.mu.Unlock()
+	return nil
+}
+
+func (fl *fakeLocker) Retrieve(k string) (*[]byte, bool, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	b, ok := fl.memories[k]
+	return &b, ok, nil
+}
+
+// holder returns the token holding the lock for k, if it hasn't expired
+func (fl *fakeLocker) holder(k string) string {
+	l, ok := fl.locks[k]
+	if !ok || time.Now().After(l.expires) {
+		return ""
+	}
+	return l.token
+}
+
+func (fl *fakeLocker) Lock(k, token string, ttl time.Duration) (bool, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if h := fl.holder(k); len(h) > 0 && h != token {
+		return false, nil
+	}
+	fl.locks[k] = fakeLock{token, time.Now().Add(ttl)}
+	return true, nil
+}
+
+func (fl *fakeLocker) Unlock(k, token string) error {
+	fl.mu.Lock()
+	if fl.holder(k) == token {
+		delete(fl.locks, k)
+	}
+	fl.mu.Unlock()
+	return nil
+}
+
+func (fl *fakeLocker) StoreLocked(k, token string, b *[]byte) (bool, error) {
+	fl.mu.Lock()
+	defer fl.mu.Unlock()
+	if fl.holder(k) != token {
+		return false, nil
+	}
+	fl.memories[k] = *b
+	delete(fl.locks, k)
+	return true, nil
+}
+
+func startLockerBrain(t *testing.T) (*fakeLocker, func()) {
+	botLogger.l = log.New(ioutil.Discard, "", 0)
+	fl := &fakeLocker{
+		memories: make(map[string][]byte),
+		locks:    make(map[string]fakeLock),
+	}
+	robot.brain = fl
+	go runBrain()
+	return fl, func() {
+		brainQuit()
+		robot.brain = nil
+	}
+}
+
+func TestLockRenewedWhileCheckedOut(t *testing.T) {
+	fl, stop := startLockerBrain(t)
+	defer stop()
+	start := time.Now()
+	token, _, _, ret := checkout("datum", true)
+	if ret != Ok {
+		t.Fatalf("checkout returned %s", ret)
+	}
+	// Before the checkout expires in memory, the lock is renewed
+	time.Sleep(memCycle + memCycle/2)
+	fl.mu.Lock()
+	l := fl.locks["datum"]
+	fl.mu.Unlock()
+	if l.token != token || !l.expires.After(start.Add(lockTTL)) {
+		t.Errorf("Lock for a checked-out datum wasn't renewed; token %q, expires %s after checkout", l.token, l.expires.Sub(start))
+	}
+	if ok, _ := fl.Lock("datum", "other", lockTTL); ok {
+		t.Error("Another robot took the lock while the datum was checked out")
+	}
+	b := []byte("updated")
+	if ret := update("datum", token, &b); ret != Ok {
+		t.Errorf("update of a checked-out datum returned %s", ret)
+	}
+	if datum, _, _ := fl.Retrieve("datum"); string(*datum) != "updated" {
+		t.Errorf("datum is %q after update", *datum)
+	}
+}
+
+func TestAbandonedCheckoutUnlocked(t *testing.T) {
+	fl, stop := startLockerBrain(t)
+	defer stop()
+	token, _, _, ret := checkout("datum", true)
+	if ret != Ok {
+		t.Fatalf("checkout returned %s", ret)
+	}
+	// The task never checks the datum in; once the checkout expires in
+	// memory, other robots can take the lock right away
+	time.Sleep(2*memCycle + memCycle/2)
+	if ok, _ := fl.Lock("datum", "other", lockTTL); !ok {
+		t.Fatal("Lock for an abandoned checkout is still held")
+	}
+	b := []byte("late")
+	if ret := update("datum", token, &b); ret != DatumLockExpired {
+		t.Errorf("update after the checkout expired returned %s", ret)
+	}
+	fl.Unlock("datum", "other")
+	if _, _, _, ret := checkout("datum", true); ret != Ok {
+		t.Errorf("checkout after the checkout expired returned %s", ret)
+	}
+}
+
+func TestCheckoutWaitsForOtherRobot(t *testing.T) {
+	fl, stop := startLockerBrain(t)
+	defer stop()
+	fl.Lock("datum", "other", time.Minute)
+	wait := 500 * time.Millisecond
+	time.AfterFunc(wait, func() { fl.Unlock("datum", "other") })
+
+	start := time.Now()
+	got := make(chan RetVal)
+	go func() {
+		token, _, _, ret := checkout("datum", true)
+		if ret == Ok {
+			checkinDatum("datum", token)
+		}
+		got <- ret
+	}()
+	// Other brain operations don't wait for the lock
+	time.Sleep(100 * time.Millisecond)
+	if _, _, _, ret := checkout("other", true); ret != Ok {
+		t.Errorf("checkout of another datum returned %s", ret)
+	}
+	if elapsed := time.Since(start); elapsed >= wait {
+		t.Errorf("checkout of another datum waited %s for the lock", elapsed)
+	}
+	if ret := <-got; ret != Ok {
+		t.Errorf("checkout after the other robot unlocked returned %s", ret)
+	}
+	if elapsed := time.Since(start); elapsed < wait {
+		t.Errorf("checkout returned after %s, before the other robot unlocked", elapsed)
+	}
+}
+
+func TestCheckoutGivesUp(t *testing.T) {
+	fl, stop := startLockerBrain(t)
+	defer stop()
+	fl.Lock("datum", "other", time.Minute)
+	start := time.Now()
+	if _, _, _, ret := checkout("datum", true); ret != BrainFailed {
+		t.Errorf("checkout of a datum locked by another robot returned %s", ret)
+	}
+	if elapsed := time.Since(start); elapsed < lockWait {
+		t.Errorf("checkout gave up after %s, before lockWait", elapsed)
+	}
+}
@@ -3,9 +3,13 @@ package bot
 import (
 	"fmt"
 	"log"
+	"path/filepath"
+	"regexp"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/ghodss/yaml"
@@ -35,8 +39,15 @@ func init() {
 	RegisterPlugin("builtInadmin", PluginHandler{DefaultConfig: adminConfig, Handler: admin})
 	RegisterPlugin("builtInlogging", PluginHandler{DefaultConfig: logConfig, Handler: logging})
 	RegisterPlugin("builtInbrain", PluginHandler{DefaultConfig: encbrainConfig, Handler: encbrain})
+	RegisterPlugin("builtInjobs", PluginHandler{DefaultConfig: jobsConfig, Handler: jobcommands})
+	RegisterPlugin("builtInhistory", PluginHandler{DefaultConfig: historiesConfig, Handler: histories})
+	RegisterPlugin("builtInschedules", PluginHandler{DefaultConfig: schedulesConfig, Handler: schedules})
+	RegisterPlugin("builtInreminders", PluginHandler{DefaultConfig: remindersConfig, Handler: reminders})
 }
 
+// Matches parameters given to 'run job', e.g. FOO=bar BAZ="some value"
+var jobParamRe = regexp.MustCompile(`([\w-]+)=("[^"]*"|'[^']*'|\S+)`)
+
 /* builtin plugins, like help */
 
 func help(bot *Robot, command string, args ...string) (retval TaskRetVal) {
@@ -69,6 +80,9 @@ func help(bot *Robot, command string, args ...string) (retval TaskRetVal) {
 	if command == "help" {
 		robot.RLock()
 		botname := robot.name
+		if pname, ok := robot.protocolNames[bot.protocol]; ok {
+			botname = pname
+		}
 		robot.RUnlock()
 
 		var term, helpOutput string
@@ -279,6 +293,427 @@ func encbrain(bot *Robot, command string, args ...string) (retval TaskRetVal) {
 			bot.Log(Error, fmt.Sprintf("User '%s' failed to initialize brain", bot.User))
 			bot.Say("Failed to initialize brain - check your passphrase?")
 		}
+	case "import":
+		dir := args[0]
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(configPath, dir)
+		}
+		bot.Say(fmt.Sprintf("Importing memories from '%s'...", dir))
+		imported, skipped, err := importBrainDirectory(dir)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Importing brain directory '%s' for user '%s': %v", dir, bot.User, err))
+			bot.Say(fmt.Sprintf("Import failed after %d memories: %v", imported, err))
+			return
+		}
+		Log(Audit, fmt.Sprintf("User '%s' imported %d memories from '%s'", bot.User, imported, dir))
+		bot.Say(fmt.Sprintf("Imported %d memories, skipped %d that already exist", imported, skipped))
+	case "rekey":
+		if !encryptBrain {
+			bot.Say("Brain encryption isn't enabled; set EncryptBrain: true in gopherbot.yaml")
+			return
+		}
+		if reKey(args[0]) {
+			Log(Audit, fmt.Sprintf("Brain key changed by user '%s'", bot.User))
+			bot.Say("Brain successfully re-keyed - you should delete your message if possible, and update any configured BrainKey")
+		} else {
+			Log(Error, fmt.Sprintf("User '%s' failed to re-key brain", bot.User))
+			bot.Say("Failed to re-key brain - is it initialized, and is the new key at least 32 bytes?")
+		}
+	case "convert":
+		if !encryptBrain {
+			bot.Say("Brain encryption isn't enabled; set EncryptBrain: true in gopherbot.yaml and initialize the brain first")
+			return
+		}
+		bot.Say("Encrypting all memories, this may take a while...")
+		stored, failed, ret := encryptMemories()
+		switch ret {
+		case Ok:
+			Log(Audit, fmt.Sprintf("User '%s' converted brain to encrypted, %d memories stored, %d failed", bot.User, stored, len(failed)))
+			if len(failed) > 0 {
+				bot.Say(fmt.Sprintf("Done - %d memories encrypted and stored, %d failed: %s", stored, len(failed), strings.Join(failed, ", ")))
+			} else {
+				bot.Say(fmt.Sprintf("Done - %d memories encrypted and stored", stored))
+			}
+		case BrainNotSupported:
+			bot.Say("Sorry, my brain can't list memories, so it can't be converted all at once; memories will be encrypted as they're used")
+		default:
+			Log(Error, fmt.Sprintf("Converting brain to encrypted for user '%s' failed after %d memories: %s", bot.User, stored, ret))
+			bot.Say(fmt.Sprintf("Failed converting brain after %d memories: %s - is the brain initialized?", stored, ret))
+		}
+	}
+	return
+}
+
+func jobcommands(bot *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return // ignore init
+	}
+	c := bot.getContext()
+	var at time.Time
+	if command == "delay" {
+		var err error
+		at, err = parseWhen(args[0], time.Now().In(userLocation(c.protocol, bot.User)))
+		if err != nil {
+			bot.Say(fmt.Sprintf("Sorry, %v", err))
+			return
+		}
+		command, args = "run", args[1:]
+	}
+	switch command {
+	case "run":
+		name := args[0]
+		t := c.tasks.getTaskByName(name)
+		job := getJob(t)
+		if job == nil {
+			bot.Say(fmt.Sprintf("Sorry, I don't have a job named '%s' configured", name))
+			return
+		}
+		task := job.botTask
+		if task.Disabled {
+			bot.Say(fmt.Sprintf("Sorry, job '%s' is disabled; reason: %s", name, task.reason))
+			return
+		}
+		if !c.taskAvailable(task, false, false) {
+			bot.Say(fmt.Sprintf("Sorry, job '%s' isn't available to you in this channel", name))
+			return
+		}
+		if c.checkAuthorization(t, "run") != Success {
+			return
+		}
+		eret, elevated := c.checkElevation(t, "run")
+		if eret != Success {
+			return
+		}
+		params := make(map[string]string)
+		for _, p := range jobParamRe.FindAllStringSubmatch(args[1], -1) {
+			params[p[1]] = strings.Trim(p[2], `"'`)
+		}
+		// Required parameters can also be supplied by configuration or stored
+		// parameters; see runPipeline.
+		for _, p := range job.Parameters {
+			if _, exists := params[p.Name]; !exists {
+				params[p.Name] = p.Value
+			}
+		}
+		storedEnv := make(map[string]string)
+		checkoutDatum(paramPrefix+task.NameSpace, &storedEnv, false)
+		for _, req := range job.RequiredParameters {
+			if _, exists := params[req]; exists {
+				continue
+			}
+			if _, exists := storedEnv[req]; exists {
+				continue
+			}
+			value, ret := bot.PromptForReply("paramValue", fmt.Sprintf("Job '%s' requires a value for parameter '%s' ('-' to cancel):", name, req))
+			if ret == Ok && value == "-" {
+				ret = Interrupted
+			}
+			if ret != Ok {
+				bot.Say(fmt.Sprintf("Not starting job '%s' without a value for '%s' (%s)", name, req, ret))
+				return
+			}
+			params[req] = value
+		}
+		if !at.IsZero() {
+			dt := delayedTask{
+				At:       at,
+				User:     c.User,
+				Channel:  c.Channel,
+				Protocol: c.protocol,
+				taskSpec: taskSpec{Name: name},
+			}
+			if c.ThreadedMessage {
+				dt.ThreadID = c.ThreadID
+			}
+			for param, value := range params {
+				dt.Parameters = append(dt.Parameters, parameter{param, value})
+			}
+			if _, ret := addDelayedTask(dt); ret != Ok {
+				bot.Say(fmt.Sprintf("Sorry, there was a problem storing the delayed job: %s", ret))
+				return
+			}
+			bot.Say(fmt.Sprintf("OK, I'll run job '%s' at %s", name, at.Format("Mon Jan 2 15:04 MST")))
+			return
+		}
+		jc := &botContext{
+			User:            c.User,
+			Channel:         c.Channel,
+			ThreadID:        c.ThreadID,
+			ThreadedMessage: c.ThreadedMessage,
+			RawMsg:          c.RawMsg,
+			protocol:        c.protocol,
+			isCommand:       true,
+			directMsg:       c.directMsg,
+			elevated:        c.elevated || elevated,
+			tasks: taskList{
+				c.tasks.t,
+				c.tasks.nameMap,
+				c.tasks.idMap,
+				c.tasks.nameSpaces,
+				sync.RWMutex{},
+			},
+			environment: params,
+		}
+		Log(Info, fmt.Sprintf("User '%s' started job '%s' in channel '%s'", bot.User, name, bot.Channel))
+		go jc.runPipeline(t, true, runJob, "run")
+	}
+	return
+}
+
+func histories(bot *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return // ignore init
+	}
+	robot.RLock()
+	hp := robot.history
+	robot.RUnlock()
+	name := args[0]
+	switch command {
+	case "list":
+		var th taskHistory
+		_, exists, ret := checkoutDatum(histPrefix+name, &th, false)
+		if ret != Ok {
+			bot.Say(fmt.Sprintf("Sorry, there was a problem retrieving the run history for '%s': %s", name, ret))
+			return
+		}
+		if !exists || len(th.Histories) == 0 {
+			bot.Say(fmt.Sprintf("I don't have any run history for '%s'", name))
+			return
+		}
+		stored := make(map[int]bool)
+		if hp != nil {
+			indexes, err := hp.ListHistories(name)
+			if err != nil {
+				Log(Error, fmt.Sprintf("Listing histories for '%s': %v", name, err))
+			}
+			for _, i := range indexes {
+				stored[i] = true
+			}
+		}
+		runs := make([]string, 0, len(th.Histories)+1)
+		runs = append(runs, fmt.Sprintf("Remembered runs of '%s':", name))
+		for _, h := range th.Histories {
+			run := fmt.Sprintf("Run %d started %s", h.LogIndex, h.CreateTime)
+			if len(h.StartedBy) > 0 {
+				run += " via " + h.StartedBy
+			}
+			if !stored[h.LogIndex] {
+				run += " (no log)"
+			}
+			runs = append(runs, run)
+		}
+		bot.Say(strings.Join(runs, "\n"))
+	case "show":
+		if hp == nil {
+			bot.Say("Sorry, no HistoryProvider is configured for storing job and plugin histories")
+			return
+		}
+		index, _ := strconv.Atoi(args[1])
+		page, _ := strconv.Atoi(args[2])
+		lines, err := hp.GetHistory(name, index)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Retrieving history %d for '%s': %v", index, name, err))
+			bot.Say(fmt.Sprintf("Sorry, I wasn't able to retrieve history for run %d of '%s'", index, name))
+			return
+		}
+		hpage, wrap := historyPage(lines, page)
+		if wrap {
+			bot.Say("(warning: value too large for pages, showing the beginning of the history)")
+		}
+		bot.Fixed().Say(strings.Join(hpage, "\n"))
+	}
+	return
+}
+
+// schedules handles listing and changing task schedules from chat; changes
+// are stored in the brain, see scheduled_tasks.go.
+func schedules(bot *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return // ignore init
+	}
+	active, paused := listSchedules()
+	switch command {
+	case "list":
+		if len(active) == 0 && len(paused) == 0 {
+			bot.Say("I don't have any scheduled tasks")
+			return
+		}
+		lines := make([]string, 0, len(active)+len(paused)+1)
+		lines = append(lines, "Scheduled tasks:")
+		for _, e := range active {
+			j, ok := e.Job.(*scheduledJob)
+			if !ok {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%s \"%s\"%s - next run %s", j.Name, j.Schedule, j.origin(), e.Next.Format("Mon Jan 2 15:04 MST")))
+		}
+		for _, j := range paused {
+			lines = append(lines, fmt.Sprintf("%s \"%s\"%s - paused", j.Name, j.Schedule, j.origin()))
+		}
+		bot.Fixed().Say(strings.Join(lines, "\n"))
+	case "pause", "resume":
+		name := args[0]
+		found := false
+		if command == "pause" {
+			for _, e := range active {
+				if j, ok := e.Job.(*scheduledJob); ok && j.Name == name {
+					found = true
+				}
+			}
+		} else {
+			for _, j := range paused {
+				if j.Name == name {
+					found = true
+				}
+			}
+		}
+		if !found {
+			bot.Say(fmt.Sprintf("Sorry, I don't have any schedules to %s for '%s'", command, name))
+			return
+		}
+		_, ret := updateSchedules(func(stored *storedSchedules) bool {
+			names := make([]string, 0, len(stored.Paused)+1)
+			for _, p := range stored.Paused {
+				if p != name {
+					names = append(names, p)
+				}
+			}
+			if command == "pause" {
+				names = append(names, name)
+			}
+			stored.Paused = names
+			return true
+		})
+		if ret != Ok {
+			bot.Say(fmt.Sprintf("Sorry, there was a problem storing the schedule change: %s", ret))
+			return
+		}
+		Log(Info, fmt.Sprintf("User '%s' requested %s of schedules for '%s'", bot.User, command, name))
+		if command == "pause" {
+			bot.Say(fmt.Sprintf("Paused schedules for '%s'", name))
+		} else {
+			bot.Say(fmt.Sprintf("Resumed schedules for '%s'", name))
+		}
+	case "schedule":
+		name, spec := args[0], args[1]
+		t := bot.getContext().tasks.getTaskByName(name)
+		if t == nil {
+			bot.Say(fmt.Sprintf("Sorry, I don't have a job or plugin named '%s' configured", name))
+			return
+		}
+		task, plugin, _ := getTask(t)
+		if task.Disabled {
+			bot.Say(fmt.Sprintf("Sorry, '%s' is disabled; reason: %s", name, task.reason))
+			return
+		}
+		if err := checkSchedule(spec); err != nil {
+			bot.Say(fmt.Sprintf("Sorry, '%s' isn't a valid schedule: %v", spec, err))
+			return
+		}
+		st := scheduledTask{Schedule: spec, taskSpec: taskSpec{Name: name}}
+		if plugin != nil {
+			// For plugins, the command and arguments; for jobs, parameters
+			fields := strings.Fields(args[2])
+			if len(fields) == 0 {
+				bot.Say(fmt.Sprintf("Scheduling plugin '%s' requires a command", name))
+				return
+			}
+			st.Command, st.Arguments = fields[0], fields[1:]
+		} else {
+			for _, p := range jobParamRe.FindAllStringSubmatch(args[2], -1) {
+				st.Parameters = append(st.Parameters, parameter{p[1], strings.Trim(p[2], `"'`)})
+			}
+		}
+		_, ret := updateSchedules(func(stored *storedSchedules) bool {
+			stored.Dynamic = append(stored.Dynamic, st)
+			return true
+		})
+		if ret != Ok {
+			bot.Say(fmt.Sprintf("Sorry, there was a problem storing the schedule: %s", ret))
+			return
+		}
+		Log(Info, fmt.Sprintf("User '%s' scheduled '%s' with schedule: %s", bot.User, name, spec))
+		bot.Say(fmt.Sprintf("Scheduled '%s' with schedule: %s", name, spec))
+	case "unschedule":
+		name := args[0]
+		changed, ret := updateSchedules(func(stored *storedSchedules) bool {
+			dynamic := make([]scheduledTask, 0, len(stored.Dynamic))
+			for _, st := range stored.Dynamic {
+				if st.Name != name {
+					dynamic = append(dynamic, st)
+				}
+			}
+			if len(dynamic) == len(stored.Dynamic) {
+				return false
+			}
+			stored.Dynamic = dynamic
+			return true
+		})
+		if ret != Ok {
+			bot.Say(fmt.Sprintf("Sorry, there was a problem removing the schedules: %s", ret))
+			return
+		}
+		if !changed {
+			bot.Say(fmt.Sprintf("I don't have any schedules added from chat for '%s'", name))
+			return
+		}
+		Log(Info, fmt.Sprintf("User '%s' removed schedules for '%s'", bot.User, name))
+		bot.Say(fmt.Sprintf("Removed schedules added from chat for '%s'", name))
+	}
+	return
+}
+
+func reminders(bot *Robot, command string, args ...string) (retval TaskRetVal) {
+	if command == "init" {
+		return // ignore init
+	}
+	loc := userLocation(bot.protocol, bot.User)
+	switch command {
+	case "remind":
+		at, err := parseWhen(args[0], time.Now().In(loc))
+		if err != nil {
+			bot.Say(fmt.Sprintf("Sorry, %v", err))
+			return
+		}
+		c := bot.getContext()
+		dt := delayedTask{
+			At:       at,
+			User:     c.User,
+			Channel:  c.Channel,
+			Protocol: c.protocol,
+			Reminder: args[1],
+		}
+		if c.ThreadedMessage {
+			dt.ThreadID = c.ThreadID
+		}
+		if _, ret := addDelayedTask(dt); ret != Ok {
+			bot.Say(fmt.Sprintf("Sorry, there was a problem storing the reminder: %s", ret))
+			return
+		}
+		bot.Say(fmt.Sprintf("OK, I'll remind you at %s", at.Format("Mon Jan 2 15:04 MST")))
+	case "list":
+		tasks, ret := listDelayedTasks(bot.User)
+		if ret != Ok {
+			bot.Say(fmt.Sprintf("Sorry, there was a problem retrieving your reminders: %s", ret))
+			return
+		}
+		if len(tasks) == 0 {
+			bot.Say("You don't have any reminders or delayed tasks")
+			return
+		}
+		lines := make([]string, 0, len(tasks)+1)
+		lines = append(lines, "Your reminders and delayed tasks:")
+		for _, dt := range tasks {
+			what := dt.Reminder
+			if len(what) == 0 {
+				what = fmt.Sprintf("run '%s'", dt.Name)
+				if len(dt.Command) > 0 {
+					what = strings.TrimSpace(fmt.Sprintf("%s %s %s", what, dt.Command, strings.Join(dt.Arguments, " ")))
+				}
+			}
+			lines = append(lines, fmt.Sprintf("%s - %s", dt.At.In(loc).Format("Mon Jan 2 15:04 MST"), what))
+		}
+		bot.Say(strings.Join(lines, "\n"))
 	}
 	return
 }
@@ -357,6 +792,43 @@ func admin(bot *Robot, command string, args ...string) (retval TaskRetVal) {
 		} else {
 			bot.Say(fmt.Sprintf("Problem storing value: %s", ret))
 		}
+	case "memories":
+		ns := args[0]
+		keys, ret := listKeysWithPrefix(ns + ":")
+		if ret == BrainNotSupported {
+			bot.Say("Sorry, my brain doesn't support listing memories")
+			return
+		}
+		if ret != Ok {
+			bot.Say(fmt.Sprintf("There was a problem listing memories for '%s': %s", ns, ret))
+			return
+		}
+		if len(keys) == 0 {
+			bot.Say(fmt.Sprintf("I don't have any memories for namespace '%s'", ns))
+			return
+		}
+		bot.Fixed().Say(fmt.Sprintf("Memories for namespace '%s':\n%s", ns, strings.Join(keys, "\n")))
+	case "forget":
+		key := args[0]
+		if key == botBrainKey {
+			bot.Say("Sorry, I can't forget my brain key; I wouldn't be able to decrypt my memories")
+			return
+		}
+		_, _, exists, ret := checkout(key, false)
+		if ret == Ok && !exists {
+			bot.Say(fmt.Sprintf("I don't have a memory for '%s'", key))
+			return
+		}
+		ret = remove(key)
+		switch ret {
+		case Ok:
+			Log(Audit, fmt.Sprintf("User '%s' removed memory '%s' from the brain", bot.User, key))
+			bot.Say(fmt.Sprintf("Ok, I've forgotten '%s'", key))
+		case BrainNotSupported:
+			bot.Say("Sorry, my brain doesn't support removing memories")
+		default:
+			bot.Say(fmt.Sprintf("There was a problem removing '%s': %s", key, ret))
+		}
 	case "abort":
 		buf := make([]byte, 32768)
 		runtime.Stack(buf, true)
@@ -404,6 +876,89 @@ func admin(bot *Robot, command string, args ...string) (retval TaskRetVal) {
 		}
 		plugDebug.Unlock()
 		bot.Say("Debugging disabled")
+	case "ps":
+		robot.RLock()
+		tz := robot.timeZone
+		robot.RUnlock()
+		activeRobots.RLock()
+		ids := make([]int, 0, len(activeRobots.i))
+		for id, c := range activeRobots.i {
+			// Skip this pipeline, and contexts registered for e.g. plugin init
+			if id == bot.id || len(c.pipeName) == 0 {
+				continue
+			}
+			ids = append(ids, id)
+		}
+		sort.Ints(ids)
+		lines := make([]string, 0, len(ids)+1)
+		lines = append(lines, fmt.Sprintf("%-10s %-16s %-16s %-12s %-12s %s", "RUN ID", "PIPELINE", "TASK", "USER", "CHANNEL", "STARTED"))
+		for _, id := range ids {
+			c := activeRobots.i[id]
+			c.Lock()
+			taskName := c.taskName
+			c.Unlock()
+			channel := c.Channel
+			if len(channel) == 0 {
+				channel = "(direct)"
+			}
+			start := c.startTime
+			if tz != nil {
+				start = start.In(tz)
+			}
+			lines = append(lines, fmt.Sprintf("%-10d %-16s %-16s %-12s %-12s %s", id, c.pipeName, taskName, c.User, channel, start.Format("Jan 2 15:04:05 MST")))
+		}
+		activeRobots.RUnlock()
+		if len(ids) == 0 {
+			bot.Say("There are no other pipelines running")
+			return
+		}
+		bot.Fixed().Say(strings.Join(lines, "\n"))
+	case "kill":
+		id, _ := strconv.Atoi(args[0])
+		c := getBotContextInt(id)
+		if c == nil || id == bot.id || len(c.pipeName) == 0 {
+			bot.Say(fmt.Sprintf("I don't have a running pipeline with run ID %s", args[0]))
+			return
+		}
+		c.Lock()
+		if len(c.killedBy) > 0 {
+			c.Unlock()
+			bot.Say(fmt.Sprintf("Pipeline %d was already killed by %s", id, c.killedBy))
+			return
+		}
+		c.killedBy = bot.User
+		cmd := c.osCmd
+		taskName := c.taskName
+		children := c.parallel
+		c.Unlock()
+		Log(Audit, fmt.Sprintf("User '%s' killed pipeline '%s', run ID %d, in task '%s'", bot.User, c.pipeName, id, taskName))
+		if len(children) > 0 {
+			for _, child := range children {
+				child.Lock()
+				if len(child.killedBy) == 0 {
+					child.killedBy = bot.User
+				}
+				childCmd := child.osCmd
+				child.Unlock()
+				if childCmd != nil {
+					if err := killProcGroup(childCmd); err != nil {
+						Log(Error, fmt.Sprintf("Killing parallel task in pipeline %d: %v", id, err))
+					}
+				}
+			}
+			bot.Say(fmt.Sprintf("Killed the parallel tasks in pipeline %d ('%s'), remaining tasks will be aborted", id, c.pipeName))
+			return
+		}
+		if cmd == nil {
+			bot.Say(fmt.Sprintf("Pipeline %d ('%s') isn't running an external task; remaining tasks will be aborted when task '%s' finishes", id, c.pipeName, taskName))
+			return
+		}
+		if err := killProcGroup(cmd); err != nil {
+			Log(Error, fmt.Sprintf("Killing task '%s' in pipeline %d: %v", taskName, id, err))
+			bot.Say(fmt.Sprintf("There was a problem killing task '%s'; remaining tasks in pipeline %d will be aborted", taskName, id))
+			return
+		}
+		bot.Say(fmt.Sprintf("Killed task '%s' in pipeline %d ('%s'), remaining tasks will be aborted", taskName, id, c.pipeName))
 	case "quit":
 		robot.Lock()
 		if robot.shuttingDown {
@@ -20,9 +20,92 @@ RequireAdmin: true
 Help:
 - Keywords: [ "initialize", "key", "brain" ]
   Helptext: [ "(bot), initialize brain <key> - by direct message only; provide brain encryption key" ]
+- Keywords: [ "rekey", "key", "brain", "passphrase" ]
+  Helptext: [ "(bot), rekey brain <newkey> - by direct message only; change the key that unlocks the brain" ]
+- Keywords: [ "convert", "encrypt", "brain" ]
+  Helptext: [ "(bot), convert brain - by direct message only; encrypt all memories after enabling EncryptBrain" ]
+- Keywords: [ "import", "brain", "migrate", "directory" ]
+  Helptext: [ "(bot), import brain <directory> - by direct message only; copy memories from a 'file' brain BrainDirectory" ]
 CommandMatchers:
 - Command: initialize
   Regex: '(?i:initialize brain (.*))'
+- Command: import
+  Regex: '(?i:import brain (?:from )?(.+))'
+- Command: rekey
+  Regex: '(?i:re-?key brain (.*))'
+- Command: convert
+  Regex: '(?i:(?:convert|encrypt) brain)'
+`
+
+const jobsConfig = `
+AllChannels: true
+AllowDirect: true
+Help:
+- Keywords: [ "run", "job", "jobs", "delay", "later" ]
+  Helptext: [ "(bot), (<when>) run job <jobname> (<param>=<value> ...) - start a job now or later, e.g. 'in 20 minutes run job backup'; prompts for any missing required parameters" ]
+CommandMatchers:
+- Command: "run"
+  Regex: '(?i:run job ([\w-]+)(?: (.*))?)'
+- Command: "delay"
+  Regex: '(?i:((?:in|at|on|today|tomorrow)\b.*?) run job ([\w-]+)(?: (.*))?)'
+ReplyMatchers:
+- Label: paramValue
+  Regex: '(.+)'
+`
+
+const schedulesConfig = `
+AllChannels: true
+AllowDirect: true
+RequireAdmin: true
+Help:
+- Keywords: [ "list", "schedule", "schedules", "job", "jobs" ]
+  Helptext: [ "(bot), list schedules - list scheduled tasks with the next time each will run" ]
+- Keywords: [ "pause", "resume", "schedule", "schedules" ]
+  Helptext: [ "(bot), pause|resume schedule <task> - stop or restart running a task on its schedules" ]
+- Keywords: [ "schedule", "schedules", "cron" ]
+  Helptext: [ "(bot), schedule <task> \"<cronspec>\" (<command> <args> | <param>=<value> ...) - add a schedule for a plugin command or job" ]
+- Keywords: [ "unschedule", "schedule", "schedules", "remove" ]
+  Helptext: [ "(bot), unschedule <task> - remove the schedules added for a task with 'schedule'" ]
+CommandMatchers:
+- Command: "list"
+  Regex: '(?i:list schedules?)'
+- Command: "pause"
+  Regex: '(?i:pause schedules?(?: for)? ([\w-]+))'
+- Command: "resume"
+  Regex: '(?i:resume schedules?(?: for)? ([\w-]+))'
+- Command: "schedule"
+  Regex: '(?i:schedule ([\w-]+) "([^"]+)"(?: (.*))?)'
+- Command: "unschedule"
+  Regex: '(?i:unschedule ([\w-]+))'
+`
+
+const remindersConfig = `
+AllChannels: true
+AllowDirect: true
+Help:
+- Keywords: [ "remind", "reminder", "reminders", "list" ]
+  Helptext: [ "(bot), remind me <when> to <something> | list reminders - e.g. 'remind me tomorrow at 9am to call Bob'; list shows your reminders and delayed tasks" ]
+CommandMatchers:
+- Command: "remind"
+  Regex: '(?i:remind me (.+?) to (.+))'
+- Command: "list"
+  Regex: '(?i:list (?:my )?reminders)'
+`
+
+const historiesConfig = `
+AllChannels: true
+AllowDirect: true
+RequireAdmin: true
+Help:
+- Keywords: [ "history", "histories", "list", "job", "jobs" ]
+  Helptext: [ "(bot), list history <job|plugin> - list the remembered runs of a job or plugin" ]
+- Keywords: [ "history", "histories", "show", "log", "logs" ]
+  Helptext: [ "(bot), show history <job|plugin> <run> (page X) - display the last or Xth previous page of output from a run" ]
+CommandMatchers:
+- Command: "list"
+  Regex: '(?i:list histor(?:y|ies)(?: for)? ([\w-]+))'
+- Command: "show"
+  Regex: '(?i:show history(?: for)? ([\w-]+) (\d+)(?: page (\d+))?)'
 `
 
 const adminConfig = `
@@ -42,11 +125,23 @@ Help:
   Helptext: [ "(bot), stop debugging - turn off debugging" ]
 - Keywords: [ "store", "parameter", "environment" ]
   Helptext: [ "(bot), store parameter <namespace> <var>=<value> - store parameter for <namespace> in brain"]
+- Keywords: [ "list", "memories", "memory", "brain", "namespace" ]
+  Helptext: [ "(bot), list memories <namespace> - list the keys stored in the brain for <namespace>" ]
+- Keywords: [ "forget", "memory", "brain", "delete" ]
+  Helptext: [ "(bot), forget <key> - remove a memory from the brain; use a full key from 'list memories'" ]
+- Keywords: [ "ps", "list", "pipeline", "pipelines", "running" ]
+  Helptext: [ "(bot), ps | list pipelines - list running pipelines with their run IDs" ]
+- Keywords: [ "kill", "pipeline", "cancel" ]
+  Helptext: [ "(bot), kill <runid> - kill the current task of a running pipeline and abort remaining tasks" ]
 CommandMatchers:
 - Command: reload
   Regex: '(?i:reload)'
 - Command: store
   Regex: '(?i:store parameter ([\w]+) ([\w-]+)=(.*))'
+- Command: memories
+  Regex: '(?i:list memories(?: for)? ([\w-]+))'
+- Command: forget
+  Regex: '(?i:forget ([\w-]+:[\w:-]+))'
 - Command: quit
   Regex: '(?i:quit|exit)'
 - Command: abort
@@ -55,6 +150,10 @@ CommandMatchers:
   Regex: '(?i:debug (?:plugin )?([\d\w-.]+)(?: (verbose))?)'
 - Command: "stop"
   Regex: '(?i:stop debugging)'
+- Command: "ps"
+  Regex: '(?i:ps|list (?:running )?pipelines)'
+- Command: "kill"
+  Regex: '(?i:kill (?:pipeline )?(\d+))'
 `
 
 const dumpConfig = `
@@ -14,38 +14,55 @@ import (
 
 /* conf.go - methods and types for reading and storing json configuration */
 
-var protocolConfig, brainConfig, historyConfig json.RawMessage
+var brainConfig, historyConfig json.RawMessage
+
+// Protocol-specific configuration for each connector, by protocol name
+var protocolConfigs map[string]json.RawMessage
+
+// protocolSpec configures an additional connector, for robots serving more
+// than one protocol
+type protocolSpec struct {
+	Protocol       string          // Name of the connector protocol, e.g. "irc"
+	ProtocolConfig json.RawMessage // Protocol-specific configuration
+	JoinChannels   []string        // Channels to join for this protocol
+}
 
 // botconf specifies 'bot configuration, and is read from $GOPHER_CONFIGDIR/conf/gopherbot.yaml
 type botconf struct {
-	AdminContact         string           // Contact info for whomever administers the robot
-	Email                string           // From: address when the robot wants to send an email
-	MailConfig           botMailer        // configuration for sending email
-	Protocol             string           // Name of the connector protocol to use, e.g. "slack"
-	ProtocolConfig       json.RawMessage  // Protocol-specific configuration, type for unmarshalling arbitrary config
-	Brain                string           // Type of Brain to use
-	BrainConfig          json.RawMessage  // Brain-specific configuration, type for unmarshalling arbitrary config
-	EncryptBrain         bool             // Whether the brain should be encrypted
-	BrainKey             string           // used to decrypt the brainKey
-	HistoryProvider      string           // Name of provider to use for storing and retrieving job/plugin histories
-	HistoryConfig        json.RawMessage  // History provider specific configuration
-	DefaultElevator      string           // Elevator plugin to use by default for ElevatedCommands and ElevateImmediateCommands
-	DefaultAuthorizer    string           // Authorizer plugin to use by default for AuthorizedCommands, or when AuthorizeAllCommands = true
-	DefaultMessageFormat string           // How the robot should format outgoing messages unless told otherwise; default: Raw
-	Name                 string           // Name of the 'bot, specify here if the protocol doesn't supply it (slack does)
-	DefaultAllowDirect   bool             // Whether plugins are available in a DM by default
-	DefaultChannels      []string         // Channels where plugins are active by default, e.g. [ "general", "random" ]
-	IgnoreUsers          []string         // Users the 'bot never talks to - like other bots
-	JoinChannels         []string         // Channels the 'bot should join when it logs in (not supported by all protocols)
-	DefaultJobChannel    string           // Where job status is posted by default
-	TimeZone             string           // For evaluating the hour in a job schedule
-	ExternalJobs         []externalJob    // list of available jobs; config in conf/jobs/<jobname>.yaml
-	ScheduledTasks       []scheduledTask  // see tasks.go
-	ExternalPlugins      []externalPlugin // List of non-Go plugins to load; config in conf/plugins/<plugname>.yaml
-	AdminUsers           []string         // List of users who can access administrative commands
-	Alias                string           // One-character alias for commands directed at the 'bot, e.g. ';open the pod bay doors'
-	LocalPort            int              // Port number for listening on localhost, for CLI plugins
-	LogLevel             string           // Initial log level, can be modified by plugins. One of "trace" "debug" "info" "warn" "error"
+	AdminContact         string            // Contact info for whomever administers the robot
+	Email                string            // From: address when the robot wants to send an email
+	MailConfig           botMailer         // configuration for sending email
+	Protocol             string            // Name of the connector protocol to use, e.g. "slack"
+	ProtocolConfig       json.RawMessage   // Protocol-specific configuration, type for unmarshalling arbitrary config
+	Protocols            []protocolSpec    // Additional connectors, for serving more than one protocol at once
+	Brain                string            // Type of Brain to use
+	BrainConfig          json.RawMessage   // Brain-specific configuration, type for unmarshalling arbitrary config
+	EncryptBrain         bool              // Whether the brain should be encrypted
+	BrainKey             string            // used to decrypt the brainKey
+	HistoryProvider      string            // Name of provider to use for storing and retrieving job/plugin histories
+	HistoryConfig        json.RawMessage   // History provider specific configuration
+	DefaultElevator      string            // Elevator plugin to use by default for ElevatedCommands and ElevateImmediateCommands
+	DefaultAuthorizer    string            // Authorizer plugin to use by default for AuthorizedCommands, or when AuthorizeAllCommands = true
+	DefaultMessageFormat string            // How the robot should format outgoing messages unless told otherwise; default: Raw
+	Name                 string            // Name of the 'bot, specify here if the protocol doesn't supply it (slack does)
+	DefaultAllowDirect   bool              // Whether plugins are available in a DM by default
+	DefaultChannels      []string          // Channels where plugins are active by default, e.g. [ "general", "random" ]
+	IgnoreUsers          []string          // Users the 'bot never talks to - like other bots
+	JoinChannels         []string          // Channels the 'bot should join when it logs in (not supported by all protocols)
+	DefaultJobChannel    string            // Where job status is posted by default
+	TimeZone             string            // For evaluating the hour in a job schedule
+	DefaultTaskTimeout   string            // Maximum run time for external tasks without a Timeout, e.g. "30m"; default is no timeout
+	ReplyTimeout         string            // How long to wait for a reply to a prompt, e.g. "5m"; default 45s
+	ExternalJobs         []externalJob     // list of available jobs; config in conf/jobs/<jobname>.yaml
+	ScheduledTasks       []scheduledTask   // see tasks.go
+	ExternalPlugins      []externalPlugin  // List of non-Go plugins to load; config in conf/plugins/<plugname>.yaml
+	AdminUsers           []string          // List of users who can access administrative commands
+	UserRoster           []rosterUser      // Attributes for users, supplementing the protocol's, see userroster.go
+	Alias                string            // One-character alias for commands directed at the 'bot, e.g. ';open the pod bay doors'
+	LocalPort            int               // Port number for listening on localhost, for CLI plugins
+	WebhookAddress       string            // Address for the webhook listener, e.g. ":8088"; webhooks are disabled if empty
+	Webhooks             []webhookEndpoint // Webhook endpoints that start jobs, see webhooks.go
+	LogLevel             string            // Initial log level, can be modified by plugins. One of "trace" "debug" "info" "warn" "error"
 }
 
 // Protects the bot config
@@ -142,13 +159,16 @@ func (r *botContext) loadConfig(preConnect bool) error {
 		var epval []externalPlugin
 		var jval []externalJob
 		var stval []scheduledTask
+		var whval []webhookEndpoint
+		var prval []protocolSpec
+		var urval []rosterUser
 		var mailval botMailer
 		var boolval bool
 		var intval int
 		var val interface{}
 		skip := false
 		switch key {
-		case "AdminContact", "Email", "Protocol", "Brain", "BrainKey", "HistoryProvider", "DefaultJobChannel", "DefaultElevator", "DefaultAuthorizer", "DefaultMessageFormat", "Name", "Alias", "LogLevel", "TimeZone":
+		case "AdminContact", "Email", "Protocol", "Brain", "BrainKey", "HistoryProvider", "DefaultJobChannel", "DefaultElevator", "DefaultAuthorizer", "DefaultMessageFormat", "Name", "Alias", "LogLevel", "TimeZone", "DefaultTaskTimeout", "ReplyTimeout", "WebhookAddress":
 			val = &strval
 		case "DefaultAllowDirect", "EncryptBrain":
 			val = &boolval
@@ -162,6 +182,12 @@ func (r *botContext) loadConfig(preConnect bool) error {
 			val = &jval
 		case "ScheduledTasks":
 			val = &stval
+		case "Webhooks":
+			val = &whval
+		case "Protocols":
+			val = &prval
+		case "UserRoster":
+			val = &urval
 		case "DefaultChannels", "IgnoreUsers", "JoinChannels", "AdminUsers":
 			val = &sarrval
 		case "MailConfig":
@@ -191,6 +217,8 @@ func (r *botContext) loadConfig(preConnect bool) error {
 			newconfig.Protocol = *(val.(*string))
 		case "ProtocolConfig":
 			newconfig.ProtocolConfig = value
+		case "Protocols":
+			newconfig.Protocols = *(val.(*[]protocolSpec))
 		case "Brain":
 			newconfig.Brain = *(val.(*string))
 		case "BrainKey":
@@ -230,6 +258,8 @@ func (r *botContext) loadConfig(preConnect bool) error {
 			newconfig.ScheduledTasks = *(val.(*[]scheduledTask))
 		case "AdminUsers":
 			newconfig.AdminUsers = *(val.(*[]string))
+		case "UserRoster":
+			newconfig.UserRoster = *(val.(*[]rosterUser))
 		case "Alias":
 			newconfig.Alias = *(val.(*string))
 		case "LocalPort":
@@ -238,6 +268,14 @@ func (r *botContext) loadConfig(preConnect bool) error {
 			newconfig.LogLevel = *(val.(*string))
 		case "TimeZone":
 			newconfig.TimeZone = *(val.(*string))
+		case "DefaultTaskTimeout":
+			newconfig.DefaultTaskTimeout = *(val.(*string))
+		case "ReplyTimeout":
+			newconfig.ReplyTimeout = *(val.(*string))
+		case "WebhookAddress":
+			newconfig.WebhookAddress = *(val.(*string))
+		case "Webhooks":
+			newconfig.Webhooks = *(val.(*[]webhookEndpoint))
 		}
 	}
 
@@ -284,6 +322,26 @@ func (r *botContext) loadConfig(preConnect bool) error {
 		}
 	}
 
+	robot.defaultTaskTimeout = 0
+	if newconfig.DefaultTaskTimeout != "" {
+		timeout, err := time.ParseDuration(newconfig.DefaultTaskTimeout)
+		if err == nil && timeout > 0 {
+			robot.defaultTaskTimeout = timeout
+		} else {
+			Log(Error, fmt.Sprintf("Invalid DefaultTaskTimeout '%s', external tasks will run without a default timeout", newconfig.DefaultTaskTimeout))
+		}
+	}
+
+	robot.replyTimeout = defaultReplyTimeout
+	if newconfig.ReplyTimeout != "" {
+		timeout, err := time.ParseDuration(newconfig.ReplyTimeout)
+		if err == nil && timeout > 0 {
+			robot.replyTimeout = timeout
+		} else {
+			Log(Error, fmt.Sprintf("Invalid ReplyTimeout '%s', using the default of %s", newconfig.ReplyTimeout, defaultReplyTimeout))
+		}
+	}
+
 	if newconfig.Email != "" {
 		robot.email = newconfig.Email
 	}
@@ -339,27 +397,53 @@ func (r *botContext) loadConfig(preConnect bool) error {
 			if len(s.Name) == 0 || len(s.Schedule) == 0 {
 				Log(Error, fmt.Sprintf("Zero-length Name (%s) or Schedule (%s) in ScheduledTask, skipping", s.Name, s.Schedule))
 			} else {
+				s.Overlap = strings.ToLower(s.Overlap)
+				switch s.Overlap {
+				case "", overlapAllow, overlapSkip, overlapQueue:
+				default:
+					Log(Error, fmt.Sprintf("Invalid Overlap '%s' for ScheduledTask '%s', using '%s'", s.Overlap, s.Name, overlapAllow))
+					s.Overlap = overlapAllow
+				}
 				st = append(st, s)
 			}
 		}
 		robot.scheduledTasks = st
 	}
+	robot.webhooks = checkWebhooks(newconfig.Webhooks)
+	// Secrets are kept in robot.webhooks; don't dump them with the config
+	for i := range newconfig.Webhooks {
+		newconfig.Webhooks[i].Secret = "XXXXXX"
+	}
 	if newconfig.IgnoreUsers != nil {
 		robot.ignoreUsers = newconfig.IgnoreUsers
 	}
+	robot.userRoster = loadRoster(newconfig.UserRoster)
 	if newconfig.JoinChannels != nil {
 		robot.joinChannels = newconfig.JoinChannels
 	}
 
 	// Items only read at start-up, before multi-threaded
 	if preConnect {
+		// The primary protocol is Protocol, or the first of Protocols
+		protocols := newconfig.Protocols
 		if newconfig.Protocol != "" {
-			robot.protocol = newconfig.Protocol
-		} else {
+			protocols = append([]protocolSpec{{
+				Protocol:       newconfig.Protocol,
+				ProtocolConfig: newconfig.ProtocolConfig,
+			}}, protocols...)
+		}
+		if len(protocols) == 0 {
 			return fmt.Errorf("Protocol not specified in gopherbot.yaml")
 		}
-		if newconfig.ProtocolConfig != nil {
-			protocolConfig = newconfig.ProtocolConfig
+		robot.protocol = protocols[0].Protocol
+		robot.protocols = []protocolSpec{}
+		protocolConfigs = make(map[string]json.RawMessage)
+		for _, p := range protocols {
+			if _, exists := protocolConfigs[p.Protocol]; exists || len(p.Protocol) == 0 {
+				return fmt.Errorf("Empty or duplicate protocol '%s' in gopherbot.yaml", p.Protocol)
+			}
+			protocolConfigs[p.Protocol] = p.ProtocolConfig
+			robot.protocols = append(robot.protocols, p)
 		}
 		if newconfig.EncryptBrain {
 			encryptBrain = true
@@ -378,6 +462,9 @@ func (r *botContext) loadConfig(preConnect bool) error {
 		} else {
 			Log(Error, "LocalPort not defined, not exporting GOPHER_HTTP_POST and external tasks will be broken")
 		}
+		if newconfig.WebhookAddress != "" {
+			robot.webhookAddress = newconfig.WebhookAddress
+		}
 		if newconfig.HistoryProvider != "" {
 			robot.historyProvider = newconfig.HistoryProvider
 		}
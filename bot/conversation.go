@@ -0,0 +1,79 @@
+package bot
+
+import "fmt"
+
+/* conversation.go - a helper for plugins that ask the user a series of
+questions, wizard-style, keeping the answers as they go. */
+
+// conversationRetries is how many times Ask re-asks after a reply that
+// doesn't match
+const conversationRetries = 2
+
+// A Conversation keeps the answers for a multi-step exchange with a user,
+// such as a wizard that asks for several values in turn. Answers are stored
+// by name, and a reply of '=' keeps the current answer, so a plugin can
+// supply defaults with Set, or loop back and ask a question again.
+type Conversation struct {
+	r       *Robot
+	answers map[string]string
+}
+
+// Conversation starts a conversation with the user in the Robot's channel
+// (or thread); prompts use the Robot's message format and reply timeout.
+func (r *Robot) Conversation() *Conversation {
+	return &Conversation{
+		r:       r,
+		answers: make(map[string]string),
+	}
+}
+
+// Ask prompts the user for a reply matching regexID, as for PromptForReply,
+// and stores it as the answer for name. When there's already an answer for
+// name, the prompt offers it as the default. Replies that don't match are
+// re-asked a couple of times, and RetryPrompt is handled internally. Ask
+// returns Ok, or the RetVal that ended the conversation, e.g. Interrupted or
+// TimeoutExpired.
+func (c *Conversation) Ask(name, regexID, prompt string) RetVal {
+	current, hasDefault := c.answers[name]
+	if hasDefault {
+		prompt = fmt.Sprintf("%s (or '=' for '%s')", prompt, current)
+	}
+	for i := 0; ; i++ {
+		rep, ret := c.r.PromptForReply(regexID, prompt)
+		switch ret {
+		case Ok:
+			c.answers[name] = rep
+			return Ok
+		case UseDefaultValue:
+			if hasDefault {
+				return Ok
+			}
+		case ReplyNotMatched:
+		default:
+			return ret
+		}
+		if i == conversationRetries {
+			return ReplyNotMatched
+		}
+		c.r.Reply("Sorry, I didn't understand that - try again, or reply '-' to cancel")
+	}
+}
+
+// Get returns the answer for name, or "" if there isn't one.
+func (c *Conversation) Get(name string) string {
+	return c.answers[name]
+}
+
+// Set stores an answer for name, e.g. to supply a default before Ask.
+func (c *Conversation) Set(name, value string) {
+	c.answers[name] = value
+}
+
+// Answers returns a copy of all the answers so far.
+func (c *Conversation) Answers() map[string]string {
+	answers := make(map[string]string, len(c.answers))
+	for name, value := range c.answers {
+		answers[name] = value
+	}
+	return answers
+}
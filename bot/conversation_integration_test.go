@@ -0,0 +1,36 @@
+// +build integration
+
+package bot_test
+
+/* conversation_integration_test.go - tests for reply timeouts and
+wizard-style conversations, using commands from plugins/samples/test.sh.
+*/
+
+import (
+	"testing"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+func TestConversation(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		// Answers are kept as the conversation goes; unmatched replies are
+		// asked again, and '=' keeps the default
+		{carol, general, ";wizard", []testc.TestMessage{{carol, general, `What's your name\?`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		{carol, general, "{carol}", []testc.TestMessage{{carol, general, `Sorry, I didn't understand that`}, {carol, general, `What's your name\?`}}, []Event{}, 0},
+		{carol, general, "Carol", []testc.TestMessage{{carol, general, `What's your favorite color\? \(or '=' for 'blue'\)`}}, []Event{}, 0},
+		{carol, general, "=", []testc.TestMessage{{null, general, `Carol likes blue`}}, []Event{}, 0},
+		// A short reply timeout set by the task
+		{carol, general, ";quick", []testc.TestMessage{{carol, general, `Quick - yes or no\?`}, {null, general, `Too slow!`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		// A prompt is canceled when the task exits without waiting for the
+		// reply, so it doesn't swallow the user's next message
+		{carol, general, ";abandon", []testc.TestMessage{{carol, general, `Are you still there\?`}, {null, general, `Never mind`}}, []Event{CommandTaskRan, ScriptTaskRan}, 500},
+		{carol, general, "hello robot", []testc.TestMessage{{null, general, "Hello, World!"}}, []Event{AmbientTaskRan, ScriptTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
@@ -0,0 +1,398 @@
+package bot
+
+/* delayedtasks.go - one-shot tasks and reminders, scheduled with
+Robot.ScheduleTask or the 'remind me' and 'in ... run job' builtins. Delayed
+tasks are stored in the brain so they survive a restart; any that came due
+while the robot was down run at start-up.
+*/
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// delayedTask is a job, plugin command or reminder to run once at a given
+// time, in the channel (and thread) it was scheduled from.
+type delayedTask struct {
+	ID       int
+	At       time.Time
+	User     string
+	Channel  string
+	Protocol string
+	ThreadID string
+	Reminder string // text to remind the user of; when set, no task is run
+	taskSpec
+}
+
+// storedDelayedTasks is the datum stored in the brain
+type storedDelayedTasks struct {
+	NextID int
+	Tasks  []delayedTask
+}
+
+// delayedLock protects delayedTimers, the timers for pending delayed tasks
+// indexed by ID.
+var delayedLock sync.Mutex
+var delayedTimers = make(map[int]*time.Timer)
+
+// ScheduleTask schedules a job or plugin to run once at a later time, as the
+// same user and in the same channel as the current pipeline. When the task is
+// a plugin, cmdargs should be a command followed by arguments; for a job,
+// cmdargs are parameters in the form NAME=value. Scheduled tasks are stored
+// in the brain; authorization and elevation are checked when the task is
+// scheduled, since the user isn't around when it runs.
+func (r *Robot) ScheduleTask(at time.Time, name string, cmdargs ...string) RetVal {
+	c := r.getContext()
+	ts, ret := c.tasks.newTaskSpec(name, cmdargs)
+	if ret != Ok {
+		return ret
+	}
+	if !c.checkTaskSecurity(r, ts.task, ts.command(), ts.Arguments, false) {
+		return TaskNotAuthorized
+	}
+	dt := delayedTask{
+		At:       at,
+		User:     r.User,
+		Channel:  r.Channel,
+		Protocol: r.protocol,
+		taskSpec: ts,
+	}
+	if r.ThreadedMessage {
+		dt.ThreadID = r.ThreadID
+	}
+	_, ret = addDelayedTask(dt)
+	return ret
+}
+
+// addDelayedTask stores a new delayed task in the brain and starts a timer
+// for it.
+func addDelayedTask(dt delayedTask) (delayedTask, RetVal) {
+	var stored storedDelayedTasks
+	tok, _, ret := checkoutDatum(delayedTasksKey, &stored, true)
+	if ret != Ok {
+		return dt, ret
+	}
+	stored.NextID++
+	dt.ID = stored.NextID
+	stored.Tasks = append(stored.Tasks, dt)
+	if ret = updateDatum(delayedTasksKey, tok, stored); ret != Ok {
+		return dt, ret
+	}
+	if len(dt.Reminder) > 0 {
+		Log(Info, fmt.Sprintf("Scheduled reminder #%d for user '%s' at %s", dt.ID, dt.User, dt.At))
+	} else {
+		Log(Info, fmt.Sprintf("Scheduled task '%s' (#%d) for user '%s' at %s", dt.Name, dt.ID, dt.User, dt.At))
+	}
+	startDelayedTimer(dt)
+	return dt, Ok
+}
+
+// startDelayedTimer starts the timer for a delayed task; tasks already due
+// run right away.
+func startDelayedTimer(dt delayedTask) {
+	wait := time.Until(dt.At)
+	if wait < 0 {
+		wait = 0
+	}
+	id := dt.ID
+	delayedLock.Lock()
+	delayedTimers[id] = time.AfterFunc(wait, func() { runDelayedTask(id) })
+	delayedLock.Unlock()
+}
+
+// loadDelayedTasks is called at start-up to start timers for the delayed
+// tasks stored in the brain.
+func loadDelayedTasks() {
+	var stored storedDelayedTasks
+	_, _, ret := checkoutDatum(delayedTasksKey, &stored, false)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Unable to retrieve delayed tasks: %s", ret))
+		return
+	}
+	for _, dt := range stored.Tasks {
+		startDelayedTimer(dt)
+	}
+	if len(stored.Tasks) > 0 {
+		Log(Info, fmt.Sprintf("Loaded %d delayed tasks", len(stored.Tasks)))
+	}
+}
+
+// stopDelayedTasks stops the timers for delayed tasks when the robot shuts
+// down; they're still in the brain for the next start.
+func stopDelayedTasks() {
+	delayedLock.Lock()
+	for id, timer := range delayedTimers {
+		timer.Stop()
+		delete(delayedTimers, id)
+	}
+	delayedLock.Unlock()
+}
+
+// listDelayedTasks returns the pending delayed tasks for a user, in the
+// order they'll run.
+func listDelayedTasks(user string) ([]delayedTask, RetVal) {
+	var stored storedDelayedTasks
+	_, _, ret := checkoutDatum(delayedTasksKey, &stored, false)
+	if ret != Ok {
+		return nil, ret
+	}
+	tasks := make([]delayedTask, 0, len(stored.Tasks))
+	for _, dt := range stored.Tasks {
+		if dt.User == user {
+			tasks = append(tasks, dt)
+		}
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].At.Before(tasks[j].At) })
+	return tasks, Ok
+}
+
+// runDelayedTask removes a delayed task from the brain, then sends the
+// reminder or runs the task.
+func runDelayedTask(id int) {
+	delayedLock.Lock()
+	delete(delayedTimers, id)
+	delayedLock.Unlock()
+	var stored storedDelayedTasks
+	tok, _, ret := checkoutDatum(delayedTasksKey, &stored, true)
+	if ret != Ok {
+		Log(Error, fmt.Sprintf("Unable to check out delayed tasks to run #%d: %s", id, ret))
+		return
+	}
+	var dt delayedTask
+	found := false
+	remaining := make([]delayedTask, 0, len(stored.Tasks))
+	for _, t := range stored.Tasks {
+		if t.ID == id {
+			dt, found = t, true
+		} else {
+			remaining = append(remaining, t)
+		}
+	}
+	if !found {
+		checkinDatum(delayedTasksKey, tok)
+		return
+	}
+	stored.Tasks = remaining
+	if ret := updateDatum(delayedTasksKey, tok, stored); ret != Ok {
+		Log(Error, fmt.Sprintf("Unable to remove delayed task #%d from the brain, not running: %s", id, ret))
+		return
+	}
+	if len(dt.Reminder) > 0 {
+		sendReminder(dt)
+		return
+	}
+
+	// runPipeline will take care of registerActive()
+	bot := &botContext{
+		User:                 dt.User,
+		Channel:              dt.Channel,
+		ThreadID:             dt.ThreadID,
+		ThreadedMessage:      len(dt.ThreadID) > 0,
+		protocol:             dt.Protocol,
+		directMsg:            len(dt.Channel) == 0,
+		bypassSecurityChecks: true, // checked when the task was scheduled
+		environment:          make(map[string]string),
+	}
+	// The pipeline gets the current task maps; the lock isn't copied
+	currentTasks.RLock()
+	bot.tasks.t = currentTasks.t
+	bot.tasks.nameMap = currentTasks.nameMap
+	bot.tasks.idMap = currentTasks.idMap
+	bot.tasks.nameSpaces = currentTasks.nameSpaces
+	currentTasks.RUnlock()
+	t := bot.tasks.getTaskByName(dt.Name)
+	if t == nil {
+		Log(Error, fmt.Sprintf("Task not found when running delayed task: %s", dt.Name))
+		return
+	}
+	task, plugin, _ := getTask(t)
+	if task.Disabled {
+		Log(Error, fmt.Sprintf("Not running disabled delayed task '%s'; reason: %s", dt.Name, task.reason))
+		return
+	}
+	isPlugin := plugin != nil
+	bot.isCommand = isPlugin
+	command := "run"
+	if isPlugin {
+		command = dt.Command
+	} else {
+		for _, p := range dt.Parameters {
+			bot.environment[p.Name] = p.Value
+		}
+	}
+	Log(Info, fmt.Sprintf("Starting delayed task '%s' (#%d) for user '%s'", dt.Name, dt.ID, dt.User))
+	bot.runPipeline(t, false, delayed, command, dt.Arguments...)
+}
+
+// sendReminder sends a reminder to the user in the channel and thread it was
+// requested from.
+func sendReminder(dt delayedTask) {
+	robot.RLock()
+	format := robot.defaultMessageFormat
+	robot.RUnlock()
+	conn := getConnector(dt.Protocol)
+	msg := "Reminder: " + dt.Reminder
+	switch {
+	case len(dt.Channel) == 0:
+		conn.SendProtocolUserMessage(dt.User, msg, format)
+	case len(dt.ThreadID) > 0:
+		conn.SendProtocolUserChannelThreadMessage(dt.User, dt.Channel, dt.ThreadID, msg, format)
+	default:
+		conn.SendProtocolUserChannelMessage(dt.User, dt.Channel, msg, format)
+	}
+}
+
+// userLocation returns the time zone for a user, from the "timezone" user
+// attribute, or the robot's TimeZone.
+func userLocation(protocol, user string) *time.Location {
+	if tzName, ret := getUserAttribute(protocol, user, "timezone"); ret == Ok && len(tzName) > 0 {
+		if loc, err := time.LoadLocation(tzName); err == nil {
+			return loc
+		}
+		Log(Warn, fmt.Sprintf("Unable to load time zone '%s' for user '%s'", tzName, user))
+	}
+	robot.RLock()
+	tz := robot.timeZone
+	robot.RUnlock()
+	if tz != nil {
+		return tz
+	}
+	return time.Local
+}
+
+var durationRe = regexp.MustCompile(`^(\d+|an?)\s*(s|secs?|seconds?|m|mins?|minutes?|h|hrs?|hours?|d|days?|w|weeks?)$`)
+var clockRe = regexp.MustCompile(`^(\d{1,2})(?::(\d\d))?\s*(am|pm)?$`)
+var dateRe = regexp.MustCompile(`^(\d{4})-(\d\d)-(\d\d)$`)
+
+// parseWhen parses a time for a reminder or delayed task, relative to now,
+// e.g.:
+//
+//	in 20 minutes, in an hour, in 1 hour and 30 minutes, in 90s
+//	at 3pm, at 15:30, at noon (today, or tomorrow if it's already past)
+//	tomorrow (9am), tomorrow at 8:30am
+//	on friday (at 4pm), on 2026-12-24 at 6pm
+func parseWhen(when string, now time.Time) (time.Time, error) {
+	when = strings.ToLower(strings.TrimSpace(when))
+	fail := fmt.Errorf("I don't understand the time '%s'; try e.g. 'in 20 minutes', 'at 3pm' or 'tomorrow at 9:30am'", when)
+	if strings.HasPrefix(when, "in ") {
+		spec := strings.TrimSpace(when[3:])
+		if d, err := time.ParseDuration(strings.Replace(spec, " ", "", -1)); err == nil && d > 0 {
+			return now.Add(d), nil
+		}
+		var total time.Duration
+		for _, part := range strings.FieldsFunc(strings.Replace(spec, " and ", ",", -1), func(r rune) bool { return r == ',' }) {
+			m := durationRe.FindStringSubmatch(strings.TrimSpace(part))
+			if m == nil {
+				return now, fail
+			}
+			n := 1
+			if m[1] != "a" && m[1] != "an" {
+				n, _ = strconv.Atoi(m[1])
+			}
+			var unit time.Duration
+			switch m[2][0] {
+			case 's':
+				unit = time.Second
+			case 'm':
+				unit = time.Minute
+			case 'h':
+				unit = time.Hour
+			case 'd':
+				unit = 24 * time.Hour
+			case 'w':
+				unit = 7 * 24 * time.Hour
+			}
+			total += time.Duration(n) * unit
+		}
+		if total == 0 {
+			return now, fail
+		}
+		return now.Add(total), nil
+	}
+
+	// Otherwise, an optional day followed by an optional time of day
+	day := now
+	dayGiven := false
+	clock := when
+	if i := strings.Index(when, " at "); i >= 0 {
+		clock = strings.TrimSpace(when[i+4:])
+		when = strings.TrimSpace(when[:i])
+	} else if strings.HasPrefix(when, "at ") {
+		clock = strings.TrimSpace(when[3:])
+		when = ""
+	} else {
+		clock = ""
+	}
+	when = strings.TrimPrefix(when, "on ")
+	switch {
+	case when == "":
+	case when == "today":
+		dayGiven = true
+	case when == "tomorrow":
+		day, dayGiven = now.AddDate(0, 0, 1), true
+	case dateRe.MatchString(when):
+		d, err := time.ParseInLocation("2006-01-02", when, now.Location())
+		if err != nil {
+			return now, fail
+		}
+		day, dayGiven = d, true
+	default:
+		found := false
+		for i := 1; i <= 7; i++ {
+			d := now.AddDate(0, 0, i)
+			if strings.ToLower(d.Weekday().String()) == when {
+				day, dayGiven, found = d, true, true
+				break
+			}
+		}
+		if !found {
+			return now, fail
+		}
+	}
+	hour, minute := 9, 0
+	switch clock {
+	case "":
+		if !dayGiven {
+			return now, fail
+		}
+	case "noon":
+		hour = 12
+	case "midnight":
+		hour = 0
+	default:
+		m := clockRe.FindStringSubmatch(clock)
+		if m == nil {
+			return now, fail
+		}
+		hour, _ = strconv.Atoi(m[1])
+		if len(m[2]) > 0 {
+			minute, _ = strconv.Atoi(m[2])
+		}
+		switch m[3] {
+		case "am":
+			if hour == 12 {
+				hour = 0
+			}
+		case "pm":
+			if hour < 12 {
+				hour += 12
+			}
+		}
+		if hour > 23 || minute > 59 {
+			return now, fail
+		}
+	}
+	at := time.Date(day.Year(), day.Month(), day.Day(), hour, minute, 0, 0, now.Location())
+	if !at.After(now) {
+		if dayGiven {
+			return now, fmt.Errorf("'%s' is in the past", at.Format("Mon Jan 2 15:04 MST"))
+		}
+		at = at.AddDate(0, 0, 1)
+	}
+	return at, nil
+}
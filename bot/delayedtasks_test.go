@@ -0,0 +1,129 @@
+package bot
+
+/* delayedtasks_test.go - tests for parsing reminder and delayed task times,
+and for delayed tasks surviving a restart.
+*/
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseWhen(t *testing.T) {
+	// A Wednesday morning
+	now := time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC)
+	at := func(month time.Month, day, hour, minute, second int) time.Time {
+		return time.Date(2026, month, day, hour, minute, second, 0, time.UTC)
+	}
+	tests := []struct {
+		when string
+		want time.Time
+		err  string // a substring of the error, when parsing fails
+	}{
+		// Durations
+		{"in 20 minutes", at(10, 14, 10, 50, 0), ""},
+		{"in 90s", at(10, 14, 10, 31, 30), ""},
+		{"in 1h30m", at(10, 14, 12, 0, 0), ""},
+		{"in an hour", at(10, 14, 11, 30, 0), ""},
+		{"in a day", at(10, 15, 10, 30, 0), ""},
+		{"in 1 hour and 30 minutes", at(10, 14, 12, 0, 0), ""},
+		{"in 2 days, 3 hrs", at(10, 16, 13, 30, 0), ""},
+		{"in 2 weeks", at(10, 28, 10, 30, 0), ""},
+		{"In 5 Mins", at(10, 14, 10, 35, 0), ""},
+		{"in 0 minutes", time.Time{}, "don't understand"},
+		{"in a while", time.Time{}, "don't understand"},
+		// Times of day, today or tomorrow if already past
+		{"at 3pm", at(10, 14, 15, 0, 0), ""},
+		{"at 15:30", at(10, 14, 15, 30, 0), ""},
+		{"at 10:45am", at(10, 14, 10, 45, 0), ""},
+		{"at noon", at(10, 14, 12, 0, 0), ""},
+		{"at 12pm", at(10, 14, 12, 0, 0), ""},
+		{"at 9am", at(10, 15, 9, 0, 0), ""},
+		{"at 10:30", at(10, 15, 10, 30, 0), ""},
+		{"at midnight", at(10, 15, 0, 0, 0), ""},
+		{"at 12am", at(10, 15, 0, 0, 0), ""},
+		{"at 24:00", time.Time{}, "don't understand"},
+		{"at 3:75pm", time.Time{}, "don't understand"},
+		{"at teatime", time.Time{}, "don't understand"},
+		// Days, with an optional time defaulting to 9am
+		{"today at 3pm", at(10, 14, 15, 0, 0), ""},
+		{"today at 9am", time.Time{}, "is in the past"},
+		{"today", time.Time{}, "is in the past"},
+		{"tomorrow", at(10, 15, 9, 0, 0), ""},
+		{"tomorrow at 8:30am", at(10, 15, 8, 30, 0), ""},
+		{"on friday", at(10, 16, 9, 0, 0), ""},
+		{"Friday at 4PM", at(10, 16, 16, 0, 0), ""},
+		{"on tuesday at noon", at(10, 20, 12, 0, 0), ""},
+		// The same weekday is next week, even if the time's still to come
+		{"on wednesday at 4pm", at(10, 21, 16, 0, 0), ""},
+		{"on 2026-12-24 at 6pm", at(12, 24, 18, 0, 0), ""},
+		{"on 2026-12-24", at(12, 24, 9, 0, 0), ""},
+		{"on 2026-01-01", time.Time{}, "is in the past"},
+		{"on 2026-13-01", time.Time{}, "don't understand"},
+		{"on someday", time.Time{}, "don't understand"},
+		{"soon", time.Time{}, "don't understand"},
+	}
+	for _, test := range tests {
+		got, err := parseWhen(test.when, now)
+		if len(test.err) > 0 {
+			if err == nil {
+				t.Errorf("parseWhen(%q) returned %s, want an error containing %q", test.when, got, test.err)
+			} else if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("parseWhen(%q) returned error %q, want one containing %q", test.when, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseWhen(%q) returned error: %v", test.when, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("parseWhen(%q) returned %s, want %s", test.when, got, test.want)
+		}
+	}
+}
+
+// delayedTimerIDs returns the IDs of the delayed tasks with running timers
+func delayedTimerIDs() map[int]bool {
+	delayedLock.Lock()
+	defer delayedLock.Unlock()
+	ids := make(map[int]bool)
+	for id := range delayedTimers {
+		ids[id] = true
+	}
+	return ids
+}
+
+func TestDelayedTasksSurviveRestart(t *testing.T) {
+	_, stop := startLockerBrain(t)
+	defer stop()
+	defer stopDelayedTasks()
+	at := time.Now().Add(time.Hour)
+	reminder, ret := addDelayedTask(delayedTask{At: at, User: "alice", Channel: "general", Reminder: "stand up"})
+	if ret != Ok {
+		t.Fatalf("Adding reminder returned %s", ret)
+	}
+	job, ret := addDelayedTask(delayedTask{At: at.Add(time.Minute), User: "alice", Channel: "general", taskSpec: taskSpec{Name: "deploy"}})
+	if ret != Ok {
+		t.Fatalf("Adding delayed task returned %s", ret)
+	}
+
+	// Shut down, then start again
+	stopDelayedTasks()
+	if ids := delayedTimerIDs(); len(ids) != 0 {
+		t.Fatalf("Timers still running after stopping delayed tasks: %v", ids)
+	}
+	loadDelayedTasks()
+	if ids := delayedTimerIDs(); len(ids) != 2 || !ids[reminder.ID] || !ids[job.ID] {
+		t.Errorf("Timers after loading delayed tasks: %v, want IDs %d and %d", ids, reminder.ID, job.ID)
+	}
+	tasks, ret := listDelayedTasks("alice")
+	if ret != Ok || len(tasks) != 2 {
+		t.Fatalf("Listing delayed tasks after loading returned %d tasks: %s", len(tasks), ret)
+	}
+	if tasks[0].Reminder != "stand up" || !tasks[0].At.Equal(at) {
+		t.Errorf("First delayed task after loading is %+v, want the reminder at %s", tasks[0], at)
+	}
+	if tasks[1].Name != "deploy" || tasks[1].Channel != "general" {
+		t.Errorf("Second delayed task after loading is %+v, want 'deploy' in general", tasks[1])
+	}
+}
@@ -8,9 +8,10 @@ import (
 const keepListeningDuration = 77 * time.Second
 
 // checkTaskMatchersAndRun checks either command matchers (for messages directed at
-// the robot), or message matchers (for ambient commands that need not be
-// directed at the robot), and calls the plugin if it matches. Note: this
-// function is called under a read lock on the 'b' struct.
+// the robot), message matchers (for ambient commands that need not be
+// directed at the robot), or job triggers (for messages from integrations), and
+// calls the plugin or job if it matches. Note: this function is called under a
+// read lock on the 'b' struct.
 func (bot *botContext) checkTaskMatchersAndRun(pipelineType pipelineType) (messageMatched bool) {
 	r := bot.makeRobot()
 	// un-needed, but more clear
@@ -25,12 +26,25 @@ func (bot *botContext) checkTaskMatchersAndRun(pipelineType pipelineType) (messa
 	var matchedMatcher InputMatcher
 	var cmdArgs []string
 	for _, t := range bot.tasks.t {
-		task, plugin, _ := getTask(t)
-		Log(Trace, fmt.Sprintf("Checking availability of task '%s' in channel '%s' for user '%s', active in %d channels (allchannels: %t)", task.name, bot.Channel, bot.User, len(task.Channels), task.AllChannels))
-		ok := bot.taskAvailable(task, false, verboseOnly)
-		if !ok {
-			Log(Trace, fmt.Sprintf("Task '%s' not available for user '%s' in channel '%s', doesn't meet criteria", task.name, bot.User, bot.Channel))
-			continue
+		task, plugin, job := getTask(t)
+		if pipelineType == jobTrigger {
+			// Triggers are normally sent by integrations, not users, so the
+			// usual availability checks don't apply; instead, a trigger only
+			// fires in the job's channel, and optionally for a specific user.
+			if job == nil || task.Disabled {
+				continue
+			}
+			if len(task.Channel) == 0 || !bot.inChannel(task.Channels[0]) {
+				Log(Trace, fmt.Sprintf("Job '%s' triggers not checked for message in channel '%s'; job channel is '%s'", task.name, bot.Channel, task.Channel))
+				continue
+			}
+		} else {
+			Log(Trace, fmt.Sprintf("Checking availability of task '%s' in channel '%s' for user '%s', active in %d channels (allchannels: %t)", task.name, bot.Channel, bot.User, len(task.Channels), task.AllChannels))
+			ok := bot.taskAvailable(task, false, verboseOnly)
+			if !ok {
+				Log(Trace, fmt.Sprintf("Task '%s' not available for user '%s' in channel '%s', doesn't meet criteria", task.name, bot.User, bot.Channel))
+				continue
+			}
 		}
 		var matchers []InputMatcher
 		var ctype string
@@ -55,10 +69,20 @@ func (bot *botContext) checkTaskMatchersAndRun(pipelineType pipelineType) (messa
 			}
 			matchers = plugin.MessageMatchers
 			ctype = "message"
+		case jobTrigger:
+			if len(job.Triggers) == 0 {
+				continue
+			}
+			matchers = job.Triggers
+			ctype = "trigger"
 		}
 		Log(Trace, fmt.Sprintf("Task '%s' is active, will check for matches", task.name))
 		bot.debug(fmt.Sprintf("Checking %d %s matchers against message: '%s'", len(matchers), ctype, bot.msg), verboseOnly)
 		for _, matcher := range matchers {
+			if pipelineType == jobTrigger && len(matcher.User) > 0 && matcher.User != bot.User {
+				Log(Trace, fmt.Sprintf("Skipping trigger '%s' for job '%s'; message from '%s' doesn't match trigger user '%s'", matcher.Regex, task.name, bot.User, matcher.User))
+				continue
+			}
 			Log(Trace, fmt.Sprintf("Checking '%s' against '%s'", bot.msg, matcher.Regex))
 			matches := matcher.re.FindAllStringSubmatch(bot.msg, -1)
 			matched := false
@@ -119,9 +143,14 @@ func (bot *botContext) checkTaskMatchersAndRun(pipelineType pipelineType) (messa
 		task, _, _ := getTask(runTask)
 		r.messageHeard()
 		matcher := matchedMatcher
+		// Admin commands for aborting the robot or killing running pipelines
+		// are still allowed when the robot is shutting down.
 		abort := false
-		if task.name == "builtInadmin" && matcher.Command == "abort" {
-			abort = true
+		if task.name == "builtInadmin" {
+			switch matcher.Command {
+			case "abort", "ps", "kill":
+				abort = true
+			}
 		}
 		robot.RLock()
 		if robot.shuttingDown && !abort {
@@ -136,7 +165,7 @@ func (bot *botContext) checkTaskMatchersAndRun(pipelineType pipelineType) (messa
 		robot.RUnlock()
 		// Check to see if user issued a new command when a reply was being
 		// waited on
-		replyMatcher := replyMatcher{bot.User, bot.Channel}
+		replyMatcher := replyMatcher{bot.protocol, bot.User, bot.Channel, bot.replyThread()}
 		replies.Lock()
 		waiters, waitingForReply := replies.m[replyMatcher]
 		if waitingForReply {
@@ -153,6 +182,18 @@ func (bot *botContext) checkTaskMatchersAndRun(pipelineType pipelineType) (messa
 		} else {
 			replies.Unlock()
 		}
+		if pipelineType == jobTrigger {
+			// Capture groups from the trigger are stored in the named
+			// parameters, and the job is started with no arguments.
+			for i, name := range matcher.Parameters {
+				if i < len(cmdArgs) {
+					bot.environment[name] = cmdArgs[i]
+				}
+			}
+			Log(Debug, fmt.Sprintf("Message from user '%s' in channel '%s' triggered job '%s'", bot.User, bot.Channel, task.name))
+			bot.runPipeline(runTask, false, jobTrigger, "run")
+			return
+		}
 		bot.runPipeline(runTask, true, pipelineType, matcher.Command, cmdArgs...)
 	}
 	return
@@ -197,7 +238,7 @@ func (bot *botContext) handleMessage() {
 	var waiters []replyWaiter
 	waitingForReply := false
 	if !messageMatched {
-		matcher := replyMatcher{bot.User, bot.Channel}
+		matcher := replyMatcher{bot.protocol, bot.User, bot.Channel, bot.replyThread()}
 		Log(Trace, fmt.Sprintf("Checking replies for matcher: %q", matcher))
 		replies.Lock()
 		waiters, waitingForReply = replies.m[matcher]
@@ -228,6 +269,10 @@ func (bot *botContext) handleMessage() {
 		// check for ambient message matches
 		messageMatched = bot.checkTaskMatchersAndRun(plugMessage)
 	}
+	// Finally, check whether the message triggers a job
+	if !messageMatched {
+		messageMatched = bot.checkTaskMatchersAndRun(jobTrigger)
+	}
 	if bot.isCommand && !messageMatched { // the robot was spoken to, but nothing matched - call catchAlls
 		robot.RLock()
 		if !robot.shuttingDown {
@@ -0,0 +1,56 @@
+package bot
+
+/* editmessage.go - editing sent messages and adding reactions. Connectors
+support these by implementing the optional MessageEditor and Reactor
+interfaces; for other connectors, updates are sent as new messages.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MessageRef is a handle for a message sent with SendEditableMessage, for
+// updating it with UpdateMessage. The ID is empty when the connector can't
+// edit messages.
+type MessageRef struct {
+	Protocol string // Name of the connector that sent the message
+	ID       string // Connector-specific ID of the message
+}
+
+// SendEditableMessage sends a message like Say, returning a reference for
+// updating it in place with UpdateMessage; e.g. for a status line in a long
+// running job.
+func (r *Robot) SendEditableMessage(msg string) (*MessageRef, RetVal) {
+	protocol, channel, thread := r.richDestination()
+	ref := &MessageRef{Protocol: protocol}
+	if editor, ok := getConnector(protocol).(MessageEditor); ok {
+		id, ret := editor.SendProtocolEditableMessage(r.User, channel, thread, msg, r.Format)
+		ref.ID = id
+		return ref, ret
+	}
+	return ref, r.Say(msg)
+}
+
+// UpdateMessage replaces the text of a message sent with SendEditableMessage.
+// If the connector can't edit messages, the text is sent as a new message.
+func (r *Robot) UpdateMessage(ref *MessageRef, msg string) RetVal {
+	if ref != nil && len(ref.ID) > 0 {
+		if editor, ok := getConnector(ref.Protocol).(MessageEditor); ok {
+			return editor.UpdateProtocolMessage(ref.ID, msg, r.Format)
+		}
+	}
+	return r.Say(msg)
+}
+
+// React adds an emoji reaction, e.g. "thumbsup" or ":tada:", to the message
+// that triggered the task. It returns ConnectorNotSupported if the connector
+// can't add reactions, or the task wasn't started by a message.
+func (r *Robot) React(reaction string) RetVal {
+	reactor, ok := getConnector(r.protocol).(Reactor)
+	if !ok || r.RawMsg == nil {
+		Log(Debug, fmt.Sprintf("Unable to add reaction '%s' for protocol '%s'", reaction, r.protocol))
+		return ConnectorNotSupported
+	}
+	return reactor.AddProtocolReaction(r.RawMsg, strings.Trim(reaction, ":"))
+}
@@ -19,8 +19,8 @@ type botMailer struct {
 // Email provides a simple interface for sending the user an email from the
 // robot.. It relies on both the robot and the user having an email address.
 // For the robot, this can be conifigured in gopherbot.conf, Email attribute.
-// For the user, this should be provided by the chat protocol, or in
-// gopherbot.conf. (TODO: not yet implemented)
+// For the user, this should be provided by the chat protocol, or in the
+// UserRoster in gopherbot.yaml.
 // It returns an error and RetVal != 0 if there's a problem.
 func (r *Robot) Email(subject string, messageBody *bytes.Buffer) (ret RetVal) {
 	var mailFrom, botName, mailTo string
@@ -27,6 +27,10 @@ const (
 	// reduces the likelihood of an authorization plugin mistakenly exiting with a success
 	// value
 	Success = 7
+	// TimedOut indicates an external task was killed for exceeding its
+	// Timeout; it's negative so it can't be mistaken for an exit code (-1 is
+	// a process killed by a signal)
+	TimedOut TaskRetVal = -2
 )
 
 const (
@@ -96,4 +100,39 @@ const (
 	TaskNotFound
 	// MissingArguments - AddTask requires a command and args for a plugin
 	MissingArguments
+
+	/* Optional brain capabilities */
+
+	// BrainNotSupported - The configured brain doesn't support the operation, e.g. DeleteDatum
+	BrainNotSupported
+
+	/* Prompting from external tasks */
+
+	// ReplyPending - An external task's prompt is still waiting for a reply; the
+	// script library re-issues the call to keep waiting
+	ReplyPending
+
+	/* Files and attachments */
+
+	// FailedMessageSend - The connector was unable to send a file, attachment or
+	// editable message
+	FailedMessageSend
+
+	/* Message editing and reactions */
+
+	// ConnectorNotSupported - The connector doesn't support the operation, e.g.
+	// React, or there's no message for it to act on
+	ConnectorNotSupported
+
+	/* Parallel tasks */
+
+	// NotAllowedInParallel - A task in a parallel group tried to add tasks to
+	// the pipeline with e.g. AddTask
+	NotAllowedInParallel
+
+	/* ScheduleTask */
+
+	// TaskNotAuthorized - The user isn't authorized to run the task, or
+	// elevation failed, when scheduling it to run later
+	TaskNotAuthorized
 )
@@ -42,10 +42,14 @@ const (
 	TriggeredTaskRan
 	ScheduledTaskRan
 	RunJobTaskRan
+	WebhookTaskRan
+	DelayedTaskRan
 	GoPluginRan
+	GoJobRan
 	ScriptPluginBadPath
 	ScriptPluginBadInterpreter
 	ScriptTaskRan
 	ScriptPluginStderrOutput
 	ScriptPluginErrExit
+	ScriptPluginTimedOut
 )
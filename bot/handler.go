@@ -7,8 +7,11 @@ import (
 	"sync"
 )
 
-// an empty object type for passing a Handler to the connector.
-type handler struct{}
+// handler implements the Handler interface; each connector gets a handler
+// with it's protocol name, brain and history providers get an empty one.
+type handler struct {
+	protocol string // name of the connector protocol, e.g. "slack"
+}
 
 /* Handle incoming messages and other callbacks from the connector. */
 
@@ -40,16 +43,28 @@ func (h handler) GetConfigPath() string {
 	return installPath
 }
 
-// ChannelMessage accepts an incoming channel message from the connector.
+// IncomingMessage accepts an incoming channel message from the connector.
 func (h handler) IncomingMessage(channelName, userName, messageFull string, raw interface{}) {
-	Log(Trace, fmt.Sprintf("Incoming message '%s' in channel '%s'", messageFull, channelName))
+	h.IncomingThreadMessage(channelName, "", false, userName, messageFull, raw)
+}
+
+// IncomingThreadMessage accepts an incoming message from a connector for a
+// protocol with threads.
+func (h handler) IncomingThreadMessage(channelName, threadID string, threaded bool, userName, messageFull string, raw interface{}) {
+	Log(Trace, fmt.Sprintf("Incoming message '%s' in channel '%s', thread '%s'", messageFull, channelName, threadID))
 	// When command == true, the message was directed at the bot
 	isCommand := false
 	logChannel := channelName
 	var message string
 
 	robot.RLock()
-	for _, user := range robot.ignoreUsers {
+	// The robot's own name is only ignored on the protocol it's from; on
+	// another protocol, that could be a real user.
+	ignoreUsers := robot.ignoreUsers
+	if self, ok := robot.selfNames[h.protocol]; ok {
+		ignoreUsers = append([]string{self}, ignoreUsers...)
+	}
+	for _, user := range ignoreUsers {
 		if strings.EqualFold(userName, user) {
 			Log(Debug, "Ignoring user", userName)
 			bot := &botContext{User: userName}
@@ -62,6 +77,10 @@ func (h handler) IncomingMessage(channelName, userName, messageFull string, raw
 	preRegex := robot.preRegex
 	postRegex := robot.postRegex
 	bareRegex := robot.bareRegex
+	// On a secondary protocol with its own name, the robot answers to that
+	if nr, ok := robot.protocolRegexes[h.protocol]; ok {
+		preRegex, postRegex, bareRegex = nr.pre, nr.post, nr.bare
+	}
 	robot.RUnlock()
 	if preRegex != nil {
 		matches := preRegex.FindAllStringSubmatch(messageFull, -1)
@@ -77,7 +96,7 @@ func (h handler) IncomingMessage(channelName, userName, messageFull string, raw
 			message = matches[0][1] + matches[0][2]
 		}
 	}
-	if !isCommand {
+	if !isCommand && bareRegex != nil {
 		if bareRegex.MatchString(messageFull) {
 			isCommand = true
 		}
@@ -106,16 +125,19 @@ func (h handler) IncomingMessage(channelName, userName, messageFull string, raw
 	// Create the botContext and a goroutine to process the message and carry state,
 	// which may eventually run a pipeline.
 	bot := &botContext{
-		User:        userName,
-		Channel:     channelName,
-		RawMsg:      raw,
-		tasks:       tasks,
-		isCommand:   isCommand,
-		directMsg:   directMsg,
-		msg:         message,
-		environment: make(map[string]string),
+		User:            userName,
+		Channel:         channelName,
+		ThreadID:        threadID,
+		ThreadedMessage: threaded,
+		RawMsg:          raw,
+		protocol:        h.protocol,
+		tasks:           tasks,
+		isCommand:       isCommand,
+		directMsg:       directMsg,
+		msg:             message,
+		environment:     make(map[string]string),
 	}
-	Log(Debug, fmt.Sprintf("Message '%s' from user '%s' in channel '%s'; isCommand: %t", message, userName, logChannel, isCommand))
+	Log(Debug, fmt.Sprintf("Message '%s' from user '%s' in channel '%s' (%s); isCommand: %t", message, userName, logChannel, h.protocol, isCommand))
 	bot.debug(fmt.Sprintf("Message (command: %v) in channel %s: %s", isCommand, logChannel, message), true)
 	go bot.handleMessage()
 }
@@ -123,7 +145,7 @@ func (h handler) IncomingMessage(channelName, userName, messageFull string, raw
 // GetProtocolConfig unmarshals the connector's configuration data into a provided struct
 func (h handler) GetProtocolConfig(v interface{}) error {
 	robot.RLock()
-	err := json.Unmarshal(protocolConfig, v)
+	err := json.Unmarshal(protocolConfigs[h.protocol], v)
 	robot.RUnlock()
 	return err
 }
@@ -149,9 +171,21 @@ func (h handler) Log(l LogLevel, v ...interface{}) {
 	Log(l, v...)
 }
 
+// isPrimary reports whether the handler is for the primary protocol, which
+// sets the robot's name and full name
+func (h handler) isPrimary() bool {
+	robot.RLock()
+	defer robot.RUnlock()
+	return h.protocol == robot.protocol
+}
+
 // Connectors that support it can call SetFullName; otherwise it can
 // be configured in gopherbot.yaml.
 func (h handler) SetFullName(n string) {
+	if !h.isPrimary() {
+		Log(Debug, fmt.Sprintf("Ignoring full name '%s' from secondary protocol '%s'", n, h.protocol))
+		return
+	}
 	Log(Debug, "Setting full name to: "+n)
 	robot.Lock()
 	robot.fullName = n
@@ -159,22 +193,26 @@ func (h handler) SetFullName(n string) {
 }
 
 // Connectors that support it can call SetName; otherwise it should
-// be configured in gobot.conf.
+// be configured in gobot.conf. A name from a secondary protocol is what
+// the robot answers to on that protocol.
 func (h handler) SetName(n string) {
-	Log(Debug, "Setting name to: "+n)
+	primary := h.isPrimary()
 	robot.Lock()
-	robot.name = n
-	// Make sure the robot ignores messages from it's own name
-	ignoring := false
-	for _, name := range robot.ignoreUsers {
-		if strings.EqualFold(n, name) {
-			ignoring = true
-			break
+	if primary {
+		Log(Debug, "Setting name to: "+n)
+		robot.name = n
+	} else {
+		Log(Debug, fmt.Sprintf("Setting name for protocol '%s' to: %s", h.protocol, n))
+		if robot.protocolNames == nil {
+			robot.protocolNames = make(map[string]string)
 		}
+		robot.protocolNames[h.protocol] = n
 	}
-	if !ignoring {
-		robot.ignoreUsers = append(robot.ignoreUsers, strings.ToLower(n))
+	// Make sure the robot ignores messages from it's own name
+	if robot.selfNames == nil {
+		robot.selfNames = make(map[string]string)
 	}
+	robot.selfNames[h.protocol] = n
 	robot.Unlock()
 	updateRegexes()
 }
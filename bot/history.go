@@ -3,7 +3,7 @@ package bot
 /*
 	history.go provides the mechanism and methods for storing and retrieving
 	job / plugin run histories of stdout/stderr for a given run. Each time
-	a job / plugin is initiated by a trigger, scheduled job, or user command,
+	a job / plugin is initiated by a trigger, scheduled job, webhook or user command,
 	a new history file is started if HistoryLogs is != 0 for the job/plugin.
 	The history provider will store histories up to some maximum, and return
 	that history based on the index.
@@ -13,9 +13,13 @@ import (
 	"log"
 )
 
+// historyTimeFormat is the format for CreateTime in a historyLog
+const historyTimeFormat = "Mon Jan 2 15:04:05 MST 2006"
+
 type historyLog struct {
 	LogIndex   int
 	CreateTime string
+	StartedBy  string // how the pipeline was started, e.g. "schedule" or "webhook"
 }
 
 type taskHistory struct {
@@ -39,6 +43,12 @@ type HistoryProvider interface {
 	// NewHistory provides a HistoryLogger for the given tag / index, and
 	// cleans up logs older than maxHistories.
 	NewHistory(tag string, index, maxHistories int) (HistoryLogger, error)
+	// ListHistories returns the indexes of stored histories for the given
+	// tag, in ascending order.
+	ListHistories(tag string) ([]int, error)
+	// GetHistory returns the lines of a stored history for the given tag /
+	// index.
+	GetHistory(tag string, index int) ([]string, error)
 }
 
 // Map of registered history providers
@@ -55,3 +65,26 @@ func RegisterHistoryProvider(name string, provider func(Handler) HistoryProvider
 	}
 	historyProviders[name] = provider
 }
+
+// historyPage returns a page of lines from a history, paginated like logPage;
+// p = 0 returns the last page, and p>0 goes back. If the history doesn't go
+// back that far, the first page is returned along with true.
+func historyPage(lines []string, p int) ([]string, bool) {
+	botLogger.Lock()
+	pageLines := botLogger.pageLines
+	botLogger.Unlock()
+	wrapped := false
+	end := len(lines) - p*pageLines
+	if end <= 0 && p > 0 {
+		wrapped = true
+		end = pageLines
+		if end > len(lines) {
+			end = len(lines)
+		}
+	}
+	start := end - pageLines
+	if start < 0 {
+		start = 0
+	}
+	return lines[start:end], wrapped
+}
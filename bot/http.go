@@ -6,12 +6,15 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"time"
 )
 
 type jsonFunction struct {
 	FuncName string
 	User     string
 	Channel  string
+	ThreadID string
+	Threaded bool
 	Format   string
 	Protocol string
 	CallerID string
@@ -50,6 +53,17 @@ type addtaskcall struct {
 	CmdArgs []string
 }
 
+type paralleltaskscall struct {
+	Limit int
+	Tasks [][]string // task names followed by command and args or job parameters
+}
+
+type scheduletaskcall struct {
+	At      string // RFC3339, e.g. "2006-01-02T15:04:05-07:00"
+	Name    string
+	CmdArgs []string
+}
+
 type paramcall struct {
 	Name, Value string
 }
@@ -92,11 +106,45 @@ type userchannelmessage struct {
 	Base64  bool
 }
 
+type threadmessage struct {
+	Channel string
+	Thread  string
+	Message string
+	Base64  bool
+}
+
+type userthreadmessage struct {
+	User    string
+	Channel string
+	Thread  string
+	Message string
+	Base64  bool
+}
+
+// A file to upload; Content is base64 encoded in the JSON
+type filemessage struct {
+	Name    string
+	Comment string
+	Content []byte
+}
+
+// A message to send or update with SendEditableMessage / UpdateMessage
+type editablemessage struct {
+	MessageRef
+	Message string
+	Base64  bool
+}
+
+type reaction struct {
+	Reaction string
+}
+
 type replyrequest struct {
 	RegexID string
 	User    string
 	Channel string
 	Prompt  string
+	Timeout int // seconds to wait for a reply; 0 for the task or robot default
 	Base64  bool
 }
 
@@ -138,6 +186,11 @@ type checkoutresponse struct {
 	RetVal    int
 }
 
+type messagerefresponse struct {
+	MessageRef
+	RetVal int
+}
+
 type replyresponse struct {
 	Reply  string
 	RetVal int
@@ -202,11 +255,14 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 	}
 	// Generate a synthetic Robot for access to it's methods
 	bot := Robot{
-		User:     f.User,
-		Channel:  f.Channel,
-		Protocol: setProtocol(f.Protocol),
-		RawMsg:   c.RawMsg,
-		id:       c.id,
+		User:            f.User,
+		Channel:         f.Channel,
+		ThreadID:        f.ThreadID,
+		ThreadedMessage: f.Threaded,
+		Protocol:        setProtocol(f.Protocol),
+		RawMsg:          c.RawMsg,
+		protocol:        c.protocol,
+		id:              c.id,
 	}
 	if len(f.Format) > 0 {
 		bot.Format = bot.setFormat(f.Format)
@@ -244,6 +300,44 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		ret := bot.AddTask(ts.Name, ts.CmdArgs...)
 		sendReturn(rw, &botretvalresponse{int(ret)})
 		return
+	case "AddFailTask":
+		var ts addtaskcall
+		if !getArgs(rw, &f.FuncArgs, &ts) {
+			return
+		}
+		ret := bot.AddFailTask(ts.Name, ts.CmdArgs...)
+		sendReturn(rw, &botretvalresponse{int(ret)})
+		return
+	case "AddFinalTask":
+		var ts addtaskcall
+		if !getArgs(rw, &f.FuncArgs, &ts) {
+			return
+		}
+		ret := bot.AddFinalTask(ts.Name, ts.CmdArgs...)
+		sendReturn(rw, &botretvalresponse{int(ret)})
+		return
+	case "AddParallelTasks":
+		var pt paralleltaskscall
+		if !getArgs(rw, &f.FuncArgs, &pt) {
+			return
+		}
+		ret := bot.AddParallelTasks(pt.Limit, pt.Tasks...)
+		sendReturn(rw, &botretvalresponse{int(ret)})
+		return
+	case "ScheduleTask":
+		var ts scheduletaskcall
+		if !getArgs(rw, &f.FuncArgs, &ts) {
+			return
+		}
+		at, err := time.Parse(time.RFC3339, ts.At)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Invalid time '%s' for ScheduleTask from task '%s': %v", ts.At, task.name, err))
+			sendReturn(rw, &botretvalresponse{int(MissingArguments)})
+			return
+		}
+		ret := bot.ScheduleTask(at, ts.Name, ts.CmdArgs...)
+		sendReturn(rw, &botretvalresponse{int(ret)})
+		return
 	case "SetParameter":
 		var param paramcall
 		if !getArgs(rw, &f.FuncArgs, &param) {
@@ -377,6 +471,80 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 			int(bot.SendUserChannelMessage(ucm.User, ucm.Channel, ucm.Message)),
 		})
 		return
+	case "SendThreadMessage":
+		var tm threadmessage
+		if !getArgs(rw, &f.FuncArgs, &tm) {
+			return
+		}
+		if tm.Base64 {
+			tm.Message = decode(tm.Message)
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(bot.SendThreadMessage(tm.Channel, tm.Thread, tm.Message)),
+		})
+		return
+	case "SendFile":
+		var fm filemessage
+		if !getArgs(rw, &f.FuncArgs, &fm) {
+			return
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(bot.SendFile(fm.Name, fm.Content, fm.Comment)),
+		})
+		return
+	case "SendAttachment":
+		var a Attachment
+		if !getArgs(rw, &f.FuncArgs, &a) {
+			return
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(bot.SendAttachment(&a)),
+		})
+		return
+	case "SendEditableMessage":
+		var em editablemessage
+		if !getArgs(rw, &f.FuncArgs, &em) {
+			return
+		}
+		if em.Base64 {
+			em.Message = decode(em.Message)
+		}
+		ref, ret := bot.SendEditableMessage(em.Message)
+		sendReturn(rw, &messagerefresponse{*ref, int(ret)})
+		return
+	case "UpdateMessage":
+		var em editablemessage
+		if !getArgs(rw, &f.FuncArgs, &em) {
+			return
+		}
+		if em.Base64 {
+			em.Message = decode(em.Message)
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(bot.UpdateMessage(&em.MessageRef, em.Message)),
+		})
+		return
+	case "React":
+		var rm reaction
+		if !getArgs(rw, &f.FuncArgs, &rm) {
+			return
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(bot.React(rm.Reaction)),
+		})
+		return
+	case "SendUserThreadMessage":
+		var utm userthreadmessage
+		if !getArgs(rw, &f.FuncArgs, &utm) {
+			return
+		}
+		if utm.Base64 {
+			utm.Message = decode(utm.Message)
+		}
+		sendReturn(rw, &botretvalresponse{
+			int(bot.SendUserThreadMessage(utm.User, utm.Channel, utm.Thread, utm.Message)),
+		})
+		return
 	case "SendUserMessage":
 		var um usermessage
 		if !getArgs(rw, &f.FuncArgs, &um) {
@@ -397,7 +565,10 @@ func (h handler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 		if rr.Base64 {
 			rr.Prompt = decode(rr.Prompt)
 		}
-		reply, ret = bot.promptInternal(rr.RegexID, rr.User, rr.Channel, rr.Prompt)
+		if rr.Timeout > 0 {
+			bot.replyTimeout = time.Duration(rr.Timeout) * time.Second
+		}
+		reply, ret = bot.externalPrompt(rr.RegexID, rr.User, rr.Channel, rr.Prompt)
 		sendReturn(rw, &replyresponse{reply, int(ret)})
 		return
 	// NOTE: "Say", "Reply", PromptForReply and PromptUserForReply are implemented
@@ -16,6 +16,12 @@ type Handler interface {
 	// 'raw' is the raw incoming struct from the connector; tasks are passed
 	// a Protocol value that can be used for interpreting this value.
 	IncomingMessage(channelName, userName, message string, raw interface{})
+	// IncomingThreadMessage is called instead of IncomingMessage by connectors
+	// for protocols with threads. For a message posted in a thread, threadID
+	// identifies the thread and threaded is true; for other channel messages
+	// threadID is the ID a thread started from the message would have (for
+	// Slack, the message timestamp), and threaded is false.
+	IncomingThreadMessage(channelName, threadID string, threaded bool, userName, message string, raw interface{})
 	// GetProtocolConfig unmarshals the ProtocolConfig section of gopherbot.yaml
 	// into a connector-provided struct
 	GetProtocolConfig(interface{}) error
@@ -67,6 +73,12 @@ type Connector interface {
 	SendProtocolChannelMessage(channelname, msg string, format MessageFormat) RetVal
 	// SendProtocolUserChannelMessage directs a message to a user in a channel
 	SendProtocolUserChannelMessage(user, channelname, msg string, format MessageFormat) RetVal
+	// SendProtocolChannelThreadMessage sends a message to a thread in a
+	// channel; connectors for protocols without threads send it to the channel.
+	SendProtocolChannelThreadMessage(channelname, threadID, msg string, format MessageFormat) RetVal
+	// SendProtocolUserChannelThreadMessage directs a message to a user in a
+	// thread in a channel, or just the channel if the protocol has no threads.
+	SendProtocolUserChannelThreadMessage(user, channelname, threadID, msg string, format MessageFormat) RetVal
 	// SendProtocolUserMessage sends a direct message to a user if supported.
 	// For protocols not supportint DM, the bot should send a message addressed
 	// to the user in an implementation-specific channel.
@@ -74,3 +86,43 @@ type Connector interface {
 	// The Run method starts the main loop and takes a channel for stopping it.
 	Run(stopchannel <-chan struct{})
 }
+
+// FileSender is an optional interface for connectors that can upload files;
+// the robot checks for it with a type assertion, and sends text files as
+// fixed-format messages for connectors without it.
+type FileSender interface {
+	// SendProtocolFile uploads a file to a channel, or to a thread in the
+	// channel when threadID is set. When channelname is "", the file goes to
+	// the user in a DM.
+	SendProtocolFile(user, channelname, threadID string, file *File) RetVal
+}
+
+// AttachmentSender is an optional interface for connectors that can send
+// structured messages, like Slack attachments; connectors without it get a
+// fixed-format text rendering.
+type AttachmentSender interface {
+	// SendProtocolAttachment sends an attachment, with the same destination
+	// rules as SendProtocolFile.
+	SendProtocolAttachment(user, channelname, threadID string, attachment *Attachment) RetVal
+}
+
+// MessageEditor is an optional interface for connectors that can edit a
+// message after sending it, so e.g. a job can update a single status line;
+// for connectors without it, updates are sent as new messages.
+type MessageEditor interface {
+	// SendProtocolEditableMessage sends a message with the same destination
+	// rules as SendProtocolFile, returning a connector-specific ID for
+	// updating it.
+	SendProtocolEditableMessage(user, channelname, threadID, msg string, format MessageFormat) (id string, ret RetVal)
+	// UpdateProtocolMessage replaces the text of a message sent with
+	// SendProtocolEditableMessage.
+	UpdateProtocolMessage(id, msg string, format MessageFormat) RetVal
+}
+
+// Reactor is an optional interface for connectors that can add emoji
+// reactions to messages.
+type Reactor interface {
+	// AddProtocolReaction adds a reaction, e.g. "thumbsup", to a message the
+	// robot heard; raw is the raw message the connector passed to the robot.
+	AddProtocolReaction(raw interface{}, reaction string) RetVal
+}
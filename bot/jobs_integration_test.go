@@ -0,0 +1,355 @@
+// +build integration
+
+package bot_test
+
+// jobs_integration_test.go - tests for starting jobs and running pipelines.
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+type goJobConfig struct {
+	Greeting string
+}
+
+func init() {
+	RegisterJob("gohello", JobHandler{
+		DefaultConfig: "Verbose: true\n",
+		Handler:       goHello,
+		Config:        &goJobConfig{},
+	})
+	RegisterJob("gofanout", JobHandler{
+		DefaultConfig: "Channel: bottest\n",
+		Handler:       goFanOut,
+	})
+	RegisterJob("gofail", JobHandler{
+		DefaultConfig: "Channel: bottest\n",
+		Handler:       func(r *Robot, args ...string) TaskRetVal { return Fail },
+	})
+	RegisterJob("gonested", JobHandler{
+		DefaultConfig: "Channel: bottest\n",
+		Handler:       goNested,
+	})
+	RegisterJob("gocleanup", JobHandler{
+		DefaultConfig: "Channel: bottest\nFinalTasks:\n- Name: gohello\n  Parameters:\n  - Name: TARGET\n    Value: finally\n",
+		Handler:       goCleanup,
+	})
+	RegisterJob("goreport", JobHandler{
+		DefaultConfig: "Channel: bottest\n",
+		Handler:       goReport,
+	})
+	RegisterJob("gochain", JobHandler{
+		DefaultConfig: "Channel: bottest\n",
+		Handler: func(r *Robot, args ...string) TaskRetVal {
+			r.AddTask("gohello")
+			return Normal
+		},
+	})
+}
+
+func goHello(r *Robot, args ...string) TaskRetVal {
+	var c *goJobConfig
+	r.GetTaskConfig(&c)
+	target := r.GetParameter("TARGET")
+	if len(target) == 0 {
+		target = "World"
+	}
+	r.Say(fmt.Sprintf("%s, %s!", c.Greeting, target))
+	return Normal
+}
+
+// goFanOut greets two targets in parallel, then everyone
+func goFanOut(r *Robot, args ...string) TaskRetVal {
+	tasks := [][]string{{"gohello", "TARGET=east"}, {"gohello", "TARGET=west"}}
+	if r.GetParameter("FAIL") == "true" {
+		tasks = append(tasks, []string{"gofail"})
+	}
+	if r.GetParameter("NESTED") == "true" {
+		tasks = append(tasks, []string{"gonested"})
+	}
+	r.SetParameter("TARGET", "everyone")
+	r.AddParallelTasks(2, tasks...)
+	r.AddTask("gohello")
+	return Normal
+}
+
+// goNested tries to add a task from a parallel task
+func goNested(r *Robot, args ...string) TaskRetVal {
+	r.Say(fmt.Sprintf("AddTask from a parallel task: %s", r.AddTask("gohello")))
+	return Normal
+}
+
+// parallelReplies checks the replies from a group of parallel tasks, which
+// can come in any order; each reply regex must match exactly once.
+func parallelReplies(t *testing.T, conn *testc.TestConnector, want ...string) {
+	remaining := make([]string, len(want))
+	copy(remaining, want)
+	for range want {
+		got, err := conn.GetBotMessage()
+		if err != nil {
+			t.Errorf("FAILED timeout waiting for parallel replies; still want: %q", remaining)
+			return
+		}
+		matched := false
+		for i, reply := range remaining {
+			if regexp.MustCompile(reply).MatchString(got.Message) {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			t.Errorf("FAILED unexpected or repeated parallel reply: \"%s\"", got.Message)
+		}
+	}
+}
+
+// goCleanup adds a fail task, a final task without the configured final
+// task's TARGET, and a task that fails if FAIL=true
+func goCleanup(r *Robot, args ...string) TaskRetVal {
+	r.AddFailTask("goreport")
+	r.AddFinalTask("gohello")
+	if r.GetParameter("FAIL") == "true" {
+		r.AddTask("gofail")
+	}
+	return Normal
+}
+
+// goReport reports the failed task and it's exit code
+func goReport(r *Robot, args ...string) TaskRetVal {
+	r.Say(fmt.Sprintf("Cleaning up after %s, exit code %s", r.GetParameter("GOPHER_FAILED_TASK"), r.GetParameter("GOPHER_FAILED_EXIT_CODE")))
+	return Normal
+}
+
+func TestJobTriggers(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []testItem{
+		{erin, bottest, "build widgets finished", []testc.TestMessage{{null, bottest, "Starting job 'buildnotify'.*"}, {null, bottest, "Build finished, widgets!"}, {null, bottest, "Finished job 'buildnotify'.*"}}, []Event{TriggeredTaskRan, ScriptTaskRan}, 0},
+		// Only erin can trigger the job
+		{alice, bottest, "build widgets finished", []testc.TestMessage{}, []Event{}, 100},
+		// ... and only in the job channel
+		{erin, general, "build widgets finished", []testc.TestMessage{}, []Event{}, 100},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestRunJob(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []testItem{
+		{alice, bottest, ";run job buildnotify TARGET=gadgets", []testc.TestMessage{{null, bottest, "Starting job 'buildnotify'.*"}, {null, bottest, "Build finished, gadgets!"}, {null, bottest, "Finished job 'buildnotify'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, ScriptTaskRan}, 0},
+		{alice, bottest, ";run job buildnotify", []testc.TestMessage{{alice, bottest, "Job 'buildnotify' requires a value for parameter 'TARGET'.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, bottest, "gizmos", []testc.TestMessage{{null, bottest, "Starting job 'buildnotify'.*"}, {null, bottest, "Build finished, gizmos!"}, {null, bottest, "Finished job 'buildnotify'.*"}}, []Event{RunJobTaskRan, ScriptTaskRan}, 0},
+		{alice, general, ";run job buildnotify TARGET=gadgets", []testc.TestMessage{{null, general, "Sorry, job 'buildnotify' isn't available.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";run job nosuchjob", []testc.TestMessage{{null, general, "Sorry, I don't have a job named 'nosuchjob'.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestTaskTimeout(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []testItem{
+		{alice, bottest, ";run job slowbuild", []testc.TestMessage{{null, bottest, "Starting job 'slowbuild'.*"}, {alice, bottest, "Task 'slowbuild' timed out after 1s.*"}, {alice, bottest, "Job 'slowbuild', run number \\d+ timed out in task: 'slowbuild'"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, ScriptTaskRan, ScriptPluginTimedOut}, 0},
+		// Exiting with the same value as TimedOut is just a failure
+		{alice, bottest, ";run job slowbuild DELAY=0 EXIT=4", []testc.TestMessage{{null, bottest, "Starting job 'slowbuild'.*"}, {null, bottest, "Hello, World!"}, {alice, bottest, "There were errors calling external plugin 'slowbuild'.*"}, {alice, bottest, "Job 'slowbuild', run number \\d+ failed in task: 'slowbuild'"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, ScriptTaskRan, ScriptPluginErrExit}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestHistory(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []testItem{
+		{alice, bottest, ";list history buildnotify", []testc.TestMessage{{null, bottest, "I don't have any run history for 'buildnotify'"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{erin, bottest, "build widgets finished", []testc.TestMessage{{null, bottest, "Starting job 'buildnotify'.*"}, {null, bottest, "Build finished, widgets!"}, {null, bottest, "Finished job 'buildnotify'.*"}}, []Event{TriggeredTaskRan, ScriptTaskRan}, 0},
+		{alice, bottest, ";list history buildnotify", []testc.TestMessage{{null, bottest, "(?s:Remembered runs of 'buildnotify':.*Run 0 started .* \\(no log\\))"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, bottest, ";show history buildnotify 0", []testc.TestMessage{{null, bottest, "Sorry, no HistoryProvider is configured.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestGoJob(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []testItem{
+		{alice, bottest, ";run job gohello TARGET=gophers", []testc.TestMessage{{null, bottest, "Starting job 'gohello'.*"}, {null, bottest, "Howdy, gophers!"}, {null, bottest, "Finished job 'gohello'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan}, 0},
+		// A Go job added later in a pipeline gets its stored parameters
+		{alice, general, ";store parameter gohello TARGET=stored", []testc.TestMessage{{null, general, "Stored"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{alice, bottest, ";run job gochain", []testc.TestMessage{{null, bottest, "Starting job 'gochain'.*"}, {null, bottest, "Howdy, stored!"}, {null, bottest, "Finished job 'gochain'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan}, 0},
+		{alice, bottest, ";run job gohello TARGET=gophers", []testc.TestMessage{{null, bottest, "Starting job 'gohello'.*"}, {null, bottest, "Howdy, gophers!"}, {null, bottest, "Finished job 'gohello'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan}, 0},
+		{alice, general, ";forget bot:parameters:gohello", []testc.TestMessage{{null, general, "Ok, I've forgotten.*"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestParallelTasks(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []struct {
+		message  string
+		parallel []string            // replies from the parallel tasks, in any order
+		replies  []testc.TestMessage // replies after the parallel tasks finish
+		events   []Event
+	}{
+		{";run job gofanout", []string{"Howdy, east!", "Howdy, west!"}, []testc.TestMessage{{null, bottest, "Howdy, everyone!"}, {null, bottest, "Finished job 'gofanout'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan, GoJobRan, RunJobTaskRan, GoJobRan}},
+		// Any failed task fails the pipeline after the others finish
+		{";run job gofanout FAIL=true", []string{"Howdy, east!", "Howdy, west!"}, []testc.TestMessage{{alice, bottest, "Job 'gofanout', run number \\d+ failed in task: 'gofail'"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan, GoJobRan, GoJobRan}},
+		// Parallel tasks can't add tasks to the pipeline
+		{";run job gofanout NESTED=true", []string{"Howdy, east!", "Howdy, west!", "AddTask from a parallel task: NotAllowedInParallel"}, []testc.TestMessage{{null, bottest, "Howdy, everyone!"}, {null, bottest, "Finished job 'gofanout'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan, GoJobRan, GoJobRan, RunJobTaskRan, GoJobRan}},
+	}
+	for _, test := range tests {
+		conn.SendBotMessage(&testc.TestMessage{alice, bottest, test.message})
+		checkReplies(t, conn, []testc.TestMessage{{null, bottest, "Starting job 'gofanout'.*"}})
+		parallelReplies(t, conn, test.parallel...)
+		checkReplies(t, conn, test.replies)
+		checkEvents(t, test.events)
+	}
+
+	teardown(t, done, conn)
+}
+
+func TestFailAndFinalTasks(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []testItem{
+		// Final tasks always run, fail tasks only when the pipeline fails
+		// Final tasks always run, fail tasks only when the pipeline fails;
+		// each gets it's own Parameters
+		{alice, bottest, ";run job gocleanup", []testc.TestMessage{{null, bottest, "Starting job 'gocleanup'.*"}, {null, bottest, "Howdy, finally!"}, {null, bottest, "Howdy, World!"}, {null, bottest, "Finished job 'gocleanup'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan}, 0},
+		{alice, bottest, ";run job gocleanup FAIL=true", []testc.TestMessage{{null, bottest, "Starting job 'gocleanup'.*"}, {null, bottest, "Cleaning up after gofail, exit code 1"}, {null, bottest, "Howdy, finally!"}, {null, bottest, "Howdy, World!"}, {alice, bottest, "Job 'gocleanup', run number \\d+ failed in task: 'gofail'"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan, RunJobTaskRan, GoJobRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	// A killed pipeline skips it's fail tasks, but still runs final tasks
+	conn.SendBotMessage(&testc.TestMessage{alice, bottest, ";run job longbuild"})
+	checkReplies(t, conn, []testc.TestMessage{{null, bottest, "Starting job 'longbuild'.*"}})
+	conn.SendBotMessage(&testc.TestMessage{alice, general, ";ps"})
+	got, err := conn.GetBotMessage()
+	if err != nil {
+		t.Fatal("FAILED timeout waiting for the list of running pipelines")
+	}
+	m := regexp.MustCompile(`(?i)(\d+)\s+longbuild`).FindStringSubmatch(got.Message)
+	if m == nil {
+		t.Fatalf("FAILED finding 'longbuild' in running pipelines: %s", got.Message)
+	}
+	conn.SendBotMessage(&testc.TestMessage{alice, general, ";kill " + m[1]})
+	parallelReplies(t, conn, "(?i:pipeline) "+m[1]+" .*remaining tasks will be aborted", "Howdy, finally!", "Pipeline 'longbuild', run \\d+ was killed by alice in task: 'longbuild'")
+	GetEvents()
+
+	teardown(t, done, conn)
+}
+
+func TestSchedules(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	tests := []testItem{
+		{alice, general, ";list schedules", []testc.TestMessage{{null, general, "I don't have any scheduled tasks"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, `;schedule gohello "@every 1h" TARGET=world`, []testc.TestMessage{{null, general, "Scheduled 'gohello' with schedule: @every 1h"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, `;schedule gohello "every hour"`, []testc.TestMessage{{null, general, "Sorry, 'every hour' isn't a valid schedule.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";list schedules", []testc.TestMessage{{null, general, `(?s)SCHEDULED TASKS:\nGOHELLO "@EVERY 1H" \(ADDED FROM CHAT\) - NEXT RUN .*`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";resume schedule gohello", []testc.TestMessage{{null, general, "Sorry, I don't have any schedules to resume for 'gohello'"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";pause schedule gohello", []testc.TestMessage{{null, general, "Paused schedules for 'gohello'"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";list schedules", []testc.TestMessage{{null, general, `(?s)SCHEDULED TASKS:\nGOHELLO "@EVERY 1H" \(ADDED FROM CHAT\) - PAUSED$`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";resume schedule gohello", []testc.TestMessage{{null, general, "Resumed schedules for 'gohello'"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";unschedule gohello", []testc.TestMessage{{null, general, "Removed schedules added from chat for 'gohello'"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";list schedules", []testc.TestMessage{{null, general, "I don't have any scheduled tasks"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+// postWebhook sends a webhook to the test robot, returning the status code
+func postWebhook(t *testing.T, endpoint string, headers map[string]string, payload string) int {
+	req, _ := http.NewRequest("POST", "http://127.0.0.1:8890/webhook/"+endpoint, bytes.NewBufferString(payload))
+	for h, v := range headers {
+		req.Header.Set(h, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("FAILED posting webhook '%s': %v", endpoint, err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestWebhook(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestjobs.log", t)
+
+	payload := `{"repository": {"name": "widgets", "owners": ["alice", "gophers"]}}`
+	mac := hmac.New(sha256.New, []byte("gopher secret"))
+	mac.Write([]byte(payload))
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	hooks := []struct {
+		endpoint string
+		headers  map[string]string
+		status   int
+		reply    string
+	}{
+		{"hello", map[string]string{"X-Hub-Signature-256": signature}, http.StatusAccepted, "Howdy, gophers!"},
+		{"tokenhello", map[string]string{"X-Webhook-Token": "gopher token", "X-Target": "webhooks"}, http.StatusAccepted, "Howdy, webhooks!"},
+		{"hello", map[string]string{"X-Hub-Signature-256": "sha256=0123456789abcdef"}, http.StatusUnauthorized, ""},
+		{"tokenhello", map[string]string{"X-Webhook-Token": "wrong token"}, http.StatusUnauthorized, ""},
+		{"nosuchhook", map[string]string{}, http.StatusNotFound, ""},
+	}
+	for _, hook := range hooks {
+		if status := postWebhook(t, hook.endpoint, hook.headers, payload); status != hook.status {
+			t.Errorf("FAILED webhook '%s' status; want: %d, got: %d", hook.endpoint, hook.status, status)
+			continue
+		}
+		if len(hook.reply) == 0 {
+			continue
+		}
+		for _, want := range []string{"Starting job 'gohello'.*", hook.reply, "Finished job 'gohello'.*"} {
+			got, err := conn.GetBotMessage()
+			if err != nil {
+				t.Errorf("FAILED timeout waiting for reply from robot; want: \"%s\"", want)
+				break
+			}
+			if !regexp.MustCompile(want).MatchString(got.Message) || got.Channel != bottest {
+				t.Errorf("FAILED webhook reply; want: \"%s\" in %s, got: \"%s\" in %s", want, bottest, got.Message, got.Channel)
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+		ev := GetEvents()
+		if len(*ev) != 2 || (*ev)[0] != WebhookTaskRan || (*ev)[1] != GoJobRan {
+			gevs := make([]string, len(*ev))
+			for i, e := range *ev {
+				gevs[i] = e.String()
+			}
+			t.Errorf("FAILED webhook events; want: \"WebhookTaskRan, GoJobRan\"; got: %s", strings.Join(gevs, ", "))
+		}
+	}
+
+	tests := []testItem{
+		{alice, bottest, ";list history gohello", []testc.TestMessage{{null, bottest, "(?s:Remembered runs of 'gohello':.*Run \\d+ started .* via webhook)"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
@@ -6,6 +6,7 @@ package bot
 
 import (
 	"log"
+	"strings"
 )
 
 // NOTE: brains shouldn't need to do their own locking. See bot/brain.go
@@ -27,6 +28,21 @@ func (mb *memBrain) Retrieve(k string) (*[]byte, bool, error) {
 	}
 }
 
+func (mb *memBrain) Delete(k string) error {
+	delete(mb.memories, k)
+	return nil
+}
+
+func (mb *memBrain) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	for k := range mb.memories {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
 // The file brain doesn't need the logger, but other brains might
 func provider(r Handler, _ *log.Logger) SimpleBrain {
 	mb := &memBrain{
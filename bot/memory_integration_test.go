@@ -5,6 +5,10 @@ package bot_test
 // memory_integration_test.go - tests that stress the robot's memory functions.
 
 import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	. "github.com/lnxjedi/gopherbot/bot"
@@ -57,3 +61,89 @@ func TestMemory(t *testing.T) {
 
 	teardown(t, done, conn)
 }
+
+func TestBrainAdmin(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestmemory.log", t)
+
+	tests := []testItem{
+		{alice, general, ";list memories buildnotify", []testc.TestMessage{{null, general, "I don't have any memories for namespace 'buildnotify'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{alice, general, ";store parameter buildnotify TARGET=gadgets", []testc.TestMessage{{null, general, "Stored"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{alice, general, ";list memories bot", []testc.TestMessage{{null, general, "(?is:Memories for namespace 'bot':.*bot:parameters:buildnotify)"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{alice, general, ";forget bot:parameters:buildnotify", []testc.TestMessage{{null, general, "Ok, I've forgotten 'bot:parameters:buildnotify'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{alice, general, ";forget bot:parameters:buildnotify", []testc.TestMessage{{null, general, "I don't have a memory for 'bot:parameters:buildnotify'"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		{alice, general, ";forget bot:brainKey", []testc.TestMessage{{null, general, "Sorry, I can't forget my brain key.*"}}, []Event{CommandTaskRan, GoPluginRan, AdminCheckPassed}, 0},
+		// Only admins can list and remove memories
+		{bob, general, ";list memories bot", []testc.TestMessage{{bob, general, "Sorry, that didn't match any commands.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestBrainEncryptionCommands(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottestmemory.log", t)
+
+	tests := []testItem{
+		{alice, null, "rekey brain abcdefghijklmnopqrstuvwxyz0123456789", []testc.TestMessage{{alice, null, "Brain encryption isn't enabled.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{alice, null, "convert brain", []testc.TestMessage{{alice, null, "Brain encryption isn't enabled.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		// By direct message only
+		{alice, general, ";convert brain", []testc.TestMessage{{alice, general, "Sorry, that didn't match any commands.*"}}, []Event{CatchAllsRan, CatchAllTaskRan, GoPluginRan}, 0},
+		{alice, null, "import brain nosuchbrain", []testc.TestMessage{{alice, null, "Importing memories from.*"}, {alice, null, "Import failed after 0 memories: reading brain directory.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{alice, null, "import brain importbrain", []testc.TestMessage{{alice, null, "Importing memories from.*"}, {alice, null, "Imported 1 memories, skipped 0 that already exist"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{alice, null, "import brain from importbrain", []testc.TestMessage{{alice, null, "Importing memories from.*"}, {alice, null, "Imported 0 memories, skipped 1 that already exist"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestEncryptedBrain(t *testing.T) {
+	// The test robot runs from the install path, with the brain directory
+	// relative to the config path
+	brainDir := filepath.Join("..", "cfg/test/cryptbrain/brain")
+	if err := os.MkdirAll(brainDir, 0755); err != nil {
+		t.Fatalf("Creating brain directory: %v", err)
+	}
+	defer os.RemoveAll(brainDir)
+	// A memory from before EncryptBrain was turned on
+	listFile := filepath.Join(brainDir, "lists:listmap")
+	if err := ioutil.WriteFile(listFile, []byte(`{"meals":["burgers"]}`), 0644); err != nil {
+		t.Fatalf("Writing plaintext memory: %v", err)
+	}
+	// Not a valid memory key; converting skips it and carries on
+	if err := ioutil.WriteFile(filepath.Join(brainDir, "bad-key"), []byte("datum"), 0644); err != nil {
+		t.Fatalf("Writing plaintext memory: %v", err)
+	}
+	oldKey := "abcdefghijklmnopqrstuvwxyz0123456789"
+	newKey := "9876543210zyxwvutsrqponmlkjihgfedcba"
+
+	done, conn := setup("cfg/test/cryptbrain", "/tmp/bottestmemory.log", t)
+	tests := []testItem{
+		{alice, null, "initialize brain " + oldKey, []testc.TestMessage{{alice, null, "Brain successfully initialized.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{alice, null, "convert brain", []testc.TestMessage{{alice, null, "Encrypting all memories.*"}, {alice, null, "Done - 1 memories encrypted and stored, 1 failed: bad-key"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+	datum, err := ioutil.ReadFile(listFile)
+	if err != nil {
+		t.Fatalf("Reading converted memory: %v", err)
+	}
+	if bytes.Contains(datum, []byte("burgers")) {
+		t.Errorf("Memory still stored in plaintext after converting the brain: %q", datum)
+	}
+	tests = []testItem{
+		{alice, general, ";show the meals list", []testc.TestMessage{{null, general, `(?m:Here's what I have.*\nburgers$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, null, "rekey brain " + newKey, []testc.TestMessage{{alice, null, "Brain successfully re-keyed.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+	teardown(t, done, conn)
+
+	// After a restart, only the new key unlocks the brain
+	done, conn = setup("cfg/test/cryptbrain", "/tmp/bottestmemory.log", t)
+	tests = []testItem{
+		{alice, null, "initialize brain " + oldKey, []testc.TestMessage{{alice, null, "Failed to initialize brain.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{alice, null, "initialize brain " + newKey, []testc.TestMessage{{alice, null, "Brain successfully initialized.*"}}, []Event{BotDirectMessage, CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";show the meals list", []testc.TestMessage{{null, general, `(?m:Here's what I have.*\nburgers$)`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+	teardown(t, done, conn)
+}
@@ -0,0 +1,174 @@
+package bot
+
+/* parallel.go - running a group of tasks added with AddParallelTasks at the
+same time. Each task gets it's own botContext, so external tasks can call back
+to the robot, and can be listed and killed like other pipelines. Output is
+buffered so each task gets a separate section in the pipeline's history. Tasks
+in a parallel group can't add tasks to the pipeline; AddTask and friends return
+NotAllowedInParallel.
+*/
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// historyEntry is a line or section start recorded by a bufferedLogger
+type historyEntry struct {
+	section    bool
+	name, text string
+}
+
+// bufferedLogger collects the history of a parallel task, to be copied to
+// the pipeline's HistoryLogger when the task finishes.
+type bufferedLogger struct {
+	sync.Mutex
+	entries []historyEntry
+}
+
+// Log implements HistoryLogger
+func (b *bufferedLogger) Log(line string) {
+	b.Lock()
+	b.entries = append(b.entries, historyEntry{text: line})
+	b.Unlock()
+}
+
+// Section implements HistoryLogger
+func (b *bufferedLogger) Section(name, info string) {
+	b.Lock()
+	b.entries = append(b.entries, historyEntry{section: true, name: name, text: info})
+	b.Unlock()
+}
+
+// Close implements HistoryLogger; the pipeline's logger is closed by
+// runPipeline
+func (b *bufferedLogger) Close() {}
+
+// copyTo writes the buffered history to the pipeline's logger
+func (b *bufferedLogger) copyTo(l HistoryLogger) {
+	b.Lock()
+	defer b.Unlock()
+	for _, e := range b.entries {
+		if e.section {
+			l.Section(e.name, e.text)
+		} else {
+			l.Log(e.text)
+		}
+	}
+}
+
+// parallelContext creates the botContext for a task in a parallel group,
+// with a copy of the pipeline environment plus the task's parameters.
+func (bot *botContext) parallelContext(ts taskSpec) *botContext {
+	env := make(map[string]string, len(bot.environment)+len(ts.Parameters))
+	for k, v := range bot.environment {
+		env[k] = v
+	}
+	for _, p := range ts.Parameters {
+		env[p.Name] = p.Value
+	}
+	c := &botContext{
+		User:            bot.User,
+		Channel:         bot.Channel,
+		ThreadID:        bot.ThreadID,
+		ThreadedMessage: bot.ThreadedMessage,
+		protocol:        bot.protocol,
+		RawMsg:          bot.RawMsg,
+		NameSpace:       bot.NameSpace,
+		tasks: taskList{
+			bot.tasks.t,
+			bot.tasks.nameMap,
+			bot.tasks.idMap,
+			bot.tasks.nameSpaces,
+			sync.RWMutex{},
+		},
+		isCommand:            bot.isCommand,
+		directMsg:            bot.directMsg,
+		bypassSecurityChecks: bot.bypassSecurityChecks,
+		elevated:             bot.elevated,
+		environment:          env,
+		pipeName:             bot.pipeName,
+		pipeDesc:             bot.pipeDesc,
+		parallelTask:         true,
+	}
+	if bot.logger != nil {
+		c.logger = &bufferedLogger{}
+	}
+	return c
+}
+
+// runParallel runs a group of tasks at the same time, at most limit at once
+// (0 for no limit), and waits for them all to finish. It returns the first
+// task in the group that failed, if any, with it's error string and return
+// value.
+func (bot *botContext) runParallel(group []taskSpec, limit int) (failed interface{}, errString string, ret TaskRetVal) {
+	if limit <= 0 || limit > len(group) {
+		limit = len(group)
+	}
+	children := make([]*botContext, len(group))
+	names := make([]string, len(group))
+	for i, ts := range group {
+		children[i] = bot.parallelContext(ts)
+		names[i] = ts.Name
+	}
+	bot.Lock()
+	bot.taskName = "parallel: " + strings.Join(names, ", ")
+	bot.parallel = children
+	bot.Unlock()
+
+	errStrings := make([]string, len(group))
+	rets := make([]TaskRetVal, len(group))
+	slots := make(chan struct{}, limit)
+	var logLock sync.Mutex
+	var wg sync.WaitGroup
+	for i, ts := range group {
+		wg.Add(1)
+		go func(i int, ts taskSpec) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			child := children[i]
+			// Tasks still waiting to start when the pipeline is killed don't run
+			child.Lock()
+			killed := len(child.killedBy) > 0
+			child.Unlock()
+			if killed {
+				rets[i] = Fail
+				return
+			}
+			child.registerActive()
+			Log(Debug, fmt.Sprintf("Starting parallel task '%s' in pipeline '%s'", ts.Name, bot.pipeName))
+			errStrings[i], rets[i] = child.callTask(ts.task, ts.command(), ts.Arguments...)
+			child.deregister()
+			if bot.logger != nil {
+				logLock.Lock()
+				child.logger.(*bufferedLogger).copyTo(bot.logger)
+				logLock.Unlock()
+			}
+		}(i, ts)
+	}
+	wg.Wait()
+
+	var killedBy string
+	for _, child := range children {
+		child.Lock()
+		if len(killedBy) == 0 {
+			killedBy = child.killedBy
+		}
+		child.Unlock()
+	}
+	bot.Lock()
+	bot.parallel = nil
+	if len(bot.killedBy) == 0 {
+		bot.killedBy = killedBy
+	}
+	bot.Unlock()
+	for i, ts := range group {
+		if rets[i] != Normal {
+			Log(Debug, fmt.Sprintf("Parallel task '%s' in pipeline '%s' failed with return value: %s", ts.Name, bot.pipeName, rets[i]))
+			return ts.task, errStrings[i], rets[i]
+		}
+	}
+	return nil, "", Normal
+}
@@ -0,0 +1,22 @@
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package bot
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// setProcGroup starts external tasks in their own process group, so that
+// any children can be killed along with the task.
+func setProcGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcGroup kills an external task and all of it's children
+func killProcGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
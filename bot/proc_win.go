@@ -0,0 +1,18 @@
+// +build windows
+
+package bot
+
+import (
+	"os/exec"
+)
+
+// setProcGroup is a noop on Windows
+func setProcGroup(cmd *exec.Cmd) {}
+
+// killProcGroup kills an external task; on Windows, children aren't killed
+func killProcGroup(cmd *exec.Cmd) error {
+	if cmd.Process == nil {
+		return nil
+	}
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,96 @@
+// +build integration
+
+package bot_test
+
+/* protocols_integration_test.go - tests for a robot serving more than one
+protocol, using a second instance of the test connector registered as "test2".
+*/
+
+import (
+	"log"
+	"regexp"
+	"testing"
+	"time"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+// The connector for the secondary protocol, set when it's initialized
+var secondary *testc.TestConnector
+
+// The robot's name on the secondary protocol, and a user on the primary
+const flexo = "flexo"
+
+func init() {
+	RegisterConnector("test2", func(h Handler, l *log.Logger) Connector {
+		conn := testc.Initialize(h, l)
+		secondary = conn.(*testc.TestConnector)
+		return conn
+	})
+}
+
+// expectMessage checks for a message from the robot on a given connector
+func expectMessage(t *testing.T, conn *testc.TestConnector, want testc.TestMessage) {
+	got, err := conn.GetBotMessage()
+	if err != nil {
+		t.Errorf("FAILED timeout waiting for reply from robot; want: \"%s\"", want.Message)
+		return
+	}
+	if !regexp.MustCompile(want.Message).MatchString(got.Message) || got.User != want.User || got.Channel != want.Channel {
+		t.Errorf("FAILED message match; want u:%s, c:%s, m:%s; got u:%s, c:%s, m:%s", want.User, want.Channel, want.Message, got.User, got.Channel, got.Message)
+	}
+}
+
+func TestMultiProtocol(t *testing.T) {
+	done, conn := setup("cfg/test/multiprotocol", "/tmp/bottest.log", t)
+	secondary.SetTest(t)
+
+	// Replies go to the protocol the message came from, and prompts only
+	// match replies from the same protocol
+	tests := []testItem{
+		{bob, general, "flexo: echo hello world", []testc.TestMessage{{null, general, "hello world"}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		// On the secondary protocol, the robot answers to that protocol's name
+		{bob, general, "bender: echo hello world", []testc.TestMessage{}, []Event{}, 100},
+		{alice, general, ";repeat", []testc.TestMessage{{alice, general, `What do you want me to repeat\?`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+	}
+	testcases(t, secondary, tests)
+	conn.SendBotMessage(&testc.TestMessage{alice, general, "not from this protocol"})
+	tests = []testItem{
+		{alice, general, "from the right protocol", []testc.TestMessage{{alice, general, "from the right protocol"}}, []Event{}, 0},
+	}
+	testcases(t, secondary, tests)
+
+	// Messages can be sent to a channel on a specific protocol
+	conn.SendBotMessage(&testc.TestMessage{alice, general, ";relay test2:random hello from test"})
+	expectMessage(t, secondary, testc.TestMessage{null, random, "hello from test"})
+	secondary.SendBotMessage(&testc.TestMessage{bob, general, ";relay test:general hello from test2"})
+	expectMessage(t, conn, testc.TestMessage{null, general, "hello from test2"})
+	secondary.SendBotMessage(&testc.TestMessage{bob, general, ";relay random hello from test2"})
+	expectMessage(t, secondary, testc.TestMessage{null, random, "hello from test2"})
+	GetEvents()
+
+	// A job with a "protocol:channel" Channel only runs in that channel on
+	// that protocol; a plain job Channel is on the primary protocol.
+	tests = []testItem{
+		{bob, general, "deploy web done", []testc.TestMessage{{null, general, "Starting job 'deploynotify'.*"}, {null, general, "Deployed, web!"}, {null, general, "Finished job 'deploynotify'.*"}}, []Event{TriggeredTaskRan, ScriptTaskRan}, 0},
+		{bob, general, "build web finished", []testc.TestMessage{}, []Event{}, 100},
+		{alice, general, ";run job deploynotify TARGET=db", []testc.TestMessage{{null, general, "Starting job 'deploynotify'.*"}, {null, general, "Deployed, db!"}, {null, general, "Finished job 'deploynotify'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, ScriptTaskRan}, 0},
+	}
+	testcases(t, secondary, tests)
+	tests = []testItem{
+		{bob, general, "bender: echo hello world", []testc.TestMessage{{null, general, "hello world"}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		{bob, general, "flexo: echo hello world", []testc.TestMessage{}, []Event{}, 100},
+		{bob, general, "build web finished", []testc.TestMessage{{null, general, "Starting job 'buildnotify'.*"}, {null, general, "Build finished, web!"}, {null, general, "Finished job 'buildnotify'.*"}}, []Event{TriggeredTaskRan, ScriptTaskRan}, 0},
+		{bob, general, "deploy web done", []testc.TestMessage{}, []Event{}, 100},
+		{alice, general, ";run job deploynotify TARGET=db", []testc.TestMessage{{null, general, "Sorry, job 'deploynotify' isn't available.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		// The robot only ignores its own name on the protocol it's from
+		{flexo, general, "bender: echo hello from flexo", []testc.TestMessage{{null, general, "hello from flexo"}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+	secondary.SendBotMessage(&testc.TestMessage{flexo, general, "flexo: echo hello from myself"})
+	time.Sleep(100 * time.Millisecond)
+	checkEvents(t, []Event{IgnoredUser})
+
+	teardown(t, done, conn)
+}
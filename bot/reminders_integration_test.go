@@ -0,0 +1,67 @@
+// +build integration
+
+package bot_test
+
+/* reminders_integration_test.go - tests for reminders and delayed jobs,
+scheduled with 'remind me' and '<when> run job'.
+*/
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+func init() {
+	RegisterJob("goscheduler", JobHandler{
+		DefaultConfig: "Channel: general\n",
+		Handler:       goScheduler,
+	})
+	RegisterJob("gohelpdesk", JobHandler{
+		DefaultConfig: "Channel: general\nAuthorizer: groups\nAuthRequire: Helpdesk\n",
+		Handler: func(r *Robot, args ...string) TaskRetVal {
+			r.Say("Helpdesk job ran")
+			return Normal
+		},
+	})
+}
+
+// goScheduler schedules a job that requires authorization, to check the
+// user is authorized when it's scheduled
+func goScheduler(r *Robot, args ...string) TaskRetVal {
+	r.Say(fmt.Sprintf("ScheduleTask: %s", r.ScheduleTask(time.Now().Add(time.Second), "gohelpdesk")))
+	return Normal
+}
+
+func TestReminders(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{alice, general, ";list reminders", []testc.TestMessage{{null, general, "You don't have any reminders or delayed tasks"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";remind me whenever to stretch", []testc.TestMessage{{null, general, "Sorry, I don't understand the time 'whenever'.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";remind me in 1 second to stretch", []testc.TestMessage{{null, general, "OK, I'll remind you at .*"}, {alice, general, "Reminder: stretch"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bob, general, ";remind me in 2 hours to get coffee", []testc.TestMessage{{null, general, "OK, I'll remind you at .*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{bob, general, ";list reminders", []testc.TestMessage{{null, general, `(?s)Your reminders and delayed tasks:\n.* - get coffee$`}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, general, ";list my reminders", []testc.TestMessage{{null, general, "You don't have any reminders or delayed tasks"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+		{alice, bottest, ";in 1 second run job gohello TARGET=gophers", []testc.TestMessage{{null, bottest, "OK, I'll run job 'gohello' at .*"}, {null, bottest, "Starting job 'gohello'.*"}, {null, bottest, "Howdy, gophers!"}, {null, bottest, "Finished job 'gohello'.*"}}, []Event{CommandTaskRan, GoPluginRan, DelayedTaskRan, GoJobRan}, 0},
+		{alice, bottest, ";at half past run job gohello", []testc.TestMessage{{null, bottest, "Sorry, I don't understand the time 'at half past'.*"}}, []Event{CommandTaskRan, GoPluginRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
+
+func TestScheduleTaskAuthorization(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{alice, general, ";run job goscheduler", []testc.TestMessage{{null, general, "Starting job 'goscheduler'.*"}, {null, general, "Sorry, you're not authorized.*"}, {null, general, "ScheduleTask: TaskNotAuthorized"}, {null, general, "Finished job 'goscheduler'.*"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, GoPluginRan, AdminCheckPassed, AuthRanFail}, 2000},
+		{bob, general, ";run job goscheduler", []testc.TestMessage{{null, general, "Starting job 'goscheduler'.*"}, {null, general, "ScheduleTask: Ok"}, {null, general, "Finished job 'goscheduler'.*"}, {null, general, "Helpdesk job ran"}}, []Event{CommandTaskRan, GoPluginRan, RunJobTaskRan, GoJobRan, GoPluginRan, AdminCheckFailed, AuthRanSuccess, DelayedTaskRan, GoJobRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
@@ -28,9 +28,22 @@ channel.
 
 The moral of the story: don't bother implementing a queue for reply waiters,
 and think hard before doing things any differently.
+
+Later, the reply timeout became configurable, and could be much longer than a
+minute. Rather than hold the http call open, an external task's prompt runs in
+its own goroutine (see externalPrompt), and the call returns ReplyPending
+after httpReplyWait; the script library then re-issues the same call, which
+picks up the prompt still in progress. So the 60 second limit above now applies
+to a single http call, not to the wait for a reply.
 */
 
-const replyTimeout = 45 * time.Second
+// defaultReplyTimeout is used when neither the task nor the robot configure
+// a ReplyTimeout
+const defaultReplyTimeout = 45 * time.Second
+
+// httpReplyWait is the longest an external task's http call waits for a reply
+// before returning ReplyPending
+const httpReplyWait = 45 * time.Second
 
 type replyDisposition int
 
@@ -48,7 +61,8 @@ type replyWaiter struct {
 
 // a reply matcher is used as the key in the replys map
 type replyMatcher struct {
-	user, channel string // Only one reply at a time can be requested for a given user/channel combination
+	protocol, user, channel string // Only one reply at a time can be requested for a given user/channel combination
+	thread                  string // For prompts in a thread, the reply must come in the same thread
 }
 
 // a reply is sent over the replyWaiter channel when a user replies
@@ -58,6 +72,20 @@ type reply struct {
 	rep         string           // text of the reply
 }
 
+// a pendingPrompt is an external task's prompt that's still waiting for a
+// reply; it's stored in the botContext until the task re-issues the call
+type pendingPrompt struct {
+	regexID, user, channel, prompt string
+	result                         chan promptResult
+	cancel                         chan struct{} // closed to stop waiting for a reply
+}
+
+// a promptResult is sent when a pending prompt finishes
+type promptResult struct {
+	rep string
+	ret RetVal
+}
+
 var replies = struct {
 	m map[replyMatcher][]replyWaiter
 	sync.Mutex
@@ -107,6 +135,9 @@ func init() {
 //	MatcherNotFound - the regexId didn't correspond to a valid regex
 //	TimeoutExpired - the user didn't respond within the timeout window
 //
+// The timeout window is 45 seconds, unless configured with ReplyTimeout for
+// the robot or task, or set for a single prompt with Robot.ReplyTimeout.
+//
 // Plugin authors can define regex's for regexId's in the plugin's JSON config,
 // with the restriction that the regexId must start with a lowercase letter.
 // A pre-definied regex from the following list can also be used:
@@ -171,9 +202,28 @@ func (r *Robot) PromptUserChannelForReply(regexID string, user string, channel s
 
 // promptInternal can return 'RetryPrompt'
 func (r *Robot) promptInternal(regexID string, user string, channel string, prompt string) (string, RetVal) {
+	return r.promptWait(regexID, user, channel, prompt, nil)
+}
+
+// promptWait is promptInternal with a channel that cancels the wait for a
+// reply when it's closed; the prompt then returns Interrupted.
+func (r *Robot) promptWait(regexID, user, channel, prompt string, cancel <-chan struct{}) (string, RetVal) {
+	protocol, channel := r.channelProtocol(channel)
+	if len(protocol) == 0 {
+		robot.RLock()
+		protocol = robot.protocol
+		robot.RUnlock()
+	}
+	// Prompts to the user in the same channel stay in the thread
+	var thread string
+	if r.ThreadedMessage && protocol == r.protocol && channel == r.Channel {
+		thread = r.ThreadID
+	}
 	matcher := replyMatcher{
-		user:    user,
-		channel: channel,
+		protocol: protocol,
+		user:     user,
+		channel:  channel,
+		thread:   thread,
 	}
 	var rep replyWaiter
 	// TODO: look up Robot from global hash
@@ -209,9 +259,11 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 		Log(Debug, fmt.Sprintf("Prompting for \"%s \" and creating reply waiters list and prompting for matcher: %q", prompt, matcher))
 		var ret RetVal
 		if channel == "" {
-			ret = robot.SendProtocolUserMessage(user, prompt, r.Format)
+			ret = getConnector(protocol).SendProtocolUserMessage(user, prompt, r.Format)
+		} else if len(thread) > 0 {
+			ret = getConnector(protocol).SendProtocolUserChannelThreadMessage(user, channel, thread, prompt, r.Format)
 		} else {
-			ret = robot.SendProtocolUserChannelMessage(user, channel, prompt, r.Format)
+			ret = getConnector(protocol).SendProtocolUserChannelMessage(user, channel, prompt, r.Format)
 		}
 		if ret != Ok {
 			replies.Unlock()
@@ -222,9 +274,21 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 		replies.m[matcher] = waiters
 		replies.Unlock()
 	}
+	timeout := r.replyTimeout
+	if timeout == 0 {
+		timeout = task.replyTimeout
+	}
+	if timeout == 0 {
+		robot.RLock()
+		timeout = robot.replyTimeout
+		robot.RUnlock()
+	}
+	if timeout == 0 {
+		timeout = defaultReplyTimeout
+	}
 	var replied reply
 	select {
-	case <-time.After(replyTimeout):
+	case <-time.After(timeout):
 		Log(Warn, fmt.Sprintf("Timed out waiting for a reply to regex \"%s\" in channel: %s", regexID, r.Channel))
 		replies.Lock()
 		waitlist, found := replies.m[matcher]
@@ -248,6 +312,13 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 		// expired.
 		replies.Unlock()
 		replied = <-rep.replyChannel
+	case <-cancel:
+		Log(Debug, fmt.Sprintf("Canceled waiting for reply to: %s", prompt))
+		if removeWaiter(matcher, rep) {
+			return "", Interrupted
+		}
+		// race: the reply is already on it's way
+		replied = <-rep.replyChannel
 	case replied = <-rep.replyChannel:
 	}
 	if replied.disposition == replyInterrupted {
@@ -268,3 +339,82 @@ func (r *Robot) promptInternal(regexID string, user string, channel string, prom
 	}
 	return replied.rep, Ok
 }
+
+// removeWaiter removes a canceled waiter from the list for a matcher,
+// returning false if it's not there because a reply is already being sent.
+// When the waiter was the one that prompted, the other waiters retry.
+func removeWaiter(matcher replyMatcher, rep replyWaiter) bool {
+	replies.Lock()
+	waitlist := replies.m[matcher]
+	for i, w := range waitlist {
+		if w.replyChannel != rep.replyChannel {
+			continue
+		}
+		if i != 0 {
+			replies.m[matcher] = append(waitlist[:i:i], waitlist[i+1:]...)
+			replies.Unlock()
+			return true
+		}
+		delete(replies.m, matcher)
+		replies.Unlock()
+		for _, other := range waitlist[1:] {
+			other.replyChannel <- reply{false, retryPrompt, ""}
+		}
+		return true
+	}
+	replies.Unlock()
+	return false
+}
+
+// externalPrompt is promptInternal for external tasks, which can't hold an
+// http call open for a long reply timeout. The prompt runs in a goroutine; if
+// it hasn't finished after httpReplyWait, externalPrompt returns ReplyPending,
+// and the same call from the task picks up the pending prompt. Like
+// promptInternal, it can return RetryPrompt. A pending prompt that's
+// replaced by a different one, or still waiting when the task finishes, is
+// canceled.
+func (r *Robot) externalPrompt(regexID, user, channel, prompt string) (string, RetVal) {
+	c := r.getContext()
+	c.Lock()
+	p := c.pendingPrompt
+	var stale *pendingPrompt
+	if p == nil || p.regexID != regexID || p.user != user || p.channel != channel || p.prompt != prompt {
+		stale = p
+		p = &pendingPrompt{regexID, user, channel, prompt, make(chan promptResult, 1), make(chan struct{})}
+		c.pendingPrompt = p
+		go func() {
+			rep, ret := r.promptWait(regexID, user, channel, prompt, p.cancel)
+			p.result <- promptResult{rep, ret}
+		}()
+	} else {
+		Log(Debug, fmt.Sprintf("Resuming pending prompt \"%s\" for user '%s' in channel '%s'", prompt, user, channel))
+	}
+	c.Unlock()
+	if stale != nil {
+		close(stale.cancel)
+	}
+	select {
+	case res := <-p.result:
+		c.Lock()
+		if c.pendingPrompt == p {
+			c.pendingPrompt = nil
+		}
+		c.Unlock()
+		return res.rep, res.ret
+	case <-time.After(httpReplyWait):
+		return "", ReplyPending
+	}
+}
+
+// cancelPrompt cancels an external task's pending prompt, if any; called when
+// the task finishes, so a prompt the task never picked up doesn't swallow
+// the user's next reply.
+func (c *botContext) cancelPrompt() {
+	c.Lock()
+	p := c.pendingPrompt
+	c.pendingPrompt = nil
+	c.Unlock()
+	if p != nil {
+		close(p.cancel)
+	}
+}
@@ -0,0 +1,122 @@
+package bot
+
+/* richmessage.go - sending files and structured messages. Connectors
+support these by implementing the optional FileSender and AttachmentSender
+interfaces; for other connectors, the robot falls back to sending the
+content as a fixed-format message.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// File is a file to upload with Robot.SendFile
+type File struct {
+	Name    string // File name, e.g. "report.txt"
+	Comment string // Optional message to go with the file
+	Content []byte
+}
+
+// Attachment is a structured message, rendered by connectors that support
+// them as e.g. a Slack or Mattermost message attachment.
+type Attachment struct {
+	Color     string            // Color for the sidebar, e.g. "good", "warning", "danger", or "#439FE0"
+	Pretext   string            // Text shown before the attachment
+	Title     string            // Title of the attachment
+	TitleLink string            // Optional link for the title
+	Text      string            // Main text of the attachment
+	Fields    []AttachmentField // Label/value pairs, often displayed as a table
+	Footer    string            // Small text shown at the bottom
+	Blocks    json.RawMessage   // Optional Slack Block Kit blocks; used in place of the other fields by connectors that understand them
+}
+
+// AttachmentField is a label/value pair in an Attachment
+type AttachmentField struct {
+	Title string
+	Value string
+	Short bool // Whether the field is short enough to be displayed beside other fields
+}
+
+// FallbackText renders an attachment as plain text; the robot sends it for
+// connectors that can't send attachments, and connectors can use it where
+// the protocol needs a plain text version, e.g. for notifications.
+func (a *Attachment) FallbackText() string {
+	var lines []string
+	if len(a.Pretext) > 0 {
+		lines = append(lines, a.Pretext)
+	}
+	if len(a.Title) > 0 {
+		if len(a.TitleLink) > 0 {
+			lines = append(lines, fmt.Sprintf("%s <%s>", a.Title, a.TitleLink))
+		} else {
+			lines = append(lines, a.Title)
+		}
+	}
+	if len(a.Text) > 0 {
+		lines = append(lines, a.Text)
+	}
+	for _, field := range a.Fields {
+		lines = append(lines, fmt.Sprintf("%s: %s", field.Title, field.Value))
+	}
+	if len(a.Footer) > 0 {
+		lines = append(lines, a.Footer)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// fallbackText renders a file as a plain text message, for connectors that
+// can't upload files.
+func (f *File) fallbackText() string {
+	var msg string
+	if len(f.Comment) > 0 {
+		msg = f.Comment + "\n"
+	}
+	if !utf8.Valid(f.Content) {
+		return msg + fmt.Sprintf("(unable to show binary file '%s', %d bytes)", f.Name, len(f.Content))
+	}
+	return msg + fmt.Sprintf("%s:\n%s", f.Name, f.Content)
+}
+
+// SendFile uploads a file to the user or channel, staying in the thread when
+// the Robot is threaded. If the connector can't upload files, a text file is
+// sent as a fixed-format message.
+func (r *Robot) SendFile(name string, content []byte, comment string) RetVal {
+	file := &File{
+		Name:    name,
+		Comment: comment,
+		Content: content,
+	}
+	protocol, channel, thread := r.richDestination()
+	conn := getConnector(protocol)
+	if sender, ok := conn.(FileSender); ok {
+		return sender.SendProtocolFile(r.User, channel, thread, file)
+	}
+	Log(Debug, fmt.Sprintf("Connector for protocol '%s' can't upload files, sending '%s' as a message", protocol, name))
+	return r.Fixed().Say(file.fallbackText())
+}
+
+// SendAttachment sends a structured message to the user or channel, staying
+// in the thread when the Robot is threaded. If the connector can't send
+// attachments, it's sent as a fixed-format message.
+func (r *Robot) SendAttachment(a *Attachment) RetVal {
+	protocol, channel, thread := r.richDestination()
+	conn := getConnector(protocol)
+	if sender, ok := conn.(AttachmentSender); ok {
+		return sender.SendProtocolAttachment(r.User, channel, thread, a)
+	}
+	Log(Debug, fmt.Sprintf("Connector for protocol '%s' can't send attachments, sending as a message", protocol))
+	return r.Fixed().Say(a.FallbackText())
+}
+
+// richDestination returns the protocol, channel and thread for a file or
+// attachment, following the same rules as Say.
+func (r *Robot) richDestination() (protocol, channel, thread string) {
+	protocol, channel = r.channelProtocol(r.Channel)
+	if len(channel) > 0 && r.ThreadedMessage {
+		thread = r.ThreadID
+	}
+	return
+}
@@ -0,0 +1,30 @@
+// +build integration
+
+package bot_test
+
+/* richmessage_integration_test.go - tests for files, attachments, editable
+messages and reactions; the test connector supports none of these, so files
+and attachments arrive as fixed-format messages, and message updates as new
+messages.
+*/
+
+import (
+	"testing"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+func TestRichMessages(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		{carol, general, ";report", []testc.TestMessage{{null, general, `(?s)^HERE'S THE REPORT\nREPORT.TXT:\nBUILD PASSED`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		{carol, general, ";attach", []testc.TestMessage{{null, general, `^BUILD\nPASSED\nBRANCH: MASTER$`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		{carol, general, ";progress", []testc.TestMessage{{null, general, `^Working\.\.\.$`}, {null, general, `^Done!$`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		{carol, general, ";react", []testc.TestMessage{{null, general, `No reactions here`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
@@ -25,17 +25,23 @@ const (
 	Slack Protocol = iota
 	Terminal
 	Test
+	IRC
+	Mattermost
 )
 
 // Robot is passed to each task as it runs, initialized from the botContext.
 // Tasks can copy and modify the Robot without affecting the botContext.
 type Robot struct {
-	User     string        // The user who sent the message; this can be modified for replying to an arbitrary user
-	Channel  string        // The channel where the message was received, or "" for a direct message. This can be modified to send a message to an arbitrary channel.
-	Protocol Protocol      // slack, terminal, test, others; used for interpreting rawmsg or sending messages with Format = 'Raw'
-	RawMsg   interface{}   // raw struct of message sent by connector; interpret based on protocol. For Slack this is a *slack.MessageEvent
-	Format   MessageFormat // The outgoing message format, one of Raw, Fixed, or Variable
-	id       int           // For looking up the botContext
+	User            string        // The user who sent the message; this can be modified for replying to an arbitrary user
+	Channel         string        // The channel where the message was received, or "" for a direct message. This can be modified to send a message to an arbitrary channel.
+	ThreadID        string        // The thread the message was received in, or for protocols with threads, the ID of a thread started from the message
+	ThreadedMessage bool          // Set when the message was received in a thread; Say and Reply then stay in the thread
+	Protocol        Protocol      // slack, terminal, test, irc, mattermost, others; used for interpreting rawmsg or sending messages with Format = 'Raw'
+	RawMsg          interface{}   // raw struct of message sent by connector; interpret based on protocol. For Slack this is a *slack.MessageEvent
+	Format          MessageFormat // The outgoing message format, one of Raw, Fixed, or Variable
+	protocol        string        // Name of the originating connector protocol, for routing messages
+	replyTimeout    time.Duration // Set with ReplyTimeout; how long prompts wait for a reply
+	id              int           // For looking up the botContext
 }
 
 //go:generate stringer -type=Protocol
@@ -87,6 +93,9 @@ func (r *Robot) SetParameter(name, value string) bool {
 // required; parameters should be specified in calls to SetParameter.
 func (r *Robot) AddTask(name string, cmdargs ...string) RetVal {
 	c := r.getContext()
+	if c.parallelTask {
+		return NotAllowedInParallel
+	}
 	t := c.tasks.getTaskByName(name)
 	if t == nil {
 		return TaskNotFound
@@ -117,6 +126,73 @@ func (r *Robot) AddTask(name string, cmdargs ...string) RetVal {
 	return Ok
 }
 
+// AddFailTask adds a task to run if the pipeline fails, e.g. to clean up or
+// send a notification, with the name of the failed task and it's return
+// value or exit code in the environment as GOPHER_FAILED_TASK and
+// GOPHER_FAILED_EXIT_CODE. Fail tasks run in the order added, before any
+// final tasks. When the task is a plugin, cmdargs should be a command followed
+// by arguments; for a job, cmdargs are parameters in the form NAME=value.
+func (r *Robot) AddFailTask(name string, cmdargs ...string) RetVal {
+	c := r.getContext()
+	if c.parallelTask {
+		return NotAllowedInParallel
+	}
+	ts, ret := c.tasks.newTaskSpec(name, cmdargs)
+	if ret != Ok {
+		return ret
+	}
+	c.failTasks = append(c.failTasks, ts)
+	return Ok
+}
+
+// AddFinalTask adds a task to run when the pipeline finishes, whether or not
+// it failed or was killed; like AddFailTask, but the GOPHER_FAILED_*
+// environment variables are only set if the pipeline failed.
+func (r *Robot) AddFinalTask(name string, cmdargs ...string) RetVal {
+	c := r.getContext()
+	if c.parallelTask {
+		return NotAllowedInParallel
+	}
+	ts, ret := c.tasks.newTaskSpec(name, cmdargs)
+	if ret != Ok {
+		return ret
+	}
+	c.finalTasks = append(c.finalTasks, ts)
+	return Ok
+}
+
+// AddParallelTasks adds a group of tasks to the pipeline that run at the same
+// time, e.g. to deploy to several regions at once. The pipeline continues when
+// all of them have finished, and fails if any of them fail. Each task is given
+// as a name followed by a command and arguments for a plugin, or parameters in
+// the form NAME=value for a job; the parameters are added to the pipeline
+// environment for that task only. At most limit tasks run at once, or all of
+// them when limit is 0. Each task gets it's own section in the pipeline
+// history. Tasks in the group can't add more tasks to the pipeline; AddTask,
+// AddParallelTasks, AddFailTask and AddFinalTask return NotAllowedInParallel.
+func (r *Robot) AddParallelTasks(limit int, tasks ...[]string) RetVal {
+	if len(tasks) == 0 {
+		return MissingArguments
+	}
+	c := r.getContext()
+	if c.parallelTask {
+		return NotAllowedInParallel
+	}
+	group := make([]taskSpec, 0, len(tasks))
+	for _, task := range tasks {
+		if len(task) == 0 {
+			return MissingArguments
+		}
+		ts, ret := c.tasks.newTaskSpec(task[0], task[1:])
+		if ret != Ok {
+			return ret
+		}
+		group = append(group, ts)
+	}
+	c.nextTasks = append(c.nextTasks, taskSpec{parallel: group, parallelLimit: limit})
+	return Ok
+}
+
 // GetParameter retrieves the value of a parameter for a namespace. Only useful
 // for Go plugins; external scripts have all parameters for the NameSpace stored
 // as environment variables. Note that runtasks.go populates the environment
@@ -129,6 +205,9 @@ func (r *Robot) GetParameter(key string) string {
 	if ok {
 		return value
 	}
+	if value, ok := c.taskParameters[key]; ok {
+		return value
+	}
 	return ""
 }
 
@@ -168,6 +247,26 @@ func (r *Robot) Direct() *Robot {
 	return &nr
 }
 
+// Threaded returns a robot object that replies in a thread; if the message
+// wasn't already in a thread, Say and Reply start one from it. For protocols
+// without threads, messages go to the channel as usual.
+func (r *Robot) Threaded() *Robot {
+	nr := *r
+	if len(nr.Channel) > 0 {
+		nr.ThreadedMessage = true
+	}
+	return &nr
+}
+
+// ReplyTimeout returns a robot object whose prompts wait the given time for
+// a reply, overriding the task and robot ReplyTimeout; e.g. for prompting
+// for a value the user has to go look up.
+func (r *Robot) ReplyTimeout(timeout time.Duration) *Robot {
+	nr := *r
+	nr.replyTimeout = timeout
+	return &nr
+}
+
 // Pause is a convenience function to pause some fractional number of seconds.
 func (r *Robot) Pause(s float64) {
 	ms := time.Duration(s * float64(1000))
@@ -201,6 +300,9 @@ func (r *Robot) GetBotAttribute(a string) *AttrRet {
 	switch a {
 	case "name":
 		attr = robot.name
+		if pname, ok := robot.protocolNames[r.protocol]; ok {
+			attr = pname
+		}
 	case "fullname", "realname":
 		attr = robot.fullName
 	case "alias":
@@ -222,16 +324,18 @@ func (r *Robot) GetBotAttribute(a string) *AttrRet {
 // - A RetVal which is one of Ok, UserNotFound, AttributeNotFound
 // Current attributes:
 // name(handle), fullName, email, firstName, lastName, phone, internalID
-// TODO: supplement data with gopherbot.json user's table
+// Attributes the protocol doesn't provide come from the UserRoster in
+// gopherbot.yaml, which can also supply duoUser, timeZone and custom
+// attributes.
 func (r *Robot) GetUserAttribute(u, a string) *AttrRet {
 	a = strings.ToLower(a)
-	attr, ret := robot.GetProtocolUserAttribute(u, a)
+	attr, ret := getUserAttribute(r.protocol, u, a)
 	return &AttrRet{attr, ret}
 }
 
 // messageHeard sends a typing notification
 func (r *Robot) messageHeard() {
-	robot.MessageHeard(r.User, r.Channel)
+	r.connector().MessageHeard(r.User, r.Channel)
 }
 
 // GetSenderAttribute returns a AttrRet with
@@ -239,20 +343,19 @@ func (r *Robot) messageHeard() {
 // - A RetVal which is one of Ok, UserNotFound, AttributeNotFound
 // Current attributes:
 // name(handle), fullName, email, firstName, lastName, phone, internalID
-// TODO: supplement data with gopherbot.json user's table
+// As for GetUserAttribute, the UserRoster supplements these.
 func (r *Robot) GetSenderAttribute(a string) *AttrRet {
 	a = strings.ToLower(a)
 	switch a {
 	case "name", "username", "handle", "user", "user name":
 		return &AttrRet{r.User, Ok}
 	default:
-		attr, ret := robot.GetProtocolUserAttribute(r.User, a)
+		attr, ret := getUserAttribute(r.protocol, r.User, a)
 		return &AttrRet{attr, ret}
 	}
 }
 
 /*
-
 GetTaskConfig sets a struct pointer to point to a config struct populated
 from configuration when plugins were loaded. To use, a plugin should define
 a struct for it's configuration data, e.g.:
@@ -321,39 +424,93 @@ func (r *Robot) Log(l LogLevel, v ...interface{}) {
 	Log(l, v...)
 }
 
+// connector returns the connector for the protocol the pipeline originated
+// from
+func (r *Robot) connector() Connector {
+	return getConnector(r.protocol)
+}
+
+// splitChannel splits a channel given as "protocol:channel" for a
+// configured protocol; otherwise protocol is empty.
+func splitChannel(channel string) (protocol, ch string) {
+	if i := strings.Index(channel, ":"); i > 0 {
+		robot.RLock()
+		_, ok := robot.protocolConnectors[channel[:i]]
+		robot.RUnlock()
+		if ok {
+			return channel[:i], channel[i+1:]
+		}
+	}
+	return "", channel
+}
+
+// channelProtocol splits a channel given as "protocol:channel" for sending to
+// a channel on a specific protocol; otherwise the protocol is the one the
+// pipeline originated from.
+func (r *Robot) channelProtocol(channel string) (protocol, ch string) {
+	if protocol, ch = splitChannel(channel); len(protocol) > 0 {
+		return protocol, ch
+	}
+	return r.protocol, ch
+}
+
 // SendChannelMessage lets a plugin easily send a message to an arbitrary
-// channel. Use Robot.Fixed().SendChannelMessage(...) for fixed-width
+// channel; use "protocol:channel", e.g. "irc:ops", for a channel on another
+// protocol. Use Robot.Fixed().SendChannelMessage(...) for fixed-width
 // font.
 func (r *Robot) SendChannelMessage(channel, msg string) RetVal {
-	return robot.SendProtocolChannelMessage(channel, msg, r.Format)
+	protocol, channel := r.channelProtocol(channel)
+	return getConnector(protocol).SendProtocolChannelMessage(channel, msg, r.Format)
 }
 
 // SendUserChannelMessage lets a plugin easily send a message directed to
 // a specific user in a specific channel without fiddling with the robot
-// object. Use Robot.Fixed().SencChannelMessage(...) for fixed-width
-// font.
+// object; the channel can be given as "protocol:channel".
+// Use Robot.Fixed().SencChannelMessage(...) for fixed-width font.
 func (r *Robot) SendUserChannelMessage(user, channel, msg string) RetVal {
-	return robot.SendProtocolUserChannelMessage(user, channel, msg, r.Format)
+	protocol, channel := r.channelProtocol(channel)
+	return getConnector(protocol).SendProtocolUserChannelMessage(user, channel, msg, r.Format)
+}
+
+// SendThreadMessage sends a message to a thread in a channel; the channel can
+// be given as "protocol:channel". For protocols without threads, the message
+// goes to the channel.
+func (r *Robot) SendThreadMessage(channel, thread, msg string) RetVal {
+	protocol, channel := r.channelProtocol(channel)
+	return getConnector(protocol).SendProtocolChannelThreadMessage(channel, thread, msg, r.Format)
+}
+
+// SendUserThreadMessage directs a message to a user in a thread in a channel;
+// the channel can be given as "protocol:channel".
+func (r *Robot) SendUserThreadMessage(user, channel, thread, msg string) RetVal {
+	protocol, channel := r.channelProtocol(channel)
+	return getConnector(protocol).SendProtocolUserChannelThreadMessage(user, channel, thread, msg, r.Format)
 }
 
 // SendUserMessage lets a plugin easily send a DM to a user. If a DM
 // isn't possible, the connector should message the user in a channel.
 func (r *Robot) SendUserMessage(user, msg string) RetVal {
-	return robot.SendProtocolUserMessage(user, msg, r.Format)
+	return r.connector().SendProtocolUserMessage(user, msg, r.Format)
 }
 
 // Reply directs a message to the user
 func (r *Robot) Reply(msg string) RetVal {
 	if r.Channel == "" {
-		return robot.SendProtocolUserMessage(r.User, msg, r.Format)
+		return r.connector().SendProtocolUserMessage(r.User, msg, r.Format)
+	}
+	if r.ThreadedMessage {
+		return r.SendUserThreadMessage(r.User, r.Channel, r.ThreadID, msg)
 	}
-	return robot.SendProtocolUserChannelMessage(r.User, r.Channel, msg, r.Format)
+	return r.SendUserChannelMessage(r.User, r.Channel, msg)
 }
 
 // Say just sends a message to the user or channel
 func (r *Robot) Say(msg string) RetVal {
 	if r.Channel == "" {
-		return robot.SendProtocolUserMessage(r.User, msg, r.Format)
+		return r.connector().SendProtocolUserMessage(r.User, msg, r.Format)
+	}
+	if r.ThreadedMessage {
+		return r.SendThreadMessage(r.Channel, r.ThreadID, msg)
 	}
-	return robot.SendProtocolChannelMessage(r.Channel, msg, r.Format)
+	return r.SendChannelMessage(r.Channel, msg)
 }
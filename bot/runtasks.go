@@ -22,8 +22,9 @@ var envPassThrough = []string{
 	"USER",
 }
 
-// runPipeline is triggered by user commands, job triggers, and scheduled tasks.
-// Called from dispatch: checkTaskMatchersAndRun or scheduledTask. interactive
+// runPipeline is triggered by user commands, job triggers, scheduled tasks,
+// delayed tasks and webhooks. Called from dispatch: checkTaskMatchersAndRun,
+// scheduledTask, runDelayedTask or webhookHandler. interactive
 // indicates whether a pipeline started from a user command - plugin match or
 // run job command.
 func (bot *botContext) runPipeline(t interface{}, interactive bool, ptype pipelineType, command string, args ...string) {
@@ -71,7 +72,8 @@ func (bot *botContext) runPipeline(t interface{}, interactive bool, ptype pipeli
 			runIndex = th.NextIndex
 			hist := historyLog{
 				LogIndex:   runIndex,
-				CreateTime: start.Format("Mon Jan 2 15:04:05 MST 2006"),
+				CreateTime: start.Format(historyTimeFormat),
+				StartedBy:  ptype.String(),
 			}
 			th.NextIndex++
 			th.Histories = append(th.Histories, hist)
@@ -133,66 +135,77 @@ func (bot *botContext) runPipeline(t interface{}, interactive bool, ptype pipeli
 	// Once Active, we need to use the Mutex for access to some fields; see
 	// botcontext/type botContext
 	bot.registerActive()
+	// Configured FailTasks and FinalTasks for a job run ahead of any added
+	// with AddFailTask / AddFinalTask.
+	if isJob {
+		bot.failTasks = bot.resolveTasks(job.FailTasks)
+		bot.finalTasks = bot.resolveTasks(job.FinalTasks)
+	}
 	r := bot.makeRobot()
-	var errString string
+	var errString, killedBy string
 	var ret TaskRetVal
+	// For connectors that can edit messages, the finished message replaces
+	// the starting message instead of adding another line.
+	var status *MessageRef
 	if verbose {
-		r.Say(fmt.Sprintf("Starting job '%s', run %d", task.name, runIndex))
-	}
+		status, _ = r.SendEditableMessage(fmt.Sprintf("Starting job '%s', run %d", task.name, runIndex))
+	}
+	// The 'run job' builtin checks authorization and elevation for the job
+	// before prompting for parameters, so they aren't checked twice.
+	securityChecked := ptype == runJob
+	// group is set when the next step in the pipeline is a group of tasks
+	// added with AddParallelTasks
+	var group []taskSpec
+	var groupLimit int
 	for {
-		// NOTE: if RequireAdmin is true, the user can't access the plugin at all if not an admin
-		if isPlugin && len(plugin.AdminCommands) > 0 {
-			adminRequired := false
-			for _, i := range plugin.AdminCommands {
-				if command == i {
-					adminRequired = true
-					break
-				}
+		if group == nil {
+			if !bot.checkTaskSecurity(r, t, command, args, securityChecked) {
+				ret = Fail
+				break
 			}
-			if adminRequired {
-				if !r.CheckAdmin() {
-					r.Say("Sorry, that command is only available to bot administrators")
-					ret = Fail
+		} else {
+			allowed := true
+			for _, ts := range group {
+				if !bot.checkTaskSecurity(r, ts.task, ts.command(), ts.Arguments, false) {
+					allowed = false
 					break
 				}
 			}
-		}
-		if !bot.bypassSecurityChecks {
-			if bot.checkAuthorization(t, command, args...) != Success {
+			if !allowed {
 				ret = Fail
 				break
 			}
-			if !bot.elevated {
-				eret, required := bot.checkElevation(t, command)
-				if eret != Success {
-					ret = Fail
-					break
-				}
-				if required {
-					bot.elevated = true
-				}
+		}
+		securityChecked = false
+		emitTaskRan(ptype)
+		if group != nil {
+			bot.debug(fmt.Sprintf("Running %d parallel tasks", len(group)), false)
+			var failed interface{}
+			failed, errString, ret = bot.runParallel(group, groupLimit)
+			if failed != nil {
+				// Failures are reported for the task that failed
+				t = failed
 			}
+		} else {
+			bot.debug(fmt.Sprintf("Running task with command '%s' and arguments: %v", command, args), false)
+			errString, ret = bot.callTask(t, command, args...)
 		}
-		switch ptype {
-		case plugCommand:
-			emit(CommandTaskRan) // for testing, otherwise noop
-		case plugMessage:
-			emit(AmbientTaskRan)
-		case catchAll:
-			emit(CatchAllTaskRan)
-		case jobTrigger:
-			emit(TriggeredTaskRan)
-		case scheduled:
-			emit(ScheduledTaskRan)
-		case runJob:
-			emit(RunJobTaskRan)
-		}
-		bot.debug(fmt.Sprintf("Running task with command '%s' and arguments: %v", command, args), false)
-		errString, ret = bot.callTask(t, command, args...)
 		bot.debug(fmt.Sprintf("Task finished with return value: %s", ret), false)
+		bot.Lock()
+		killedBy = bot.killedBy
+		bot.Unlock()
+		if len(killedBy) > 0 {
+			task, _, _ := getTask(t)
+			Log(Audit, fmt.Sprintf("Pipeline '%s', run %d killed by user '%s' in task '%s'", bot.pipeName, runIndex, killedBy, task.name))
+			if bot.logger != nil {
+				bot.logger.Section("killed", fmt.Sprintf("pipeline killed by user '%s' in task '%s'; remaining tasks aborted", killedBy, task.name))
+			}
+			break
+		}
 
 		if ret != Normal {
-			if interactive && errString != "" {
+			// Scheduled plugins still report timeouts; jobs report below
+			if (interactive || (ret == TimedOut && !isJob)) && errString != "" {
 				r.Reply(errString)
 			}
 			break
@@ -200,32 +213,201 @@ func (bot *botContext) runPipeline(t interface{}, interactive bool, ptype pipeli
 		if len(bot.nextTasks) > 0 {
 			var ts taskSpec
 			ts, bot.nextTasks = bot.nextTasks[0], bot.nextTasks[1:]
+			if ts.parallel != nil {
+				group, groupLimit = ts.parallel, ts.parallelLimit
+				continue
+			}
+			group = nil
 			_, plugin, _ := getTask(ts.task)
 			isPlugin = plugin != nil
 			if isPlugin {
-				command = ts.Command
 				args = ts.Arguments
 			} else {
-				command = "run"
 				args = []string{}
 			}
+			command = ts.command()
 			t = ts.task
 		} else {
 			break
 		}
 	}
+	bot.runCleanupTasks(r, ptype, t, ret)
 	bot.deregister()
 	if bot.logger != nil {
 		bot.logger.Section("done", "pipeline has completed")
 		bot.logger.Close()
 	}
+	if len(killedBy) > 0 {
+		task, _, _ := getTask(t)
+		r.Say(fmt.Sprintf("Pipeline '%s', run %d was killed by %s in task: '%s'", bot.pipeName, runIndex, killedBy, task.name))
+		return
+	}
 	if ret == Normal && verbose {
-		r.Say(fmt.Sprintf("Finished job '%s', run %d", bot.pipeName, runIndex))
+		r.UpdateMessage(status, fmt.Sprintf("Finished job '%s', run %d", bot.pipeName, runIndex))
 	}
 	if ret != Normal && isJob {
 		task, _, _ := getTask(t)
-		r.Reply(fmt.Sprintf("Job '%s', run number %d failed in task: '%s'", bot.pipeName, runIndex, task.name))
+		if ret == TimedOut {
+			r.Reply(fmt.Sprintf("Job '%s', run number %d timed out in task: '%s'", bot.pipeName, runIndex, task.name))
+		} else {
+			r.Reply(fmt.Sprintf("Job '%s', run number %d failed in task: '%s'", bot.pipeName, runIndex, task.name))
+		}
+	}
+}
+
+// emitTaskRan emits the event for a task running in a pipeline of the given
+// type; for testing, otherwise noop
+func emitTaskRan(ptype pipelineType) {
+	switch ptype {
+	case plugCommand:
+		emit(CommandTaskRan)
+	case plugMessage:
+		emit(AmbientTaskRan)
+	case catchAll:
+		emit(CatchAllTaskRan)
+	case jobTrigger:
+		emit(TriggeredTaskRan)
+	case scheduled:
+		emit(ScheduledTaskRan)
+	case runJob:
+		emit(RunJobTaskRan)
+	case webhook:
+		emit(WebhookTaskRan)
+	case delayed:
+		emit(DelayedTaskRan)
+	}
+}
+
+// resolveTasks looks up the tasks for a job's configured FailTasks or
+// FinalTasks, skipping any that can't run.
+func (bot *botContext) resolveTasks(specs []taskSpec) []taskSpec {
+	resolved := make([]taskSpec, 0, len(specs))
+	for _, ts := range specs {
+		t := bot.tasks.getTaskByName(ts.Name)
+		if t == nil {
+			Log(Error, fmt.Sprintf("Task '%s' not found for fail or final tasks of job '%s'", ts.Name, bot.pipeName))
+			continue
+		}
+		if _, plugin, _ := getTask(t); plugin != nil && len(ts.Command) == 0 {
+			Log(Error, fmt.Sprintf("Empty 'Command' for plugin '%s' in fail or final tasks of job '%s'", ts.Name, bot.pipeName))
+			continue
+		}
+		ts.task = t
+		resolved = append(resolved, ts)
+	}
+	return resolved
+}
+
+// runCleanupTasks runs the pipeline's fail tasks if it failed, then it's
+// final tasks. When the pipeline failed, the name of the failed task and it's
+// return value or exit code are in the environment as GOPHER_FAILED_TASK and
+// GOPHER_FAILED_EXIT_CODE. A killed pipeline skips it's fail tasks, but still
+// runs it's final tasks, which can be killed in turn. Each cleanup task gets
+// it's own copy of the environment with it's Parameters. Cleanup tasks can't
+// add more tasks to the pipeline, and failures are logged without changing
+// the pipeline's result.
+func (bot *botContext) runCleanupTasks(r *Robot, ptype pipelineType, failed interface{}, ret TaskRetVal) {
+	var cleanup []taskSpec
+	bot.Lock()
+	killed := len(bot.killedBy) > 0
+	bot.killedBy = ""
+	bot.Unlock()
+	if ret != Normal && !killed {
+		task, _, _ := getTask(failed)
+		bot.environment["GOPHER_FAILED_TASK"] = task.name
+		bot.environment["GOPHER_FAILED_EXIT_CODE"] = fmt.Sprintf("%d", ret)
+		cleanup = append(cleanup, bot.failTasks...)
+	}
+	cleanup = append(cleanup, bot.finalTasks...)
+	env := bot.environment
+	defer func() {
+		bot.environment = env
+	}()
+	for _, ts := range cleanup {
+		bot.Lock()
+		killed := len(bot.killedBy) > 0
+		bot.Unlock()
+		if killed {
+			return
+		}
+		if !bot.checkTaskSecurity(r, ts.task, ts.command(), ts.Arguments, false) {
+			continue
+		}
+		bot.environment = make(map[string]string, len(env)+len(ts.Parameters))
+		for k, v := range env {
+			bot.environment[k] = v
+		}
+		for _, p := range ts.Parameters {
+			bot.environment[p.Name] = p.Value
+		}
+		emitTaskRan(ptype)
+		bot.debug(fmt.Sprintf("Running cleanup task '%s' with command '%s' and arguments: %v", ts.Name, ts.command(), ts.Arguments), false)
+		errString, tret := bot.callTask(ts.task, ts.command(), ts.Arguments...)
+		bot.nextTasks = bot.nextTasks[:0]
+		if tret != Normal {
+			Log(Error, fmt.Sprintf("Cleanup task '%s' in pipeline '%s' failed with return value: %s", ts.Name, bot.pipeName, tret))
+			if bot.logger != nil {
+				bot.logger.Log(fmt.Sprintf("cleanup task '%s' failed: %s", ts.Name, errString))
+			}
+		}
+	}
+}
+
+// checkTaskSecurity checks admin commands, authorization and elevation for a
+// task in a pipeline, returning false if the task can't run.
+func (bot *botContext) checkTaskSecurity(r *Robot, t interface{}, command string, args []string, securityChecked bool) bool {
+	_, plugin, _ := getTask(t)
+	// NOTE: if RequireAdmin is true, the user can't access the plugin at all if not an admin
+	if plugin != nil && len(plugin.AdminCommands) > 0 {
+		adminRequired := false
+		for _, i := range plugin.AdminCommands {
+			if command == i {
+				adminRequired = true
+				break
+			}
+		}
+		if adminRequired {
+			if !r.CheckAdmin() {
+				r.Say("Sorry, that command is only available to bot administrators")
+				return false
+			}
+		}
 	}
+	if !bot.bypassSecurityChecks && !securityChecked {
+		if bot.checkAuthorization(t, command, args...) != Success {
+			return false
+		}
+		if !bot.elevated {
+			eret, required := bot.checkElevation(t, command)
+			if eret != Success {
+				return false
+			}
+			if required {
+				bot.elevated = true
+			}
+		}
+	}
+	return true
+}
+
+// storedParameters returns the parameters stored for a task's NameSpace that
+// aren't already set in env. They're supplied to that task only; useful
+// mainly for specific tasks to have secrets passed in but not handed to
+// everything in the pipeline. Dynamically provided and configured parameters
+// take precedence over stored parameters.
+func storedParameters(task *botTask, env map[string]string) map[string]string {
+	params := make(map[string]string)
+	storedEnv := make(map[string]string)
+	_, exists, _ := checkoutDatum(paramPrefix+task.NameSpace, &storedEnv, false)
+	if !exists {
+		return params
+	}
+	for key, value := range storedEnv {
+		if _, exists := env[key]; !exists {
+			params[key] = value
+		}
+	}
+	return params
 }
 
 // callTask does the real work of running a job or plugin with a command and arguments.
@@ -241,6 +423,10 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 		bot.debug(msg, false)
 		return msg, ConfigurationError
 	}
+	bot.Lock()
+	bot.taskName = task.name
+	bot.taskDesc = task.Description
+	bot.Unlock()
 	if bot.logger != nil {
 		var desc string
 		if len(task.Description) > 0 {
@@ -255,12 +441,27 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 		defer checkPanic(r, fmt.Sprintf("Plugin: %s, command: %s, arguments: %v", task.name, command, args))
 	}
 	Log(Debug, fmt.Sprintf("Dispatching command '%s' to plugin '%s' with arguments '%#v'", command, task.name, args))
-	if isPlugin && plugin.taskType == taskGo {
-		if command != "init" {
-			emit(GoPluginRan)
+	if task.taskType == taskGo {
+		if isPlugin {
+			if command != "init" {
+				emit(GoPluginRan)
+			}
+			Log(Debug, fmt.Sprintf("Call go plugin: '%s' with args: %q", task.name, args))
+			return "", pluginHandlers[task.name].Handler(r, command, args...)
 		}
-		Log(Debug, fmt.Sprintf("Call go plugin: '%s' with args: %q", task.name, args))
-		return "", pluginHandlers[task.name].Handler(r, command, args...)
+		// Go jobs read the pipeline environment with GetParameter, falling
+		// back to parameters stored for the job
+		if !bot.pipeStarting {
+			bot.taskParameters = storedParameters(task, bot.environment)
+			defer func() {
+				bot.taskParameters = nil
+			}()
+		} else {
+			bot.pipeStarting = false
+		}
+		emit(GoJobRan)
+		Log(Debug, fmt.Sprintf("Call go job: '%s' with args: %q", task.name, args))
+		return "", jobHandlers[task.name].Handler(r, args...)
 	}
 	var fullPath string // full path to the executable
 	var err error
@@ -292,11 +493,7 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 	} else {
 		cmd = exec.Command(fullPath, externalArgs...)
 	}
-	bot.Lock()
-	bot.taskName = task.name
-	bot.taskDesc = task.Description
-	bot.osCmd = cmd
-	bot.Unlock()
+	setProcGroup(cmd)
 	envhash := make(map[string]string)
 	if len(bot.environment) > 0 {
 		for k, v := range bot.environment {
@@ -304,20 +501,9 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 		}
 	}
 
-	// Pull stored env vars specific to this task and supply to this task only.
-	// No effect if already defined. Useful mainly for specific tasks to have
-	// secrets passed in but not handed to everything in the pipeline.
 	if !bot.pipeStarting {
-		storedEnv := make(map[string]string)
-		_, exists, _ := checkoutDatum(paramPrefix+task.NameSpace, &storedEnv, false)
-		if exists {
-			for key, value := range storedEnv {
-				// Dynamically provided and configured parameters take precedence over stored parameters
-				_, exists := envhash[key]
-				if !exists {
-					envhash[key] = value
-				}
-			}
+		for key, value := range storedParameters(task, envhash) {
+			envhash[key] = value
 		}
 	} else {
 		bot.pipeStarting = false
@@ -325,6 +511,12 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 
 	envhash["GOPHER_CHANNEL"] = bot.Channel
 	envhash["GOPHER_USER"] = bot.User
+	envhash["GOPHER_THREAD_ID"] = bot.ThreadID
+	if bot.ThreadedMessage {
+		envhash["GOPHER_THREADED_MESSAGE"] = "true"
+	} else {
+		envhash["GOPHER_THREADED_MESSAGE"] = ""
+	}
 	envhash["GOPHER_PROTOCOL"] = fmt.Sprintf("%s", bot.Protocol)
 	env := make([]string, 0, len(envhash))
 	keys := make([]string, 0, len(envhash))
@@ -362,6 +554,44 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 		errString = fmt.Sprintf("There were errors calling external plugin '%s', you might want to ask an administrator to check the logs", task.name)
 		return errString, MechanismFail
 	}
+	// osCmd is only set after the process has started, so the kill builtin
+	// always has a valid Process; if the pipeline was killed while the task
+	// was starting, kill it now.
+	bot.Lock()
+	bot.osCmd = cmd
+	killed := len(bot.killedBy) > 0
+	bot.Unlock()
+	if killed {
+		killProcGroup(cmd)
+	}
+	defer func() {
+		bot.Lock()
+		bot.osCmd = nil
+		bot.Unlock()
+		// A prompt the task didn't wait for can't get a reply now
+		bot.cancelPrompt()
+	}()
+	// Tasks without a configured Timeout get the robot's DefaultTaskTimeout.
+	// Killing the process group also closes the pipes read below.
+	timeout := task.timeout
+	if timeout == 0 {
+		robot.RLock()
+		timeout = robot.defaultTaskTimeout
+		robot.RUnlock()
+	}
+	var timer *time.Timer
+	timedOut := false
+	if timeout > 0 {
+		timer = time.AfterFunc(timeout, func() {
+			bot.Lock()
+			timedOut = true
+			bot.Unlock()
+			killProcGroup(cmd)
+		})
+		defer timer.Stop()
+	}
+	// the last few lines of stderr are logged when a task times out
+	var stdErrTail []string
 	if command != "init" {
 		emit(ScriptTaskRan)
 	}
@@ -373,6 +603,7 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 			return errString, MechanismFail
 		}
 		stdErrString := string(stdErrBytes)
+		stdErrTail = tailLines(strings.Split(strings.TrimRight(stdErrString, "\n"), "\n"), stdErrTailLines)
 		if len(stdErrString) > 0 {
 			Log(Warn, fmt.Errorf("Output from stderr of external command '%s': %s", fullPath, stdErrString))
 			errString = fmt.Sprintf("There was error output while calling external task '%s', you might want to ask an administrator to check the logs", task.name)
@@ -393,6 +624,7 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 			for scanner.Scan() {
 				line := scanner.Text()
 				bot.logger.Log("ERR " + line)
+				stdErrTail = tailLines(append(stdErrTail, line), stdErrTailLines)
 			}
 			closed <- struct{}{}
 		}()
@@ -408,7 +640,22 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 			}
 		}
 	}
-	if err = cmd.Wait(); err != nil {
+	err = cmd.Wait()
+	if timer != nil {
+		timer.Stop()
+	}
+	bot.Lock()
+	killedByTimeout := timedOut
+	bot.Unlock()
+	if killedByTimeout {
+		Log(Error, fmt.Sprintf("External task '%s' exceeded timeout of %s and was killed; last lines of stderr:\n%s", task.name, timeout, strings.Join(stdErrTail, "\n")))
+		if bot.logger != nil {
+			bot.logger.Section("timeout", fmt.Sprintf("task '%s' killed after exceeding timeout of %s", task.name, timeout))
+		}
+		emit(ScriptPluginTimedOut)
+		return fmt.Sprintf("Task '%s' timed out after %s and was stopped", task.name, timeout), TimedOut
+	}
+	if err != nil {
 		retval = Fail
 		success := false
 		if exitstatus, ok := err.(*exec.ExitError); ok {
@@ -428,6 +675,17 @@ func (bot *botContext) callTask(t interface{}, command string, args ...string) (
 	return errString, retval
 }
 
+// stdErrTailLines is how many lines of stderr are logged for a timed-out task
+const stdErrTailLines = 10
+
+// tailLines returns at most the last n lines
+func tailLines(lines []string, n int) []string {
+	if len(lines) > n {
+		return lines[len(lines)-n:]
+	}
+	return lines
+}
+
 // Windows argument parsing is all over the map; try to fix it here
 // Currently powershell only
 func fixInterpreterArgs(interpreter string, args []string) []string {
@@ -3,6 +3,7 @@ package bot
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron"
 )
@@ -10,6 +11,100 @@ import (
 var taskRunner *cron.Cron
 var schedMutex sync.Mutex
 
+// activeSchedules are the schedules added to the taskRunner, and
+// pausedSchedules are the schedules not added because they were paused with
+// 'pause schedule'; both protected by schedMutex.
+var activeSchedules, pausedSchedules []*scheduledJob
+
+// Overlap policies for a scheduled task whose last run is still going
+const (
+	overlapAllow = "allow" // start another run anyway
+	overlapSkip  = "skip"  // skip this run
+	overlapQueue = "queue" // run again when the last run finishes
+)
+
+// overlapLock protects runningSchedules and queuedSchedules, which track
+// scheduled runs by task name for the skip and queue Overlap policies.
+var overlapLock sync.Mutex
+var runningSchedules = make(map[string]bool)
+var queuedSchedules = make(map[string]*scheduledJob)
+
+// storedSchedules are the schedule changes made from chat, stored in the
+// brain and merged with ScheduledTasks from gopherbot.yaml.
+type storedSchedules struct {
+	Dynamic []scheduledTask // schedules added with 'schedule <task> ...'
+	Paused  []string        // names of tasks whose schedules are paused
+}
+
+// scheduledJob is the cron Job for a scheduled task, so 'list schedules'
+// can tell which task each cron entry runs.
+type scheduledJob struct {
+	scheduledTask
+	dynamic bool // added from chat rather than configured
+	run     func()
+}
+
+// origin notes schedules added from chat when listing schedules
+func (j *scheduledJob) origin() string {
+	if j.dynamic {
+		return " (added from chat)"
+	}
+	return ""
+}
+
+// Run implements cron.Job, applying the schedule's Overlap policy when the
+// last scheduled run of the task is still going.
+func (j *scheduledJob) Run() {
+	if j.Overlap == "" || j.Overlap == overlapAllow {
+		j.run()
+		return
+	}
+	overlapLock.Lock()
+	if runningSchedules[j.Name] {
+		switch {
+		case j.Overlap == overlapSkip:
+			reportSchedule(Warn, fmt.Sprintf("Skipping scheduled run of '%s', the last run is still going", j.Name))
+		case queuedSchedules[j.Name] != nil:
+			reportSchedule(Warn, fmt.Sprintf("Skipping scheduled run of '%s', a run is already queued", j.Name))
+		default:
+			reportSchedule(Info, fmt.Sprintf("Queueing scheduled run of '%s' until the last run finishes", j.Name))
+			queuedSchedules[j.Name] = j
+		}
+		overlapLock.Unlock()
+		return
+	}
+	runningSchedules[j.Name] = true
+	overlapLock.Unlock()
+	for {
+		j.run()
+		overlapLock.Lock()
+		next, queued := queuedSchedules[j.Name]
+		if !queued {
+			delete(runningSchedules, j.Name)
+			overlapLock.Unlock()
+			return
+		}
+		delete(queuedSchedules, j.Name)
+		overlapLock.Unlock()
+		j = next
+	}
+}
+
+// reportSchedule logs status for scheduled tasks, and posts it to the
+// DefaultJobChannel when there is one; that can be "protocol:channel" for a
+// channel on a secondary protocol.
+func reportSchedule(l LogLevel, msg string) {
+	Log(l, msg)
+	robot.RLock()
+	channel := robot.defaultJobChannel
+	format := robot.defaultMessageFormat
+	robot.RUnlock()
+	if len(channel) > 0 {
+		protocol, channel := splitChannel(channel)
+		getConnector(protocol).SendProtocolChannelMessage(channel, msg, format)
+	}
+}
+
 func scheduleTasks() {
 	schedMutex.Lock()
 	if taskRunner != nil {
@@ -35,7 +130,25 @@ func scheduleTasks() {
 		sync.RWMutex{},
 	}
 	currentTasks.RUnlock()
+	var stored storedSchedules
+	if _, _, ret := checkoutDatum(schedulesKey, &stored, false); ret != Ok {
+		Log(Error, fmt.Sprintf("Unable to retrieve stored schedules, scheduling configured tasks only: %s", ret))
+	}
+	paused := make(map[string]bool)
+	for _, name := range stored.Paused {
+		paused[name] = true
+	}
+	jobs := make([]*scheduledJob, 0, len(scheduled)+len(stored.Dynamic))
 	for _, st := range scheduled {
+		jobs = append(jobs, &scheduledJob{scheduledTask: st})
+	}
+	for _, st := range stored.Dynamic {
+		jobs = append(jobs, &scheduledJob{scheduledTask: st, dynamic: true})
+	}
+	activeSchedules = []*scheduledJob{}
+	pausedSchedules = []*scheduledJob{}
+	for _, j := range jobs {
+		st := j.scheduledTask
 		t := tasks.getTaskByName(st.Name)
 		if t == nil {
 			Log(Error, fmt.Sprintf("Task not found when scheduling task: %s", st.Name))
@@ -46,13 +159,106 @@ func scheduleTasks() {
 			Log(Error, fmt.Sprintf("Not scheduling disabled task '%s'; reason: %s", st.Name, task.reason))
 			continue
 		}
+		if paused[st.Name] {
+			Log(Info, fmt.Sprintf("Not scheduling paused task '%s' with schedule: %s", st.Name, st.Schedule))
+			pausedSchedules = append(pausedSchedules, j)
+			continue
+		}
 		Log(Info, fmt.Sprintf("Scheduling job '%s' with schedule: %s", st.Name, st.Schedule))
-		taskRunner.AddFunc(st.Schedule, func() { runScheduledTask(t, st.taskSpec, tasks) })
+		j.run = func() { runScheduledTask(t, st.taskSpec, tasks) }
+		if err := taskRunner.AddJob(st.Schedule, j); err != nil {
+			Log(Error, fmt.Sprintf("Invalid schedule '%s' for task '%s': %v", st.Schedule, st.Name, err))
+			continue
+		}
+		activeSchedules = append(activeSchedules, j)
 	}
 	taskRunner.Start()
 	schedMutex.Unlock()
 }
 
+// catchUpTasks is called once at start-up to run scheduled tasks with
+// CatchUp, when the last run recorded in the task's history is older than
+// the last time it was due; i.e. the robot was down when it should have run.
+// Only jobs and plugins with HistoryLogs record runs.
+func catchUpTasks() {
+	schedMutex.Lock()
+	jobs := activeSchedules
+	schedMutex.Unlock()
+	robot.RLock()
+	tz := robot.timeZone
+	robot.RUnlock()
+	if tz == nil {
+		tz = time.Local
+	}
+	now := time.Now().In(tz)
+	caughtUp := make(map[string]bool)
+	for _, j := range jobs {
+		if !j.CatchUp || caughtUp[j.Name] {
+			continue
+		}
+		var th taskHistory
+		_, exists, ret := checkoutDatum(histPrefix+j.Name, &th, false)
+		if ret != Ok || !exists || len(th.Histories) == 0 {
+			Log(Info, fmt.Sprintf("No recorded runs for scheduled task '%s', not catching up", j.Name))
+			continue
+		}
+		last := th.Histories[len(th.Histories)-1]
+		lastRun, err := time.ParseInLocation(historyTimeFormat, last.CreateTime, tz)
+		if err != nil {
+			Log(Error, fmt.Sprintf("Parsing last run time '%s' for scheduled task '%s': %v", last.CreateTime, j.Name, err))
+			continue
+		}
+		schedule, err := cron.Parse(j.Schedule)
+		if err != nil {
+			continue
+		}
+		due := schedule.Next(lastRun)
+		if due.After(now) {
+			continue
+		}
+		caughtUp[j.Name] = true
+		reportSchedule(Info, fmt.Sprintf("Running '%s' to catch up on a missed run due at %s", j.Name, due.Format("Mon Jan 2 15:04 MST")))
+		go j.Run()
+	}
+}
+
+// checkSchedule checks that a cron spec is valid
+func checkSchedule(spec string) error {
+	_, err := cron.Parse(spec)
+	return err
+}
+
+// listSchedules returns the active cron entries for scheduled tasks, and
+// the paused schedules.
+func listSchedules() (active []*cron.Entry, paused []*scheduledJob) {
+	schedMutex.Lock()
+	defer schedMutex.Unlock()
+	if taskRunner != nil {
+		active = taskRunner.Entries()
+	}
+	return active, pausedSchedules
+}
+
+// updateSchedules applies a change to the schedules stored in the brain,
+// then reschedules tasks. The update function returns false when there's
+// nothing to change.
+func updateSchedules(update func(*storedSchedules) bool) (changed bool, ret RetVal) {
+	var stored storedSchedules
+	tok, _, ret := checkoutDatum(schedulesKey, &stored, true)
+	if ret != Ok {
+		return false, ret
+	}
+	if !update(&stored) {
+		checkinDatum(schedulesKey, tok)
+		return false, Ok
+	}
+	if ret = updateDatum(schedulesKey, tok, stored); ret != Ok {
+		return false, ret
+	}
+	scheduleTasks()
+	return true, Ok
+}
+
 func runScheduledTask(t interface{}, ts taskSpec, tasks taskList) {
 	task, plugin, _ := getTask(t)
 	isPlugin := plugin != nil
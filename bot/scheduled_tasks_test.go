@@ -0,0 +1,170 @@
+package bot
+
+/* scheduled_tasks_test.go - tests for the Overlap policies and CatchUp of
+scheduled tasks, with jobs that block until the test releases them.
+*/
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingJob is a scheduled job whose runs block until released
+type blockingJob struct {
+	sync.Mutex
+	runs    int
+	started chan struct{}
+	release chan struct{}
+}
+
+func newBlockingJob(name, overlap string) (*blockingJob, *scheduledJob) {
+	bj := &blockingJob{
+		started: make(chan struct{}, 10),
+		release: make(chan struct{}),
+	}
+	j := &scheduledJob{
+		scheduledTask: scheduledTask{
+			Schedule: "@hourly",
+			Overlap:  overlap,
+			taskSpec: taskSpec{Name: name},
+		},
+		run: func() {
+			bj.Lock()
+			bj.runs++
+			bj.Unlock()
+			bj.started <- struct{}{}
+			<-bj.release
+		},
+	}
+	return bj, j
+}
+
+func (bj *blockingJob) count() int {
+	bj.Lock()
+	defer bj.Unlock()
+	return bj.runs
+}
+
+// waitStart waits for a run of the job to start
+func (bj *blockingJob) waitStart(t *testing.T) {
+	select {
+	case <-bj.started:
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for the scheduled job to start")
+	}
+}
+
+// runInBackground starts a scheduled run, returning a channel that's closed
+// when Run returns
+func runInBackground(j *scheduledJob) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		j.Run()
+		close(done)
+	}()
+	return done
+}
+
+func TestOverlapSkip(t *testing.T) {
+	botLogger.l = log.New(ioutil.Discard, "", 0)
+	bj, j := newBlockingJob("skipper", overlapSkip)
+	done := runInBackground(j)
+	bj.waitStart(t)
+
+	// Runs while the first is still going are skipped, and return at once
+	j.Run()
+	j.Run()
+	close(bj.release)
+	<-done
+	if runs := bj.count(); runs != 1 {
+		t.Errorf("Job with Overlap 'skip' ran %d times, want 1", runs)
+	}
+
+	// Once the last run finishes, the next one runs
+	j.Run()
+	if runs := bj.count(); runs != 2 {
+		t.Errorf("Job with Overlap 'skip' ran %d times after the first run finished, want 2", runs)
+	}
+}
+
+func TestOverlapQueue(t *testing.T) {
+	botLogger.l = log.New(ioutil.Discard, "", 0)
+	bj, j := newBlockingJob("queuer", overlapQueue)
+	done := runInBackground(j)
+	bj.waitStart(t)
+
+	// The first overlapping run is queued, later ones are skipped
+	j.Run()
+	j.Run()
+	if runs := bj.count(); runs != 1 {
+		t.Errorf("Job with Overlap 'queue' ran %d times while the first run was going, want 1", runs)
+	}
+	bj.release <- struct{}{}
+	bj.waitStart(t)
+	if runs := bj.count(); runs != 2 {
+		t.Errorf("Job with Overlap 'queue' ran %d times after the first run finished, want 2", runs)
+	}
+	close(bj.release)
+	<-done
+	if runs := bj.count(); runs != 2 {
+		t.Errorf("Job with Overlap 'queue' ran %d times, want 2", runs)
+	}
+	overlapLock.Lock()
+	running := runningSchedules["queuer"]
+	overlapLock.Unlock()
+	if running {
+		t.Error("Job with Overlap 'queue' still marked running after the queued run finished")
+	}
+}
+
+// seedHistory stores a run history for a task with the given last run time
+func seedHistory(t *testing.T, fl *fakeLocker, name string, lastRun time.Time) {
+	th := taskHistory{
+		NextIndex: 1,
+		Histories: []historyLog{{0, lastRun.Format(historyTimeFormat), "schedule"}},
+	}
+	b, err := json.Marshal(th)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fl.Store(histPrefix+name, &b)
+}
+
+func TestCatchUp(t *testing.T) {
+	fl, stop := startLockerBrain(t)
+	defer stop()
+	missed, missedJob := newBlockingJob("missed", overlapAllow)
+	missedJob.CatchUp = true
+	current, currentJob := newBlockingJob("current", overlapAllow)
+	currentJob.CatchUp = true
+	close(missed.release)
+	close(current.release)
+	now := time.Now()
+	seedHistory(t, fl, "missed", now.Add(-3*time.Hour))
+	seedHistory(t, fl, "current", now)
+
+	schedMutex.Lock()
+	saved := activeSchedules
+	// The same task scheduled twice is only caught up once
+	activeSchedules = []*scheduledJob{missedJob, missedJob, currentJob}
+	schedMutex.Unlock()
+	defer func() {
+		schedMutex.Lock()
+		activeSchedules = saved
+		schedMutex.Unlock()
+	}()
+
+	catchUpTasks()
+	missed.waitStart(t)
+	time.Sleep(100 * time.Millisecond)
+	if runs := missed.count(); runs != 1 {
+		t.Errorf("Job that missed a run was caught up %d times, want 1", runs)
+	}
+	if runs := current.count(); runs != 0 {
+		t.Errorf("Job that didn't miss a run was caught up %d times, want 0", runs)
+	}
+}
@@ -32,16 +32,18 @@ func StartTest(v VersionInfo, cfgdir, logfile string, t *testing.T) (<-chan stru
 		botLogger = log.New(lf, "", log.LstdFlags)
 	}
 
-	initBot(configpath, installpath, botLogger)
+	// Brain encryption state is process-wide; each test robot starts with
+	// it off and the brain locked, like a new process.
+	encryptBrain = false
+	cryptBrain.Lock()
+	cryptBrain.initialized = false
+	cryptBrain.initializing = false
+	cryptBrain.Unlock()
 
-	initializeConnector, ok := connectors[robot.protocol]
-	if !ok {
-		botLogger.Fatalf("No connector registered with name: %s", robot.protocol)
-	}
+	initBot(configpath, installpath, botLogger)
 
-	// handler{} is just a placeholder struct for implementing the Handler interface
-	h := handler{}
-	conn := initializeConnector(h, botLogger)
+	// Initialize a connector for each configured protocol
+	conn := initializeConnectors(botLogger)
 
 	// NOTE: we use setConnector instead of passing the connector to run()
 	// because of the way Windows services run. See 'start_win.go'.
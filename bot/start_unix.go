@@ -113,14 +113,8 @@ func Start(v VersionInfo) {
 
 	initBot(configpath, installpath, botLogger)
 
-	initializeConnector, ok := connectors[robot.protocol]
-	if !ok {
-		botLogger.Fatalf("No connector registered with name: %s", robot.protocol)
-	}
-
-	// handler{} is just a placeholder struct for implementing the Handler interface
-	h := handler{}
-	conn := initializeConnector(h, botLogger)
+	// Initialize a connector for each configured protocol
+	conn := initializeConnectors(botLogger)
 
 	// NOTE: we use setConnector instead of passing the connector to run()
 	// because of the way Windows services run. See 'start_win.go'.
@@ -161,14 +161,8 @@ func Start(v VersionInfo) {
 	botLogger.Printf("Starting up with config dir: %s, and install dir: %s\n", lp, installpath)
 	initBot(configpath, installpath, botLogger)
 
-	initializeConnector, ok := connectors[robot.protocol]
-	if !ok {
-		botLogger.Fatal("No connector registered with name:", robot.protocol)
-	}
-
-	// handler{} is just a placeholder struct for implementing the Handler interface
-	h := handler{}
-	conn := initializeConnector(h, log.New(ioutil.Discard, "", 0))
+	// Initialize a connector for each configured protocol
+	conn := initializeConnectors(log.New(ioutil.Discard, "", 0))
 	setConnector(conn)
 
 	if isIntSess {
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"reflect"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/ghodss/yaml"
 )
@@ -26,6 +28,8 @@ func (r *botContext) loadTaskConfig() {
 	// copy the list of default channels (for plugins only)
 	pchan := robot.plugChannels
 	jdefchan := robot.defaultJobChannel
+	primary := robot.protocol
+	protocols := robot.protocols
 	externalPlugins := robot.externalPlugins
 	externalJobs := robot.externalJobs
 	robot.RUnlock() // we're done with bot data 'til the end
@@ -46,6 +50,20 @@ func (r *botContext) loadTaskConfig() {
 		i++
 	}
 
+	for jobname := range jobHandlers {
+		job := &botJob{
+			botTask: &botTask{
+				name:     jobname,
+				taskType: taskGo,
+				taskID:   getTaskID(jobname),
+			},
+		}
+		tlist = append(tlist, job)
+		taskIndexByID[job.botTask.taskID] = i
+		taskIndexByName[job.botTask.name] = i
+		i++
+	}
+
 	// Initial load of plugins
 	for index, script := range externalPlugins {
 		if !identifierRe.MatchString(script.Name) {
@@ -57,7 +75,7 @@ func (r *botContext) loadTaskConfig() {
 			continue
 		}
 		if _, ok := taskIndexByName[script.Name]; ok {
-			msg := fmt.Sprintf("External plugin index: #%d, name: '%s' duplicates name of builtIn or Go plugin, skipping", index, script.Name)
+			msg := fmt.Sprintf("External plugin index: #%d, name: '%s' duplicates name of builtIn or Go task, skipping", index, script.Name)
 			Log(Error, msg)
 			r.debug(msg, false)
 			continue
@@ -88,7 +106,7 @@ func (r *botContext) loadTaskConfig() {
 			continue
 		}
 		if _, ok := taskIndexByName[script.Name]; ok {
-			msg := fmt.Sprintf("External job index: #%d, name: '%s' duplicates name of builtIn or Go plugin, skipping", index, script.Name)
+			msg := fmt.Sprintf("External job index: #%d, name: '%s' duplicates name of builtIn or Go task, skipping", index, script.Name)
 			Log(Error, msg)
 			r.debug(msg, false)
 			continue
@@ -172,6 +190,15 @@ LoadLoop:
 					continue
 				}
 			}
+		} else if task.taskType == taskGo {
+			if err := yaml.Unmarshal([]byte(jobHandlers[task.name].DefaultConfig), &tcfgload); err != nil {
+				msg := fmt.Sprintf("Error unmarshalling default configuration, disabling: %v", err)
+				Log(Error, fmt.Errorf("Problem unmarshalling job default config for '%s', disabling: %v", task.name, err))
+				r.debug(msg, false)
+				task.Disabled = true
+				task.reason = msg
+				continue
+			}
 		}
 		// getConfigFile overlays the default config with configuration from the install path, then config path
 		cpath := "jobs/"
@@ -218,10 +245,11 @@ LoadLoop:
 			var hval []PluginHelp
 			var mval []InputMatcher
 			var pval []parameter
+			var tval []taskSpec
 			var val interface{}
 			skip := false
 			switch key {
-			case "Description", "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel", "User", "Path":
+			case "Description", "Elevator", "Authorizer", "AuthRequire", "NameSpace", "Channel", "User", "Path", "Timeout", "ReplyTimeout":
 				val = &strval
 			case "Parameters":
 				val = &pval
@@ -235,6 +263,8 @@ LoadLoop:
 				val = &hval
 			case "CommandMatchers", "ReplyMatchers", "MessageMatchers", "Triggers":
 				val = &mval
+			case "FailTasks", "FinalTasks":
+				val = &tval
 			case "Config":
 				skip = true
 			default:
@@ -327,6 +357,30 @@ LoadLoop:
 				task.Users = *(val.(*[]string))
 			case "HistoryLogs":
 				task.HistoryLogs = *(val.(*int))
+			case "Timeout":
+				task.Timeout = *(val.(*string))
+				timeout, err := time.ParseDuration(task.Timeout)
+				if err != nil || timeout <= 0 {
+					msg := fmt.Sprintf("Disabling task '%s' - invalid Timeout '%s', must be a positive duration like '90s' or '10m'", task.name, task.Timeout)
+					Log(Error, msg)
+					r.debug(msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
+				task.timeout = timeout
+			case "ReplyTimeout":
+				task.ReplyTimeout = *(val.(*string))
+				timeout, err := time.ParseDuration(task.ReplyTimeout)
+				if err != nil || timeout <= 0 {
+					msg := fmt.Sprintf("Disabling task '%s' - invalid ReplyTimeout '%s', must be a positive duration like '90s' or '10m'", task.name, task.ReplyTimeout)
+					Log(Error, msg)
+					r.debug(msg, false)
+					task.Disabled = true
+					task.reason = msg
+					continue LoadLoop
+				}
+				task.replyTimeout = timeout
 			case "Authorizer":
 				task.Authorizer = *(val.(*string))
 			case "AuthRequire":
@@ -405,6 +459,18 @@ LoadLoop:
 				} else {
 					job.RequiredParameters = *(val.(*[]string))
 				}
+			case "FailTasks":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.FailTasks = *(val.(*[]taskSpec))
+				}
+			case "FinalTasks":
+				if isPlugin {
+					mismatch = true
+				} else {
+					job.FinalTasks = *(val.(*[]taskSpec))
+				}
 			case "Config":
 				task.Config = value
 			}
@@ -476,6 +542,20 @@ LoadLoop:
 			}
 		} else {
 			task.Channels = []string{task.Channel}
+			// With more than one protocol, a plain job channel is on the
+			// primary protocol, where scheduled runs send their messages.
+			if len(protocols) > 1 && len(task.Channel) > 0 {
+				qualified := false
+				for _, p := range protocols {
+					if strings.HasPrefix(task.Channel, p.Protocol+":") {
+						qualified = true
+						break
+					}
+				}
+				if !qualified {
+					task.Channels = []string{primary + ":" + task.Channel}
+				}
+			}
 		}
 
 		// Considering possible default channels, is the plugin visible anywhere?
@@ -547,6 +627,16 @@ LoadLoop:
 				} else {
 					trigger.re = re
 				}
+				for _, param := range trigger.Parameters {
+					if !identifierRe.MatchString(param) {
+						msg := fmt.Sprintf("Disabling %s, invalid trigger parameter name '%s'; doesn't match regex '%s'", task.name, param, identifierRe.String())
+						Log(Error, msg)
+						r.debug(msg, false)
+						task.Disabled = true
+						task.reason = msg
+						continue LoadLoop
+					}
+				}
 			}
 		}
 		for i := range task.ReplyMatchers {
@@ -606,42 +696,49 @@ LoadLoop:
 					}
 				}
 			}
-			// For Go plugins, use the provided empty config struct to go ahead
-			// and unmarshall Config. The GetTaskConfig call just sets a pointer
-			// without unmshalling again.
-			if plugin.taskType == taskGo {
-				// Copy the pointer to the empty config struct / empty struct (when no config)
-				// pluginHandlers[name].Config is an empty struct for unmarshalling provided
-				// in RegisterPlugin.
-				pt := reflect.ValueOf(pluginHandlers[task.name].Config)
-				if pt.Kind() == reflect.Ptr {
-					if task.Config != nil {
-						// reflect magic: create a pointer to a new empty config struct for the plugin
-						task.config = reflect.New(reflect.Indirect(pt).Type()).Interface()
-						if err := json.Unmarshal(task.Config, task.config); err != nil {
-							msg := fmt.Sprintf("Error unmarshalling plugin config json to config, disabling: %v", err)
-							Log(Error, msg)
-							r.debug(msg, false)
-							task.Disabled = true
-							task.reason = msg
-							continue
-						}
-					} else {
-						// Providing custom config not required (should it be?)
-						msg := fmt.Sprintf("Plugin '%s' has custom config, but none is configured", task.name)
-						Log(Warn, msg)
-						r.debug(msg, false)
-					}
-				} else {
-					if task.Config != nil {
-						msg := fmt.Sprintf("Custom configuration data provided for Go plugin '%s', but no config struct was registered; disabling", task.name)
+		}
+
+		// For Go tasks, use the provided empty config struct to go ahead
+		// and unmarshall Config. The GetTaskConfig call just sets a pointer
+		// without unmshalling again.
+		if task.taskType == taskGo {
+			// Copy the pointer to the empty config struct / empty struct (when no config)
+			// pluginHandlers[name].Config / jobHandlers[name].Config is an empty
+			// struct for unmarshalling provided in RegisterPlugin / RegisterJob.
+			var cfgStruct interface{}
+			if isPlugin {
+				cfgStruct = pluginHandlers[task.name].Config
+			} else {
+				cfgStruct = jobHandlers[task.name].Config
+			}
+			pt := reflect.ValueOf(cfgStruct)
+			if pt.Kind() == reflect.Ptr {
+				if task.Config != nil {
+					// reflect magic: create a pointer to a new empty config struct for the task
+					task.config = reflect.New(reflect.Indirect(pt).Type()).Interface()
+					if err := json.Unmarshal(task.Config, task.config); err != nil {
+						msg := fmt.Sprintf("Error unmarshalling task config json to config, disabling: %v", err)
 						Log(Error, msg)
 						r.debug(msg, false)
 						task.Disabled = true
 						task.reason = msg
-					} else {
-						Log(Debug, fmt.Sprintf("Config interface isn't a pointer, skipping unmarshal for Go plugin '%s'", task.name))
+						continue
 					}
+				} else {
+					// Providing custom config not required (should it be?)
+					msg := fmt.Sprintf("Task '%s' has custom config, but none is configured", task.name)
+					Log(Warn, msg)
+					r.debug(msg, false)
+				}
+			} else {
+				if task.Config != nil {
+					msg := fmt.Sprintf("Custom configuration data provided for Go task '%s', but no config struct was registered; disabling", task.name)
+					Log(Error, msg)
+					r.debug(msg, false)
+					task.Disabled = true
+					task.reason = msg
+				} else {
+					Log(Debug, fmt.Sprintf("Config interface isn't a pointer, skipping unmarshal for Go task '%s'", task.name))
 				}
 			}
 		}
@@ -4,13 +4,22 @@ package bot
 
 import "strconv"
 
-const _TaskRetVal_name = "NormalFailMechanismFailConfigurationError"
+const (
+	_TaskRetVal_name_0 = "TimedOut"
+	_TaskRetVal_name_1 = "NormalFailMechanismFailConfigurationError"
+)
 
-var _TaskRetVal_index = [...]uint8{0, 6, 10, 23, 41}
+var (
+	_TaskRetVal_index_1 = [...]uint8{0, 6, 10, 23, 41}
+)
 
 func (i TaskRetVal) String() string {
-	if i < 0 || i >= TaskRetVal(len(_TaskRetVal_index)-1) {
+	switch {
+	case i == -2:
+		return _TaskRetVal_name_0
+	case 0 <= i && i <= 3:
+		return _TaskRetVal_name_1[_TaskRetVal_index_1[i]:_TaskRetVal_index_1[i+1]]
+	default:
 		return "TaskRetVal(" + strconv.FormatInt(int64(i), 10) + ")"
 	}
-	return _TaskRetVal_name[_TaskRetVal_index[i]:_TaskRetVal_index[i+1]]
 }
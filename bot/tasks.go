@@ -8,6 +8,7 @@ import (
 	"regexp"
 	"strings"
 	"sync"
+	"time"
 )
 
 // PluginNames can be letters, numbers & underscores only, mainly so
@@ -100,6 +101,45 @@ type taskSpec struct {
 	// make calls to SetParameter()
 	Parameters []parameter
 	task       interface{} // populated in AddTask
+	// A group of tasks added with AddParallelTasks, and the most to run at
+	// once; 0 for no limit
+	parallel      []taskSpec
+	parallelLimit int
+}
+
+// newTaskSpec looks up a task by name, returning a taskSpec for a plugin
+// command and arguments, or for a job with parameters in the form NAME=value;
+// for ScheduleTask and AddParallelTasks.
+func (tl *taskList) newTaskSpec(name string, cmdargs []string) (taskSpec, RetVal) {
+	t := tl.getTaskByName(name)
+	if t == nil {
+		return taskSpec{}, TaskNotFound
+	}
+	ts := taskSpec{Name: name, task: t}
+	if _, plugin, _ := getTask(t); plugin != nil {
+		if len(cmdargs) == 0 || len(cmdargs[0]) == 0 {
+			return ts, MissingArguments
+		}
+		ts.Command, ts.Arguments = cmdargs[0], cmdargs[1:]
+		return ts, Ok
+	}
+	for _, arg := range cmdargs {
+		p := strings.SplitN(arg, "=", 2)
+		if len(p) != 2 || len(p[0]) == 0 {
+			return ts, MissingArguments
+		}
+		ts.Parameters = append(ts.Parameters, parameter{p[0], p[1]})
+	}
+	return ts, Ok
+}
+
+// command returns the command for running the task; jobs are always called
+// with "run".
+func (ts taskSpec) command() string {
+	if _, plugin, _ := getTask(ts.task); plugin != nil {
+		return ts.Command
+	}
+	return "run"
 }
 
 // parameters are provided to jobs and plugins as environment variables
@@ -120,6 +160,8 @@ type externalJob struct {
 // items in gopherbot.yaml
 type scheduledTask struct {
 	Schedule string // timespec for https://godoc.org/github.com/robfig/cron
+	CatchUp  bool   // run once at start-up if the robot was down when the task was due
+	Overlap  string // when the last run is still going: "allow" (default) another, "skip" or "queue" one
 	taskSpec
 }
 
@@ -139,8 +181,33 @@ const (
 	jobTrigger
 	scheduled
 	runJob
+	webhook
+	delayed
 )
 
+// String describes how a pipeline was started, for run histories
+func (p pipelineType) String() string {
+	switch p {
+	case plugCommand:
+		return "command"
+	case plugMessage:
+		return "message"
+	case catchAll:
+		return "catchall"
+	case jobTrigger:
+		return "trigger"
+	case scheduled:
+		return "schedule"
+	case runJob:
+		return "run job"
+	case webhook:
+		return "webhook"
+	case delayed:
+		return "delayed"
+	}
+	return "unknown"
+}
+
 // InputMatcher specifies the command or message to match for a plugin, or user and message to trigger a job
 type InputMatcher struct {
 	Regex      string         // The regular expression string to match - bot adds ^\w* & \w*$
@@ -168,6 +235,10 @@ type botTask struct {
 	PrivateNameSpace bool            // when set for tasks, memories will be stored/retrieved from task namespace instead of pipeline
 	Description      string          // description of job or plugin
 	HistoryLogs      int             // how many runs of this job/plugin to keep history for
+	Timeout          string          // maximum run time for an external task, e.g. "90s"; overrides DefaultTaskTimeout
+	timeout          time.Duration   // parsed Timeout
+	ReplyTimeout     string          // how long prompts from this task wait for a reply, e.g. "5m"; overrides the robot's ReplyTimeout
+	replyTimeout     time.Duration   // parsed ReplyTimeout
 	AllowDirect      bool            // Set this true if this plugin can be accessed via direct message
 	DirectOnly       bool            // Set this true if this plugin ONLY accepts direct messages
 	Channel          string          // channel where a job can be interracted with, channel where a scheduled task (job or plugin) runs
@@ -193,6 +264,8 @@ type botJob struct {
 	Triggers           []InputMatcher // user/regex that triggers a job, e.g. a git-activated webhook or integration
 	Parameters         []parameter    // Fixed parameters for a given job; many jobs will use the same script with differing parameters
 	RequiredParameters []string       // required in schedule, prompted to user for interactive
+	FailTasks          []taskSpec     // tasks to run when the job's pipeline fails
+	FinalTasks         []taskSpec     // tasks to run when the job's pipeline finishes, even if it failed
 	*botTask
 }
 
@@ -220,6 +293,17 @@ type PluginHandler struct {
 
 var pluginHandlers = make(map[string]PluginHandler)
 
+// JobHandler is the struct a Go job registers for the Gopherbot job API.
+// Go jobs are configured in conf/jobs/<jobname>.yaml, and run in pipelines
+// and schedules just like external jobs.
+type JobHandler struct {
+	DefaultConfig string                                      // A yaml-formatted multiline string defining the default job configuration; see PluginHandler
+	Handler       func(bot *Robot, args ...string) TaskRetVal // The callback function called by the robot when the job runs; parameters are available with GetParameter
+	Config        interface{}                                 // An optional empty struct defining custom configuration for the job
+}
+
+var jobHandlers = make(map[string]JobHandler)
+
 // stopRegistrations is set "true" when the bot is created to prevent registration outside of init functions
 var stopRegistrations = false
 
@@ -285,9 +369,30 @@ func RegisterPlugin(name string, plug PluginHandler) {
 	if _, exists := pluginHandlers[name]; exists {
 		log.Fatalf("Attempted plugin name registration duplicates builtIn or other Go plugin: %s", name)
 	}
+	if _, exists := jobHandlers[name]; exists {
+		log.Fatalf("Attempted plugin name registration duplicates Go job: %s", name)
+	}
 	pluginHandlers[name] = plug
 }
 
+// RegisterJob allows Go jobs to register a JobHandler in a func init().
+// Unlike plugins, jobs aren't sent an "init" command.
+func RegisterJob(name string, job JobHandler) {
+	if stopRegistrations {
+		return
+	}
+	if !identifierRe.MatchString(name) {
+		log.Fatalf("Job name '%s' doesn't match task name regex '%s'", name, identifierRe.String())
+	}
+	if _, exists := pluginHandlers[name]; exists {
+		log.Fatalf("Attempted job name registration duplicates builtIn or other Go plugin: %s", name)
+	}
+	if _, exists := jobHandlers[name]; exists {
+		log.Fatalf("Attempted job name registration duplicates other Go job: %s", name)
+	}
+	jobHandlers[name] = job
+}
+
 func getTaskID(plug string) string {
 	taskNameIDmap.Lock()
 	taskID, ok := taskNameIDmap.m[plug]
@@ -0,0 +1,65 @@
+// +build integration
+
+package bot_test
+
+/* threads_integration_test.go - tests for replies and prompts in threads,
+using the test connector's thread messages.
+*/
+
+import (
+	"regexp"
+	"testing"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+// expectThreadMessage checks for a message from the robot in a given thread;
+// an empty thread means the message shouldn't be in a thread
+func expectThreadMessage(t *testing.T, conn *testc.TestConnector, want testc.TestMessage, thread string) {
+	got, err := conn.GetBotThreadMessage()
+	if err != nil {
+		t.Errorf("FAILED timeout waiting for reply from robot; want: \"%s\"", want.Message)
+		return
+	}
+	if !regexp.MustCompile(want.Message).MatchString(got.Message) || got.User != want.User || got.Channel != want.Channel || got.Thread != thread {
+		t.Errorf("FAILED message match; want u:%s, c:%s, t:%s, m:%s; got u:%s, c:%s, t:%s, m:%s", want.User, want.Channel, thread, want.Message, got.User, got.Channel, got.Thread, got.Message)
+	}
+}
+
+// threadMessage makes a message to the robot in a thread
+func threadMessage(user, channel, thread string, threaded bool, message string) *testc.ThreadMessage {
+	return &testc.ThreadMessage{
+		TestMessage: testc.TestMessage{User: user, Channel: channel, Message: message},
+		Thread:      thread,
+		Threaded:    threaded,
+	}
+}
+
+func TestThreads(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottest.log", t)
+
+	// Replies to a message in a thread stay in the thread, for Go and
+	// external plugins
+	conn.SendBotThreadMessage(threadMessage(alice, general, "1000.1", true, ";ping"))
+	expectThreadMessage(t, conn, testc.TestMessage{alice, general, "PONG"}, "1000.1")
+	conn.SendBotThreadMessage(threadMessage(bob, general, "1000.1", true, "bender: echo hello thread"))
+	expectThreadMessage(t, conn, testc.TestMessage{null, general, "hello thread"}, "1000.1")
+	// ... but not for messages outside a thread
+	conn.SendBotThreadMessage(threadMessage(alice, general, "1000.2", false, ";ping"))
+	expectThreadMessage(t, conn, testc.TestMessage{alice, general, "PONG"}, "")
+	// Threaded() starts a thread from the message
+	conn.SendBotThreadMessage(threadMessage(alice, general, "1000.3", false, ";thread"))
+	expectThreadMessage(t, conn, testc.TestMessage{alice, general, "replying in a thread"}, "1000.3")
+
+	// Prompts in a thread only match replies in the same thread
+	conn.SendBotThreadMessage(threadMessage(alice, general, "1000.4", true, ";repeat"))
+	expectThreadMessage(t, conn, testc.TestMessage{alice, general, `What do you want me to repeat\?`}, "1000.4")
+	conn.SendBotMessage(&testc.TestMessage{alice, general, "not in the thread"})
+	conn.SendBotThreadMessage(threadMessage(alice, general, "1000.5", true, "in another thread"))
+	conn.SendBotThreadMessage(threadMessage(alice, general, "1000.4", true, "in the thread"))
+	expectThreadMessage(t, conn, testc.TestMessage{alice, general, "in the thread"}, "1000.4")
+	GetEvents()
+
+	teardown(t, done, conn)
+}
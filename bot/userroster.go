@@ -0,0 +1,113 @@
+package bot
+
+/* userroster.go - the UserRoster from gopherbot.yaml, which supplies user
+attributes that the chat protocol doesn't provide, like an email address for
+IRC users, or a Duo username.
+*/
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rosterUser is an entry in the UserRoster
+type rosterUser struct {
+	UserName      string            // The user's name in chat
+	ProtocolUsers map[string]string // The user's name for protocols where it differs from UserName, e.g. {"irc": "alice_"}
+	Email         string            // Email address, e.g. for Robot.Email
+	Phone         string            // Phone number
+	DuoUser       string            // Username for Duo two-factor authentication
+	TimeZone      string            // e.g. "America/New_York"
+	Attributes    map[string]string // Any other attributes, e.g. {"team": "ops"}
+}
+
+// userRoster indexes the UserRoster by user name, and by protocol and name
+// for users with ProtocolUsers
+type userRoster struct {
+	byName     map[string]*rosterUser
+	byProtocol map[string]map[string]*rosterUser
+}
+
+// loadRoster indexes the configured UserRoster
+func loadRoster(users []rosterUser) userRoster {
+	roster := userRoster{
+		byName:     make(map[string]*rosterUser),
+		byProtocol: make(map[string]map[string]*rosterUser),
+	}
+	for i := range users {
+		u := &users[i]
+		if len(u.UserName) == 0 {
+			Log(Error, fmt.Sprintf("Zero-length UserName for UserRoster entry #%d, skipping", i))
+			continue
+		}
+		roster.byName[u.UserName] = u
+		for protocol, name := range u.ProtocolUsers {
+			if roster.byProtocol[protocol] == nil {
+				roster.byProtocol[protocol] = make(map[string]*rosterUser)
+			}
+			roster.byProtocol[protocol][name] = u
+		}
+	}
+	return roster
+}
+
+// lookup finds the roster entry for a user of a protocol; a user with a
+// different name for the protocol in ProtocolUsers isn't found by UserName.
+func (ur userRoster) lookup(protocol, user string) (*rosterUser, bool) {
+	if u, ok := ur.byProtocol[protocol][user]; ok {
+		return u, true
+	}
+	u, ok := ur.byName[user]
+	if !ok {
+		return nil, false
+	}
+	if _, renamed := u.ProtocolUsers[protocol]; renamed {
+		return nil, false
+	}
+	return u, true
+}
+
+// attribute returns a roster attribute for a user, or "" if the user isn't
+// in the roster or doesn't have the attribute. The attribute should already
+// be lowercase.
+func (ur userRoster) attribute(protocol, user, attr string) string {
+	u, ok := ur.lookup(protocol, user)
+	if !ok {
+		return ""
+	}
+	switch attr {
+	case "email":
+		return u.Email
+	case "phone":
+		return u.Phone
+	case "duouser", "duo user":
+		return u.DuoUser
+	case "timezone", "time zone":
+		return u.TimeZone
+	}
+	for name, value := range u.Attributes {
+		if strings.ToLower(name) == attr {
+			return value
+		}
+	}
+	return ""
+}
+
+// getUserAttribute looks up a user attribute from the connector,
+// supplemented by the UserRoster for attributes the connector doesn't have.
+func getUserAttribute(protocol, user, attr string) (string, RetVal) {
+	value, ret := getConnector(protocol).GetProtocolUserAttribute(user, attr)
+	if ret == Ok && len(value) > 0 {
+		return value, ret
+	}
+	robot.RLock()
+	roster := robot.userRoster
+	if len(protocol) == 0 {
+		protocol = robot.protocol
+	}
+	robot.RUnlock()
+	if rosterValue := roster.attribute(protocol, user, attr); len(rosterValue) > 0 {
+		return rosterValue, Ok
+	}
+	return value, ret
+}
@@ -0,0 +1,30 @@
+// +build integration
+
+package bot_test
+
+/* userroster_integration_test.go - tests for user attributes from the
+UserRoster in gopherbot.yaml.
+*/
+
+import (
+	"testing"
+
+	. "github.com/lnxjedi/gopherbot/bot"
+	testc "github.com/lnxjedi/gopherbot/connectors/test"
+)
+
+func TestUserRoster(t *testing.T) {
+	done, conn := setup("cfg/test/membrain", "/tmp/bottest.log", t)
+
+	tests := []testItem{
+		// erin is mapped by ProtocolUsers; the connector's email wins over the roster
+		{erin, general, ";whois", []testc.TestMessage{{null, general, `^email: erin@example.com, time zone: America/New_York, team: ops$`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		// bob is found by UserName
+		{bob, general, ";whois", []testc.TestMessage{{null, general, `^email: bob@example.com, time zone: Europe/London, team: dev$`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+		// carol has a different name for the test protocol, so isn't found as carol
+		{carol, general, ";whois", []testc.TestMessage{{null, general, `^email: @example.com, time zone: , team: $`}}, []Event{CommandTaskRan, ScriptTaskRan}, 0},
+	}
+	testcases(t, conn, tests)
+
+	teardown(t, done, conn)
+}
@@ -43,6 +43,10 @@ func setProtocol(proto string) Protocol {
 		return Slack
 	case "term", "terminal":
 		return Terminal
+	case "irc":
+		return IRC
+	case "mattermost":
+		return Mattermost
 	default:
 		return Test
 	}
@@ -52,7 +56,31 @@ func updateRegexes() {
 	robot.RLock()
 	name := robot.name
 	alias := robot.alias
+	protocolNames := make(map[string]string)
+	for protocol, pname := range robot.protocolNames {
+		protocolNames[protocol] = pname
+	}
 	robot.RUnlock()
+	primary := compileRegexes(name, alias)
+	protocolRegexes := make(map[string]nameRegexes)
+	for protocol, pname := range protocolNames {
+		protocolRegexes[protocol] = compileRegexes(pname, alias)
+	}
+	robot.Lock()
+	robot.preRegex = primary.pre
+	robot.postRegex = primary.post
+	robot.bareRegex = primary.bare
+	robot.protocolRegexes = protocolRegexes
+	robot.Unlock()
+}
+
+// nameRegexes holds the regexes for addressing the robot by a given name
+type nameRegexes struct {
+	pre, post, bare *regexp.Regexp
+}
+
+// compileRegexes generates and logs the regexes for a name
+func compileRegexes(name string, alias rune) nameRegexes {
 	pre, post, bare, errpre, errpost, errbare := updateRegexesWrapped(name, alias)
 	if errpre != nil {
 		Log(Error, fmt.Sprintf("Error compiling pre regex: %s", errpre))
@@ -72,11 +100,7 @@ func updateRegexes() {
 	if bare != nil {
 		Log(Debug, fmt.Sprintf("Setting bare regex to: %s", bare))
 	}
-	robot.Lock()
-	robot.preRegex = pre
-	robot.postRegex = post
-	robot.bareRegex = bare
-	robot.Unlock()
+	return nameRegexes{pre, post, bare}
 }
 
 // TODO: write unit test. The regexes produced shouldn't be checked, but rather
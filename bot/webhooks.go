@@ -0,0 +1,259 @@
+package bot
+
+/* webhooks.go - a separate HTTP listener for starting jobs from GitHub,
+GitLab, Jenkins and other services that send webhooks. Unlike the localhost
+JSON API in http.go, the webhook listener is normally exposed to the network,
+so every request has to be verified with a shared secret.
+*/
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// webhookEndpoint maps an endpoint on the webhook listener to a job
+type webhookEndpoint struct {
+	Endpoint   string             // the webhook is served at /webhook/<Endpoint>
+	Job        string             // name of the job to start
+	Verify     string             // "hmac" (default) for a hex-encoded HMAC-SHA256 signature of the payload, or "token" for a shared token
+	Header     string             // header with the signature or token; defaults to X-Hub-Signature-256 for hmac and X-Webhook-Token for token
+	Secret     string             // shared secret for the signature or token
+	User       string             // user the job runs as, defaults to "webhook"
+	Channel    string             // channel for the job, defaults to the job's Channel
+	Parameters []webhookParameter // job parameters extracted from the request
+}
+
+// webhookParameter sets a job parameter from a field in the JSON payload, or
+// a request header
+type webhookParameter struct {
+	Name   string // name of the parameter (environment variable) for the job
+	Field  string // dotted path to a value in the payload, e.g. "repository.full_name" or "commits.0.id"
+	Header string // alternatively, a request header, e.g. "X-GitHub-Event"
+}
+
+const webhookPath = "/webhook/"
+
+// Payloads larger than this are refused
+const maxWebhookPayload = 1 << 20
+
+// checkWebhooks validates configured webhooks, returning a map of endpoints;
+// misconfigured webhooks are logged and skipped.
+func checkWebhooks(hooks []webhookEndpoint) map[string]webhookEndpoint {
+	wm := make(map[string]webhookEndpoint)
+	for _, h := range hooks {
+		if len(h.Endpoint) == 0 || len(h.Job) == 0 {
+			Log(Error, fmt.Sprintf("Zero-length Endpoint (%s) or Job (%s) in Webhooks, skipping", h.Endpoint, h.Job))
+			continue
+		}
+		if identifierRe.FindString(h.Endpoint) != h.Endpoint {
+			Log(Error, fmt.Sprintf("Invalid webhook Endpoint '%s', must match '%s'; skipping", h.Endpoint, identifierRe.String()))
+			continue
+		}
+		if _, exists := wm[h.Endpoint]; exists {
+			Log(Error, fmt.Sprintf("Duplicate webhook Endpoint '%s', skipping", h.Endpoint))
+			continue
+		}
+		if len(h.Secret) == 0 {
+			Log(Error, fmt.Sprintf("No Secret configured for webhook '%s', skipping", h.Endpoint))
+			continue
+		}
+		// Parameters become environment variables for the job
+		validParams := true
+		for _, p := range h.Parameters {
+			if len(p.Name) == 0 || identifierRe.FindString(p.Name) != p.Name {
+				Log(Error, fmt.Sprintf("Invalid Parameter Name '%s' for webhook '%s', must match '%s'; skipping", p.Name, h.Endpoint, identifierRe.String()))
+				validParams = false
+			}
+		}
+		if !validParams {
+			continue
+		}
+		switch h.Verify {
+		case "", "hmac":
+			h.Verify = "hmac"
+			if len(h.Header) == 0 {
+				h.Header = "X-Hub-Signature-256"
+			}
+		case "token":
+			if len(h.Header) == 0 {
+				h.Header = "X-Webhook-Token"
+			}
+		default:
+			Log(Error, fmt.Sprintf("Invalid Verify '%s' for webhook '%s', must be 'hmac' or 'token'; skipping", h.Verify, h.Endpoint))
+			continue
+		}
+		if len(h.User) == 0 {
+			h.User = "webhook"
+		}
+		wm[h.Endpoint] = h
+	}
+	return wm
+}
+
+// verify checks the signature or token for a webhook request
+func (h webhookEndpoint) verify(r *http.Request, payload []byte) bool {
+	value := r.Header.Get(h.Header)
+	if len(value) == 0 {
+		return false
+	}
+	if h.Verify == "token" {
+		return subtle.ConstantTimeCompare([]byte(value), []byte(h.Secret)) == 1
+	}
+	// GitHub prefixes the signature with the algorithm
+	signature, err := hex.DecodeString(strings.TrimPrefix(value, "sha256="))
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write(payload)
+	return hmac.Equal(signature, mac.Sum(nil))
+}
+
+// payloadField looks up a dotted path in a decoded JSON payload; array
+// elements are selected by index.
+func payloadField(payload interface{}, field string) (string, bool) {
+	v := payload
+	for _, f := range strings.Split(field, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = t[f]; !ok {
+				return "", false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(f)
+			if err != nil || i < 0 || i >= len(t) {
+				return "", false
+			}
+			v = t[i]
+		default:
+			return "", false
+		}
+	}
+	switch t := v.(type) {
+	case nil:
+		return "", false
+	case string:
+		return t, true
+	case json.Number:
+		return t.String(), true
+	case bool:
+		return strconv.FormatBool(t), true
+	default:
+		// Objects and arrays are passed as JSON
+		b, _ := json.Marshal(t)
+		return string(b), true
+	}
+}
+
+type webhookHandler struct{}
+
+// ServeHTTP verifies a webhook request and starts the configured job
+func (webhookHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	endpoint := strings.TrimPrefix(r.URL.Path, webhookPath)
+	robot.RLock()
+	hook, ok := robot.webhooks[endpoint]
+	shuttingDown := robot.shuttingDown
+	robot.RUnlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if shuttingDown {
+		http.Error(w, "Robot is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	payload, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookPayload))
+	if err != nil {
+		Log(Warn, fmt.Sprintf("Reading payload for webhook '%s' from %s: %v", endpoint, r.RemoteAddr, err))
+		http.Error(w, "Error reading payload", http.StatusBadRequest)
+		return
+	}
+	if !hook.verify(r, payload) {
+		Log(Warn, fmt.Sprintf("Verification failed for webhook '%s' from %s", endpoint, r.RemoteAddr))
+		http.Error(w, "Verification failed", http.StatusUnauthorized)
+		return
+	}
+	environment := make(map[string]string)
+	var decoded interface{}
+	parsed := false
+	for _, p := range hook.Parameters {
+		if len(p.Header) > 0 {
+			if value := r.Header.Get(p.Header); len(value) > 0 {
+				environment[p.Name] = value
+			}
+			continue
+		}
+		if !parsed {
+			d := json.NewDecoder(bytes.NewReader(payload))
+			d.UseNumber()
+			if err := d.Decode(&decoded); err != nil {
+				Log(Warn, fmt.Sprintf("Decoding JSON payload for webhook '%s': %v", endpoint, err))
+				http.Error(w, "Invalid JSON payload", http.StatusBadRequest)
+				return
+			}
+			parsed = true
+		}
+		if value, ok := payloadField(decoded, p.Field); ok {
+			environment[p.Name] = value
+		} else {
+			Log(Debug, fmt.Sprintf("Field '%s' not found in payload for webhook '%s'", p.Field, endpoint))
+		}
+	}
+
+	// runPipeline will take care of registerActive()
+	bot := &botContext{
+		User:                 hook.User,
+		bypassSecurityChecks: true, // verified by the shared secret
+		environment:          environment,
+	}
+	// The pipeline gets the current task maps; the lock isn't copied
+	currentTasks.RLock()
+	bot.tasks.t = currentTasks.t
+	bot.tasks.nameMap = currentTasks.nameMap
+	bot.tasks.idMap = currentTasks.idMap
+	bot.tasks.nameSpaces = currentTasks.nameSpaces
+	currentTasks.RUnlock()
+	t := bot.tasks.getTaskByName(hook.Job)
+	if t == nil {
+		Log(Error, fmt.Sprintf("Job '%s' not found for webhook '%s'", hook.Job, endpoint))
+		http.Error(w, "Job not available", http.StatusInternalServerError)
+		return
+	}
+	task, _, job := getTask(t)
+	if job == nil || task.Disabled {
+		Log(Error, fmt.Sprintf("Webhook '%s' configured with disabled task or non-job '%s'", endpoint, hook.Job))
+		http.Error(w, "Job not available", http.StatusInternalServerError)
+		return
+	}
+	bot.Channel = hook.Channel
+	if len(bot.Channel) == 0 {
+		bot.Channel = task.Channel
+	}
+	Log(Info, fmt.Sprintf("Webhook '%s' from %s started job '%s'", endpoint, r.RemoteAddr, task.name))
+	go bot.runPipeline(t, false, webhook, "run")
+	w.WriteHeader(http.StatusAccepted)
+	fmt.Fprintf(w, "Started job '%s'\n", task.name)
+}
+
+// serveWebhooks runs the webhook listener, on it's own ServeMux so the
+// localhost JSON API isn't exposed.
+func serveWebhooks(address string) {
+	mux := http.NewServeMux()
+	mux.Handle(webhookPath, webhookHandler{})
+	Log(Info, fmt.Sprintf("Listening for webhooks on %s", address))
+	Log(Fatal, http.ListenAndServe(address, mux))
+}
@@ -0,0 +1,43 @@
+package bot
+
+/* webhooks_test.go - tests for validating configured webhooks; misconfigured
+webhooks are skipped.
+*/
+
+import (
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+func TestCheckWebhooks(t *testing.T) {
+	botLogger.l = log.New(ioutil.Discard, "", 0)
+	param := func(name string) []webhookParameter {
+		return []webhookParameter{{Name: "TARGET", Field: "repository.name"}, {Name: name, Header: "X-Event"}}
+	}
+	hooks := []webhookEndpoint{
+		{Endpoint: "deploy", Job: "deploy", Secret: "secret", Parameters: param("EVENT")},
+		{Endpoint: "token", Job: "deploy", Secret: "secret", Verify: "token"},
+		{Endpoint: "bad endpoint", Job: "deploy", Secret: "secret"},
+		{Endpoint: "deploy", Job: "other", Secret: "secret"},
+		{Endpoint: "nosecret", Job: "deploy"},
+		{Endpoint: "badverify", Job: "deploy", Secret: "secret", Verify: "md5"},
+		{Endpoint: "spaces", Job: "deploy", Secret: "secret", Parameters: param("GIT EVENT")},
+		{Endpoint: "equals", Job: "deploy", Secret: "secret", Parameters: param("EVENT=push")},
+		{Endpoint: "empty", Job: "deploy", Secret: "secret", Parameters: param("")},
+	}
+	wm := checkWebhooks(hooks)
+	if len(wm) != 2 {
+		t.Errorf("checkWebhooks returned %d endpoints, want 2: %v", len(wm), wm)
+	}
+	deploy, ok := wm["deploy"]
+	if !ok {
+		t.Fatal("Valid webhook 'deploy' was skipped")
+	}
+	if deploy.Job != "deploy" || deploy.Verify != "hmac" || deploy.Header != "X-Hub-Signature-256" || deploy.User != "webhook" {
+		t.Errorf("Webhook 'deploy' has Job '%s', Verify '%s', Header '%s', User '%s'; want the first 'deploy' with defaults", deploy.Job, deploy.Verify, deploy.Header, deploy.User)
+	}
+	if token, ok := wm["token"]; !ok || token.Header != "X-Webhook-Token" {
+		t.Errorf("Webhook 'token' missing or has the wrong Header: %+v", token)
+	}
+}
@@ -0,0 +1,116 @@
+// Package boltBrain is a single-file embedded implementation of the
+// bot.SimpleBrain interface using BoltDB. Every Store is a transaction that's
+// synced to disk before returning, so memories are never partially written.
+package boltBrain
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+var robot bot.Handler
+var db *bolt.DB
+
+// All memories are stored in a single bucket
+var memories = []byte("memories")
+
+type brainConfig struct {
+	BrainFile string // path to the database file; relative to the config directory if not absolute
+}
+
+var bc brainConfig
+
+func (bc *brainConfig) Store(k string, b *[]byte) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(memories).Put([]byte(k), *b)
+	})
+	if err != nil {
+		return fmt.Errorf("Storing datum \"%s\": %v", k, err)
+	}
+	return nil
+}
+
+func (bc *brainConfig) Retrieve(k string) (*[]byte, bool, error) {
+	var datum []byte
+	err := db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(memories).Get([]byte(k))
+		if v != nil {
+			// v is only valid for the life of the transaction
+			datum = make([]byte, len(v))
+			copy(datum, v)
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("Retrieving datum \"%s\": %v", k, err)
+		robot.Log(bot.Error, err)
+		return nil, false, err
+	}
+	if datum == nil { // Memory doesn't exist yet
+		return nil, false, nil
+	}
+	return &datum, true, nil
+}
+
+func (bc *brainConfig) Delete(k string) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(memories).Delete([]byte(k))
+	})
+	if err != nil {
+		return fmt.Errorf("Deleting datum \"%s\": %v", k, err)
+	}
+	return nil
+}
+
+func (bc *brainConfig) List(prefix string) ([]string, error) {
+	keys := make([]string, 0)
+	err := db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(memories).Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Listing keys with prefix \"%s\": %v", prefix, err)
+	}
+	return keys, nil
+}
+
+// The bolt brain doesn't need the logger, but other brains might
+func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
+	robot = r
+	robot.GetBrainConfig(&bc)
+	if len(bc.BrainFile) == 0 {
+		robot.Log(bot.Fatal, "BrainConfig missing value for BrainFile required by 'bolt' brain")
+	}
+	brainFile := bc.BrainFile
+	if !filepath.IsAbs(brainFile) {
+		brainFile = filepath.Join(robot.GetConfigPath(), brainFile)
+	}
+	var err error
+	// A timeout keeps a second robot using the same file from hanging forever
+	db, err = bolt.Open(brainFile, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Opening brain file \"%s\": %v", brainFile, err))
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(memories)
+		return err
+	})
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Initializing brain file \"%s\": %v", brainFile, err))
+	}
+	robot.Log(bot.Info, fmt.Sprintf("Initialized bolt brain with file: '%s'", brainFile))
+	return &bc
+}
+
+func init() {
+	bot.RegisterSimpleBrain("bolt", provider)
+}
@@ -0,0 +1,118 @@
+package boltBrain
+
+/* boltbrain_test.go - tests using a brain file in a temporary directory,
+removed afterwards.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	bolt "github.com/coreos/bbolt"
+)
+
+// open opens the brain file and creates the memories bucket, like provider
+func open(t *testing.T, brainFile string) {
+	var err error
+	db, err = bolt.Open(brainFile, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if err != nil {
+		t.Fatalf("Opening brain file: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(memories)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Initializing brain file: %v", err)
+	}
+}
+
+func setup(t *testing.T) (*brainConfig, string, func()) {
+	dir, err := ioutil.TempDir("", "boltbrain")
+	if err != nil {
+		t.Fatalf("Creating temporary directory: %v", err)
+	}
+	bc = brainConfig{BrainFile: filepath.Join(dir, "brain.db")}
+	open(t, bc.BrainFile)
+	return &bc, bc.BrainFile, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestStoreRetrieveList(t *testing.T) {
+	bb, _, teardown := setup(t)
+	defer teardown()
+	if _, exists, err := bb.Retrieve("nothing"); exists || err != nil {
+		t.Fatalf("Retrieve of missing key returned exists: %t, err: %v", exists, err)
+	}
+	for _, k := range []string{"bot:one", "bot:two", "other"} {
+		b := []byte(k)
+		if err := bb.Store(k, &b); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	datum, exists, err := bb.Retrieve("bot:two")
+	if err != nil || !exists {
+		t.Errorf("Retrieve returned exists: %t, err: %v", exists, err)
+	} else if string(*datum) != "bot:two" {
+		t.Errorf("Retrieve returned %q, want \"bot:two\"", *datum)
+	}
+	b := []byte("updated")
+	if err := bb.Store("bot:two", &b); err != nil {
+		t.Fatalf("Store: %v", err)
+	}
+	if datum, _, _ := bb.Retrieve("bot:two"); datum == nil || string(*datum) != "updated" {
+		t.Errorf("Retrieve after overwriting returned %v, want \"updated\"", datum)
+	}
+	keys, err := bb.List("bot:")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "bot:one" || keys[1] != "bot:two" {
+		t.Errorf("List(\"bot:\") returned %q", keys)
+	}
+	if keys, _ := bb.List(""); len(keys) != 3 {
+		t.Errorf("List(\"\") returned %q, want all 3 keys", keys)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	bb, _, teardown := setup(t)
+	defer teardown()
+	b := []byte("datum")
+	bb.Store("bot:gone", &b)
+	if err := bb.Delete("bot:gone"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, exists, err := bb.Retrieve("bot:gone"); exists || err != nil {
+		t.Errorf("Retrieve after Delete returned exists: %t, err: %v", exists, err)
+	}
+	if keys, _ := bb.List("bot:"); len(keys) != 0 {
+		t.Errorf("List after Delete returned %q", keys)
+	}
+	if err := bb.Delete("bot:never"); err != nil {
+		t.Errorf("Delete of missing key: %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	bb, brainFile, teardown := setup(t)
+	defer teardown()
+	b := []byte("remembered")
+	bb.Store("bot:memory", &b)
+	db.Close()
+	open(t, brainFile)
+	datum, exists, err := bb.Retrieve("bot:memory")
+	if err != nil || !exists {
+		t.Fatalf("Retrieve after re-opening returned exists: %t, err: %v", exists, err)
+	}
+	if string(*datum) != "remembered" {
+		t.Errorf("Retrieve after re-opening returned %q, want \"remembered\"", *datum)
+	}
+}
@@ -119,6 +119,57 @@ func (db *brainConfig) Retrieve(k string) (datum *[]byte, exists bool, err error
 	return &m.Content, true, nil
 }
 
+func (db *brainConfig) Delete(k string) error {
+	_, err := svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName: aws.String(dynamocfg.TableName),
+		Key: map[string]*dynamodb.AttributeValue{
+			"Memory": {
+				S: aws.String(k),
+			},
+		},
+	})
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error deleting memory: %v", err.Error()))
+		return err
+	}
+	return nil
+}
+
+func (db *brainConfig) List(prefix string) ([]string, error) {
+	input := &dynamodb.ScanInput{
+		TableName:            aws.String(dynamocfg.TableName),
+		ProjectionExpression: aws.String("Memory"),
+	}
+	if len(prefix) > 0 {
+		input.FilterExpression = aws.String("begins_with(Memory, :prefix)")
+		input.ExpressionAttributeValues = map[string]*dynamodb.AttributeValue{
+			":prefix": {
+				S: aws.String(prefix),
+			},
+		}
+	}
+	keys := make([]string, 0)
+	var uerr error
+	err := svc.ScanPages(input, func(page *dynamodb.ScanOutput, lastPage bool) bool {
+		for _, item := range page.Items {
+			m := dynaMemory{}
+			if uerr = dynamodbattribute.UnmarshalMap(item, &m); uerr != nil {
+				return false
+			}
+			keys = append(keys, m.Memory)
+		}
+		return true
+	})
+	if err == nil {
+		err = uerr
+	}
+	if err != nil {
+		robot.Log(bot.Error, fmt.Sprintf("Error listing memories: %v", err.Error()))
+		return nil, err
+	}
+	return keys, nil
+}
+
 func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
 	robot = r
 	robot.GetBrainConfig(&dynamocfg)
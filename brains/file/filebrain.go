@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/lnxjedi/gopherbot/bot"
 )
@@ -43,6 +44,28 @@ func (fb *brainConfig) Retrieve(k string) (*[]byte, bool, error) {
 	}
 }
 
+func (fb *brainConfig) Delete(k string) error {
+	datumPath := brainPath + "/" + k
+	if err := os.Remove(datumPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("Removing datum \"%s\": %v", datumPath, err)
+	}
+	return nil
+}
+
+func (fb *brainConfig) List(prefix string) ([]string, error) {
+	files, err := ioutil.ReadDir(brainPath)
+	if err != nil {
+		return nil, fmt.Errorf("Reading brain directory \"%s\": %v", brainPath, err)
+	}
+	keys := make([]string, 0)
+	for _, f := range files {
+		if !f.IsDir() && strings.HasPrefix(f.Name(), prefix) {
+			keys = append(keys, f.Name())
+		}
+	}
+	return keys, nil
+}
+
 // The file brain doesn't need the logger, but other brains might
 func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
 	robot = r
@@ -0,0 +1,192 @@
+// Package redisBrain is a Redis implementation of the bot.SimpleBrain
+// interface. It also implements bot.BrainLocker, keeping datum locks in Redis
+// with expiring keys, so more than one robot process can safely share the
+// same brain.
+package redisBrain
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+var robot bot.Handler
+var pool *redis.Pool
+
+type brainConfig struct {
+	Address  string // host:port of the redis server, defaults to localhost:6379
+	Password string // optional
+	Database int    // optional database number
+	Prefix   string // prepended to all redis keys, defaults to "gopherbot:"
+}
+
+var rc brainConfig
+
+// Memories are stored in <Prefix>memory:<key>, and locks in <Prefix>lock:<key>
+func memoryKey(k string) string { return rc.Prefix + "memory:" + k }
+func lockKey(k string) string   { return rc.Prefix + "lock:" + k }
+
+// Take a lock, or renew it if it's already held with the given token
+var lockScript = redis.NewScript(1, `
+local holder = redis.call("GET", KEYS[1])
+if holder == false or holder == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+	return 1
+end
+return 0
+`)
+
+// Release a lock only if it's still held with the given token
+var unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Store the memory and release the lock, only if the lock is still held with
+// the given token
+var storeLockedScript = redis.NewScript(2, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[2], ARGV[2])
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+return 0
+`)
+
+func (rb *brainConfig) Store(k string, b *[]byte) error {
+	c := pool.Get()
+	defer c.Close()
+	if _, err := c.Do("SET", memoryKey(k), *b); err != nil {
+		return fmt.Errorf("Storing datum \"%s\": %v", k, err)
+	}
+	return nil
+}
+
+func (rb *brainConfig) Retrieve(k string) (*[]byte, bool, error) {
+	c := pool.Get()
+	defer c.Close()
+	datum, err := redis.Bytes(c.Do("GET", memoryKey(k)))
+	if err == redis.ErrNil { // Memory doesn't exist yet
+		return nil, false, nil
+	}
+	if err != nil {
+		err = fmt.Errorf("Retrieving datum \"%s\": %v", k, err)
+		robot.Log(bot.Error, err)
+		return nil, false, err
+	}
+	return &datum, true, nil
+}
+
+func (rb *brainConfig) Delete(k string) error {
+	c := pool.Get()
+	defer c.Close()
+	if _, err := c.Do("DEL", memoryKey(k)); err != nil {
+		return fmt.Errorf("Deleting datum \"%s\": %v", k, err)
+	}
+	return nil
+}
+
+// escape glob characters for SCAN MATCH
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (rb *brainConfig) List(prefix string) ([]string, error) {
+	c := pool.Get()
+	defer c.Close()
+	keys := make([]string, 0)
+	mkprefix := memoryKey("")
+	match := globEscaper.Replace(mkprefix+prefix) + "*"
+	cursor := 0
+	for {
+		reply, err := redis.Values(c.Do("SCAN", cursor, "MATCH", match, "COUNT", 100))
+		if err != nil {
+			return nil, fmt.Errorf("Listing keys with prefix \"%s\": %v", prefix, err)
+		}
+		var found []string
+		if _, err := redis.Scan(reply, &cursor, &found); err != nil {
+			return nil, fmt.Errorf("Listing keys with prefix \"%s\": %v", prefix, err)
+		}
+		for _, k := range found {
+			keys = append(keys, strings.TrimPrefix(k, mkprefix))
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	return keys, nil
+}
+
+func (rb *brainConfig) Lock(k, token string, ttl time.Duration) (bool, error) {
+	c := pool.Get()
+	defer c.Close()
+	locked, err := redis.Bool(lockScript.Do(c, lockKey(k), token, int64(ttl/time.Millisecond)))
+	if err != nil {
+		return false, fmt.Errorf("Locking datum \"%s\": %v", k, err)
+	}
+	return locked, nil
+}
+
+func (rb *brainConfig) Unlock(k, token string) error {
+	c := pool.Get()
+	defer c.Close()
+	if _, err := unlockScript.Do(c, lockKey(k), token); err != nil {
+		return fmt.Errorf("Unlocking datum \"%s\": %v", k, err)
+	}
+	return nil
+}
+
+func (rb *brainConfig) StoreLocked(k, token string, b *[]byte) (bool, error) {
+	c := pool.Get()
+	defer c.Close()
+	stored, err := redis.Bool(storeLockedScript.Do(c, lockKey(k), memoryKey(k), token, *b))
+	if err != nil {
+		return false, fmt.Errorf("Storing datum \"%s\": %v", k, err)
+	}
+	return stored, nil
+}
+
+// The redis brain doesn't need the logger, but other brains might
+func provider(r bot.Handler, _ *log.Logger) bot.SimpleBrain {
+	robot = r
+	robot.GetBrainConfig(&rc)
+	if len(rc.Address) == 0 {
+		rc.Address = "localhost:6379"
+	}
+	if len(rc.Prefix) == 0 {
+		rc.Prefix = "gopherbot:"
+	}
+	options := []redis.DialOption{redis.DialDatabase(rc.Database)}
+	if len(rc.Password) > 0 {
+		options = append(options, redis.DialPassword(rc.Password))
+	}
+	pool = &redis.Pool{
+		MaxIdle:     3,
+		IdleTimeout: 4 * time.Minute,
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", rc.Address, options...)
+		},
+		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			if time.Since(t) < time.Minute {
+				return nil
+			}
+			_, err := c.Do("PING")
+			return err
+		},
+	}
+	c := pool.Get()
+	defer c.Close()
+	if _, err := c.Do("PING"); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Connecting to redis server \"%s\": %v", rc.Address, err))
+	}
+	robot.Log(bot.Info, fmt.Sprintf("Initialized redis brain with server: '%s', prefix: '%s'", rc.Address, rc.Prefix))
+	return &rc
+}
+
+func init() {
+	bot.RegisterSimpleBrain("redis", provider)
+}
@@ -0,0 +1,206 @@
+package redisBrain
+
+/* redisbrain_test.go - tests against an in-process miniredis server, which
+runs the same Lua scripts as redis. Expiry is simulated with FastForward,
+since miniredis doesn't expire keys in real time.
+*/
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/garyburd/redigo/redis"
+)
+
+func setup(t *testing.T) (*brainConfig, *miniredis.Miniredis, func()) {
+	s, err := miniredis.Run()
+	if err != nil {
+		t.Fatalf("Starting miniredis: %v", err)
+	}
+	rc = brainConfig{Address: s.Addr(), Prefix: "gopherbot-test:"}
+	pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			return redis.Dial("tcp", s.Addr())
+		},
+	}
+	return &rc, s, func() {
+		pool.Close()
+		s.Close()
+	}
+}
+
+func TestStoreRetrieveList(t *testing.T) {
+	rb, s, teardown := setup(t)
+	defer teardown()
+	if _, exists, err := rb.Retrieve("nothing"); exists || err != nil {
+		t.Fatalf("Retrieve of missing key returned exists: %t, err: %v", exists, err)
+	}
+	for _, k := range []string{"bot:one", "bot:two", "other"} {
+		b := []byte(k)
+		if err := rb.Store(k, &b); err != nil {
+			t.Fatalf("Store: %v", err)
+		}
+	}
+	if !s.Exists("gopherbot-test:memory:bot:one") {
+		t.Errorf("Store didn't create key with the memory prefix; keys: %q", s.Keys())
+	}
+	datum, exists, err := rb.Retrieve("bot:two")
+	if err != nil || !exists || string(*datum) != "bot:two" {
+		t.Errorf("Retrieve returned %v, exists: %t, err: %v", datum, exists, err)
+	}
+	keys, err := rb.List("bot:")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	sort.Strings(keys)
+	if len(keys) != 2 || keys[0] != "bot:one" || keys[1] != "bot:two" {
+		t.Errorf("List returned %v", keys)
+	}
+	if err := rb.Delete("bot:one"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, exists, _ := rb.Retrieve("bot:one"); exists {
+		t.Error("Datum still exists after Delete")
+	}
+}
+
+func TestList(t *testing.T) {
+	rb, s, teardown := setup(t)
+	defer teardown()
+	for i := 0; i < 250; i++ {
+		b := []byte("datum")
+		rb.Store(fmt.Sprintf("bot:%d", i), &b)
+	}
+	for _, k := range []string{"glob*one", "glob?two", "glob[x]three", `glob\four`, "globfive"} {
+		b := []byte(k)
+		rb.Store(k, &b)
+	}
+	// Keys with another prefix, and locks, aren't listed
+	s.Set("other-robot:memory:glob*one", "datum")
+	s.Set("gopherbot-test:lock:globsix", "token")
+
+	keys, err := rb.List("")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(keys) != 255 {
+		t.Errorf("List(\"\") returned %d keys, want 255", len(keys))
+	}
+	// Glob characters in the prefix match literally
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"glob*", []string{"glob*one"}},
+		{"glob?", []string{"glob?two"}},
+		{"glob[x]", []string{"glob[x]three"}},
+		{`glob\`, []string{`glob\four`}},
+		{"glob", []string{"glob*one", "glob?two", "glob[x]three", `glob\four`, "globfive"}},
+		{"nothing", []string{}},
+	}
+	for _, test := range tests {
+		keys, err := rb.List(test.prefix)
+		if err != nil {
+			t.Errorf("List(%q): %v", test.prefix, err)
+			continue
+		}
+		sort.Strings(keys)
+		sort.Strings(test.want)
+		if len(keys) != len(test.want) {
+			t.Errorf("List(%q) returned %q, want %q", test.prefix, keys, test.want)
+			continue
+		}
+		for i := range keys {
+			if keys[i] != test.want[i] {
+				t.Errorf("List(%q) returned %q, want %q", test.prefix, keys, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLocking(t *testing.T) {
+	rb, s, teardown := setup(t)
+	defer teardown()
+	ok, err := rb.Lock("datum", "first", time.Second)
+	if err != nil || !ok {
+		t.Fatalf("First Lock returned %t, err: %v", ok, err)
+	}
+	if token, _ := s.Get(lockKey("datum")); token != "first" {
+		t.Errorf("Lock key holds %q, want \"first\"", token)
+	}
+	if ok, _ := rb.Lock("datum", "second", time.Second); ok {
+		t.Error("Second Lock succeeded while the datum was locked")
+	}
+	// Locking again with the same token renews the lock
+	if ok, err := rb.Lock("datum", "first", time.Minute); err != nil || !ok {
+		t.Errorf("Renewing Lock returned %t, err: %v", ok, err)
+	}
+	if ttl := s.TTL(lockKey("datum")); ttl <= time.Second || ttl > time.Minute {
+		t.Errorf("Renewed lock has TTL %s, want up to a minute", ttl)
+	}
+	b := []byte("second")
+	if stored, _ := rb.StoreLocked("datum", "second", &b); stored {
+		t.Error("StoreLocked succeeded without holding the lock")
+	}
+	if s.Exists(memoryKey("datum")) {
+		t.Error("StoreLocked without holding the lock stored the datum")
+	}
+	// Unlocking with the wrong token doesn't release the lock
+	if err := rb.Unlock("datum", "second"); err != nil {
+		t.Errorf("Unlock with the wrong token: %v", err)
+	}
+	if !s.Exists(lockKey("datum")) {
+		t.Error("Unlock with the wrong token released the lock")
+	}
+	b = []byte("first")
+	stored, err := rb.StoreLocked("datum", "first", &b)
+	if err != nil || !stored {
+		t.Fatalf("StoreLocked returned %t, err: %v", stored, err)
+	}
+	datum, _, _ := rb.Retrieve("datum")
+	if datum == nil || string(*datum) != "first" {
+		t.Errorf("Retrieve after StoreLocked returned %v", datum)
+	}
+	// StoreLocked released the lock
+	if s.Exists(lockKey("datum")) {
+		t.Error("StoreLocked didn't release the lock")
+	}
+	if ok, _ := rb.Lock("datum", "second", 100*time.Millisecond); !ok {
+		t.Error("Lock failed after StoreLocked released it")
+	}
+	// Unlocking with the right token releases the lock
+	if err := rb.Unlock("datum", "second"); err != nil {
+		t.Errorf("Unlock: %v", err)
+	}
+	if s.Exists(lockKey("datum")) {
+		t.Error("Unlock with the right token didn't release the lock")
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	rb, s, teardown := setup(t)
+	defer teardown()
+	if ok, err := rb.Lock("datum", "first", 100*time.Millisecond); err != nil || !ok {
+		t.Fatalf("Lock returned %t, err: %v", ok, err)
+	}
+	s.FastForward(200 * time.Millisecond)
+	// After the lock expires, the old holder can't store, and another can lock
+	b := []byte("late")
+	if stored, _ := rb.StoreLocked("datum", "first", &b); stored {
+		t.Error("StoreLocked succeeded after the lock expired")
+	}
+	if _, exists, _ := rb.Retrieve("datum"); exists {
+		t.Error("StoreLocked after the lock expired stored the datum")
+	}
+	if ok, _ := rb.Lock("datum", "second", time.Second); !ok {
+		t.Fatal("Lock failed after the previous lock expired")
+	}
+	// Renewing an expired lock fails once someone else holds it
+	if ok, _ := rb.Lock("datum", "first", time.Second); ok {
+		t.Error("Renewing an expired lock succeeded while another token held it")
+	}
+}
@@ -0,0 +1,433 @@
+// Package irc implements an IRC (RFC 1459) connector for the robot, over plain
+// TCP or TLS, with optional NickServ authentication and flood control.
+package irc
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+const connectTimeout = 30 * time.Second   // for dialing and registering
+const identifyTimeout = 10 * time.Second  // for NickServ to confirm identification
+const writeTimeout = 30 * time.Second     // for writing a line to the server
+const maxReconnectDelay = 2 * time.Minute // reconnect backoff doubles up to this
+const quitMessage = "Gopherbot shutting down"
+
+// IRC channel names start with one of these; the robot uses bare names for
+// '#' channels, e.g. "general" for "#general".
+const chanPrefixes = "#&+!"
+
+// ircConnector holds all the relevant data about a connection
+type ircConnector struct {
+	config
+	nick         string             // the robot's current nick, normally config.Nick
+	floodDelay   time.Duration      // delay between lines after a burst
+	users        map[string]ircUser // configured users, by lower-case nick
+	channels     map[string]bool    // channels joined, by lower-case name, for re-joining after a reconnect
+	conn         net.Conn           // the current connection to the server
+	disconnected chan error         // receives the error when the current connection drops
+	incoming     chan *Message      // messages from the server
+	send         chan string        // lines to send, subject to flood control
+	running      bool               // set on call to Run
+	wlock        sync.Mutex         // serializes writes to the connection
+	bot.Handler                     // bot API for connectors
+	sync.RWMutex                    // shared mutex for locking connector data structures
+}
+
+// Message is a parsed IRC protocol message; it's passed to tasks as the raw
+// message for the IRC protocol.
+type Message struct {
+	Prefix  string   // source of the message, e.g. nick!user@host
+	Command string   // e.g. PRIVMSG, or a three-digit numeric reply
+	Params  []string // parameters, including the trailing parameter
+}
+
+// parseMessage parses a line from the server, ignoring IRCv3 message tags
+func parseMessage(line string) *Message {
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil
+		}
+		line = line[i+1:]
+	}
+	m := &Message{}
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return nil
+		}
+		m.Prefix, line = line[1:i], line[i+1:]
+	}
+	var trailing string
+	hasTrailing := false
+	if i := strings.Index(line, " :"); i >= 0 {
+		line, trailing = line[:i], line[i+2:]
+		hasTrailing = true
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+	m.Command = strings.ToUpper(fields[0])
+	m.Params = fields[1:]
+	if hasTrailing {
+		m.Params = append(m.Params, trailing)
+	}
+	return m
+}
+
+// Nick returns the nick from the message prefix
+func (m *Message) Nick() string {
+	if i := strings.IndexAny(m.Prefix, "!@"); i >= 0 {
+		return m.Prefix[:i]
+	}
+	return m.Prefix
+}
+
+func (m *Message) trailing() string {
+	if len(m.Params) == 0 {
+		return ""
+	}
+	return m.Params[len(m.Params)-1]
+}
+
+// ircChannel converts a channel name from the robot to an IRC channel name
+func ircChannel(ch string) string {
+	if len(ch) > 0 && strings.IndexByte(chanPrefixes, ch[0]) >= 0 {
+		return ch
+	}
+	return "#" + ch
+}
+
+// botChannel converts an IRC channel name to the name used by the robot
+func botChannel(ch string) string {
+	return strings.TrimPrefix(ch, "#")
+}
+
+// connect dials the server and registers the connection, then identifies with
+// NickServ and joins any channels the robot was in before a reconnect.
+func (ic *ircConnector) connect() error {
+	dialer := &net.Dialer{Timeout: connectTimeout}
+	var conn net.Conn
+	var err error
+	if ic.TLS {
+		host, _, _ := net.SplitHostPort(ic.Server)
+		conn, err = tls.DialWithDialer(dialer, "tcp", ic.Server, &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: ic.InsecureSkipVerify,
+		})
+	} else {
+		conn, err = dialer.Dial("tcp", ic.Server)
+	}
+	if err != nil {
+		return err
+	}
+	disconnected := make(chan error, 1)
+	ic.Lock()
+	ic.conn = conn
+	ic.disconnected = disconnected
+	ic.Unlock()
+	go ic.readLoop(conn, disconnected)
+
+	nick := ic.Nick
+	if len(ic.Password) > 0 {
+		ic.writeLine("PASS " + ic.Password)
+	}
+	ic.writeLine("NICK " + nick)
+	ic.writeLine(fmt.Sprintf("USER %s 0 * :%s", ic.Nick, ic.RealName))
+	timeout := time.After(connectTimeout)
+registration:
+	for {
+		select {
+		case m := <-ic.incoming:
+			switch m.Command {
+			case "PING":
+				ic.writeLine("PONG :" + m.trailing())
+			case "001": // RPL_WELCOME
+				break registration
+			case "433": // ERR_NICKNAMEINUSE
+				nick += "_"
+				ic.Log(bot.Warn, fmt.Sprintf("Nick '%s' in use, trying '%s'", ic.Nick, nick))
+				ic.writeLine("NICK " + nick)
+			case "ERROR":
+				conn.Close()
+				return fmt.Errorf("server error: %s", m.trailing())
+			}
+		case err := <-disconnected:
+			return err
+		case <-timeout:
+			conn.Close()
+			return fmt.Errorf("timed out waiting for registration")
+		}
+	}
+	ic.Lock()
+	ic.nick = nick
+	channels := make([]string, 0, len(ic.channels))
+	for ch := range ic.channels {
+		channels = append(channels, ch)
+	}
+	ic.Unlock()
+	ic.Log(bot.Info, fmt.Sprintf("Registered with IRC server '%s' as '%s'", ic.Server, nick))
+	if len(ic.NickServPassword) > 0 {
+		if err := ic.identify(conn, disconnected); err != nil {
+			return err
+		}
+	}
+	for _, ch := range channels {
+		ic.writeLine("JOIN " + ch)
+	}
+	return nil
+}
+
+// NickServ notices confirming or refusing identification, lower-case; these
+// cover the common services packages, Atheme and Anope.
+var identifiedNotices = []string{"you are now identified", "you are now recognized", "password accepted"}
+var identifyFailedNotices = []string{"invalid password", "password incorrect", "not a registered nickname", "isn't registered", "is not registered"}
+
+// identify identifies with NickServ, then waits for it to confirm before
+// the robot joins channels, which might require it. If identification fails
+// or isn't confirmed in time, it's logged and the robot carries on.
+func (ic *ircConnector) identify(conn net.Conn, disconnected <-chan error) error {
+	ic.writeLine(fmt.Sprintf("PRIVMSG NickServ :IDENTIFY %s %s", ic.Nick, ic.NickServPassword))
+	timeout := time.After(identifyTimeout)
+	for {
+		select {
+		case m := <-ic.incoming:
+			switch m.Command {
+			case "PING":
+				ic.writeLine("PONG :" + m.trailing())
+			case "900": // RPL_LOGGEDIN
+				ic.Log(bot.Info, fmt.Sprintf("Identified with NickServ: %s", m.trailing()))
+				return nil
+			case "NOTICE":
+				if !strings.EqualFold(m.Nick(), "NickServ") {
+					break
+				}
+				text := m.trailing()
+				ic.Log(bot.Debug, fmt.Sprintf("NickServ: %s", text))
+				lower := strings.ToLower(text)
+				for _, n := range identifiedNotices {
+					if strings.Contains(lower, n) {
+						ic.Log(bot.Info, fmt.Sprintf("Identified with NickServ: %s", text))
+						return nil
+					}
+				}
+				for _, n := range identifyFailedNotices {
+					if strings.Contains(lower, n) {
+						ic.Log(bot.Error, fmt.Sprintf("Identifying with NickServ failed: %s", text))
+						return nil
+					}
+				}
+			case "ERROR":
+				conn.Close()
+				return fmt.Errorf("server error: %s", m.trailing())
+			}
+		case err := <-disconnected:
+			return err
+		case <-timeout:
+			ic.Log(bot.Warn, "Timed out waiting for NickServ to confirm identification, joining channels anyway")
+			return nil
+		}
+	}
+}
+
+// reconnect tries to connect until it succeeds or the robot is stopped,
+// returning false if stopped.
+func (ic *ircConnector) reconnect(stop <-chan struct{}) bool {
+	delay := time.Second
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(delay):
+		}
+		err := ic.connect()
+		if err == nil {
+			return true
+		}
+		ic.Log(bot.Error, fmt.Sprintf("Reconnecting to IRC server '%s': %v", ic.Server, err))
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// readLoop reads lines from a connection until it fails
+func (ic *ircConnector) readLoop(conn net.Conn, disconnected chan<- error) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			disconnected <- err
+			return
+		}
+		if m := parseMessage(line); m != nil {
+			ic.incoming <- m
+		}
+	}
+}
+
+// writeLine sends a line to the server immediately
+func (ic *ircConnector) writeLine(line string) error {
+	ic.RLock()
+	conn := ic.conn
+	ic.RUnlock()
+	ic.wlock.Lock()
+	defer ic.wlock.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := fmt.Fprintf(conn, "%s\r\n", line)
+	if err != nil {
+		ic.Log(bot.Error, fmt.Sprintf("Writing to IRC server: %v", err))
+	}
+	return err
+}
+
+// writeLoop sends queued lines, allowing a burst of FloodBurst lines, then
+// one line every floodDelay, so the server doesn't disconnect the robot for
+// flooding.
+func (ic *ircConnector) writeLoop() {
+	tokens := ic.FloodBurst
+	refilled := time.Now()
+	for line := range ic.send {
+		if ic.floodDelay > 0 {
+			if n := int(time.Since(refilled) / ic.floodDelay); n > 0 {
+				tokens += n
+				refilled = refilled.Add(time.Duration(n) * ic.floodDelay)
+				if tokens >= ic.FloodBurst {
+					tokens = ic.FloodBurst
+					refilled = time.Now()
+				}
+			}
+			if tokens == 0 {
+				time.Sleep(ic.floodDelay - time.Since(refilled))
+				refilled = refilled.Add(ic.floodDelay)
+				tokens = 1
+			}
+			tokens--
+		}
+		ic.writeLine(line)
+	}
+}
+
+// splitLine breaks a long line in to pieces no longer than max bytes,
+// preferring to break on a space.
+func splitLine(line string, max int) []string {
+	lines := []string{}
+	for len(line) > max {
+		i := max
+		// don't split a UTF-8 sequence
+		for i > 0 && line[i]&0xC0 == 0x80 {
+			i--
+		}
+		if s := strings.LastIndexByte(line[:i], ' '); s > 0 {
+			i = s
+		}
+		lines = append(lines, line[:i])
+		line = strings.TrimLeft(line[i:], " ")
+	}
+	return append(lines, line)
+}
+
+// sendMessage queues a possibly multi-line message to a channel or nick
+func (ic *ircConnector) sendMessage(target, msg string) bot.RetVal {
+	msg = strings.Replace(msg, "\r", "", -1)
+	for _, line := range strings.Split(msg, "\n") {
+		// IRC doesn't allow empty messages
+		if len(line) == 0 {
+			line = " "
+		}
+		for _, l := range splitLine(line, ic.MaxLineLength) {
+			ic.send <- fmt.Sprintf("PRIVMSG %s :%s", target, l)
+		}
+	}
+	return bot.Ok
+}
+
+// handle processes a message from the server
+func (ic *ircConnector) handle(m *Message) {
+	ic.RLock()
+	nick := ic.nick
+	ic.RUnlock()
+	switch m.Command {
+	case "PING":
+		ic.writeLine("PONG :" + m.trailing())
+	case "PRIVMSG":
+		if len(m.Params) < 2 {
+			return
+		}
+		target, text := m.Params[0], m.Params[1]
+		// Ignore CTCP requests like VERSION and ACTION
+		if strings.HasPrefix(text, "\x01") {
+			return
+		}
+		if strings.EqualFold(target, nick) {
+			ic.IncomingMessage("", m.Nick(), text, m)
+		} else {
+			ic.IncomingMessage(botChannel(target), m.Nick(), text, m)
+		}
+	case "KICK":
+		if len(m.Params) >= 2 && strings.EqualFold(m.Params[1], nick) {
+			ic.Log(bot.Warn, fmt.Sprintf("Kicked from channel '%s' by '%s': %s", m.Params[0], m.Nick(), m.trailing()))
+			ic.Lock()
+			delete(ic.channels, strings.ToLower(m.Params[0]))
+			ic.Unlock()
+		}
+	case "NICK":
+		if strings.EqualFold(m.Nick(), nick) && len(m.Params) > 0 {
+			ic.Lock()
+			ic.nick = m.Params[0]
+			ic.Unlock()
+		}
+	case "ERROR":
+		ic.Log(bot.Error, fmt.Sprintf("Error from IRC server: %s", m.trailing()))
+	case "NOTICE":
+		if strings.EqualFold(m.Nick(), "NickServ") {
+			ic.Log(bot.Debug, fmt.Sprintf("NickServ: %s", m.trailing()))
+		}
+	}
+}
+
+func (ic *ircConnector) Run(stop <-chan struct{}) {
+	ic.Lock()
+	// This should never happen, just a bit of defensive coding
+	if ic.running {
+		ic.Unlock()
+		return
+	}
+	ic.running = true
+	disconnected := ic.disconnected
+	ic.Unlock()
+
+loop:
+	for {
+		select {
+		case <-stop:
+			ic.Log(bot.Debug, "Received stop in connector")
+			ic.writeLine("QUIT :" + quitMessage)
+			ic.RLock()
+			ic.conn.Close()
+			ic.RUnlock()
+			break loop
+		case m := <-ic.incoming:
+			ic.handle(m)
+		case err := <-disconnected:
+			ic.Log(bot.Error, fmt.Sprintf("Disconnected from IRC server '%s': %v", ic.Server, err))
+			if !ic.reconnect(stop) {
+				break loop
+			}
+			ic.RLock()
+			disconnected = ic.disconnected
+			ic.RUnlock()
+		}
+	}
+}
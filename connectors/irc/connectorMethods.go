@@ -0,0 +1,76 @@
+package irc
+
+import (
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+func (ic *ircConnector) MessageHeard(u, c string) {
+	return
+}
+
+// GetProtocolUserAttribute returns a string attribute for a user from the
+// configured Users, since IRC servers don't have that information
+func (ic *ircConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	user, exists := ic.users[strings.ToLower(u)]
+	if !exists {
+		return "", bot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		return user.Email, bot.Ok
+	case "internalid":
+		return user.InternalID, bot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return user.FullName, bot.Ok
+	case "firstname", "first name":
+		return user.FirstName, bot.Ok
+	case "lastname", "last name":
+		return user.LastName, bot.Ok
+	case "phone":
+		return user.Phone, bot.Ok
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (ic *ircConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ic.sendMessage(ircChannel(ch), msg)
+}
+
+// SendProtocolUserChannelMessage sends a message to a channel, addressed to a
+// user the usual IRC way
+func (ic *ircConnector) SendProtocolUserChannelMessage(u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ic.sendMessage(ircChannel(ch), u+": "+msg)
+}
+
+// SendProtocolChannelThreadMessage sends a message to a channel; IRC doesn't
+// have threads
+func (ic *ircConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ic.SendProtocolChannelMessage(ch, msg, f)
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a channel,
+// addressed to a user; IRC doesn't have threads
+func (ic *ircConnector) SendProtocolUserChannelThreadMessage(u, ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ic.SendProtocolUserChannelMessage(u, ch, msg, f)
+}
+
+// SendProtocolUserMessage sends a direct message to a user
+func (ic *ircConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return ic.sendMessage(u, msg)
+}
+
+// JoinChannel joins a channel given it's human-readable name, e.g. "general";
+// channels are re-joined after a reconnect.
+func (ic *ircConnector) JoinChannel(c string) (ret bot.RetVal) {
+	ch := ircChannel(c)
+	ic.Lock()
+	// Channel names are case-insensitive; the server might use different case
+	ic.channels[strings.ToLower(ch)] = true
+	ic.Unlock()
+	ic.send <- "JOIN " + ch
+	return bot.Ok
+}
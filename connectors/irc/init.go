@@ -0,0 +1,110 @@
+package irc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// ircUser maps a nick to user attributes, since IRC servers don't provide them
+type ircUser struct {
+	Name                                        string // the user's nick
+	InternalID                                  string // defaults to the nick
+	Email, FullName, FirstName, LastName, Phone string
+}
+
+type config struct {
+	Server             string    // host:port of the IRC server
+	TLS                bool      // connect with TLS
+	InsecureSkipVerify bool      // don't verify the server's TLS certificate, e.g. for self-signed certificates
+	Password           string    // server password, if required
+	Nick               string    // the robot's nick, also the name it's addressed by
+	RealName           string    // the robot's full name
+	NickServPassword   string    // password for identifying with NickServ
+	FloodBurst         int       // maximum number of lines to send in a burst, default 4
+	FloodDelay         string    // delay between lines once the burst is used up, default "1s"
+	MaxLineLength      int       // longer lines are split, default 400
+	Users              []ircUser // attributes for known users
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+func init() {
+	bot.RegisterConnector("irc", Initialize)
+}
+
+// Initialize connects and registers with the IRC server, and returns a
+// connector object
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Errorf("Unable to retrieve protocol configuration: %v", err))
+	}
+	if len(c.Server) == 0 || len(c.Nick) == 0 {
+		robot.Log(bot.Fatal, "IRC connector requires Server and Nick in ProtocolConfig")
+	}
+	if len(c.RealName) == 0 {
+		c.RealName = c.Nick
+	}
+	if c.FloodBurst <= 0 {
+		c.FloodBurst = 4
+	}
+	floodDelay := time.Second
+	if len(c.FloodDelay) > 0 {
+		d, err := time.ParseDuration(c.FloodDelay)
+		if err == nil && d >= 0 {
+			floodDelay = d
+		} else {
+			robot.Log(bot.Error, fmt.Sprintf("Invalid FloodDelay '%s', using default of %s", c.FloodDelay, floodDelay))
+		}
+	}
+	if c.MaxLineLength <= 0 {
+		c.MaxLineLength = 400
+	}
+
+	users := make(map[string]ircUser)
+	for _, u := range c.Users {
+		if len(u.InternalID) == 0 {
+			u.InternalID = u.Name
+		}
+		users[strings.ToLower(u.Name)] = u
+	}
+
+	ic := &ircConnector{
+		config:     c,
+		nick:       c.Nick,
+		floodDelay: floodDelay,
+		users:      users,
+		channels:   make(map[string]bool),
+		incoming:   make(chan *Message, 64),
+		send:       make(chan string, 256),
+	}
+	ic.Handler = robot
+
+	if err := ic.connect(); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Connecting to IRC server '%s': %v", c.Server, err))
+	}
+	go ic.writeLoop()
+
+	ic.SetFullName(c.RealName)
+	ic.Log(bot.Debug, "Set bot full name to", c.RealName)
+	ic.SetName(ic.nick)
+	ic.Log(bot.Info, "Set bot name to", ic.nick)
+
+	return bot.Connector(ic)
+}
@@ -0,0 +1,225 @@
+package irc
+
+/* irc_test.go - tests the connector against a minimal ircd stand-in, which
+registers the robot and records the lines it sends.
+*/
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+type heardMessage struct {
+	channel, user, message string
+}
+
+// testHandler stands in for the robot
+type testHandler struct {
+	config string
+	heard  chan heardMessage
+}
+
+func (h *testHandler) IncomingMessage(channel, user, message string, raw interface{}) {
+	h.heard <- heardMessage{channel, user, message}
+}
+func (h *testHandler) IncomingThreadMessage(channel, thread string, threaded bool, user, message string, raw interface{}) {
+	h.heard <- heardMessage{channel, user, message}
+}
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	return json.Unmarshal([]byte(h.config), v)
+}
+func (h *testHandler) GetBrainConfig(v interface{}) error   { return nil }
+func (h *testHandler) GetHistoryConfig(v interface{}) error { return nil }
+func (h *testHandler) SetFullName(n string)                 {}
+func (h *testHandler) SetName(n string)                     {}
+func (h *testHandler) GetLogLevel() bot.LogLevel            { return bot.Debug }
+func (h *testHandler) GetLogToFile() bool                   { return false }
+func (h *testHandler) GetInstallPath() string               { return "" }
+func (h *testHandler) GetConfigPath() string                { return "" }
+func (h *testHandler) Log(l bot.LogLevel, v ...interface{}) {}
+
+// testServer is the ircd stand-in; it accepts one connection at a time
+type testServer struct {
+	listener net.Listener
+	conn     net.Conn
+	lines    *bufio.Reader
+	t        *testing.T
+}
+
+func (s *testServer) accept() {
+	conn, err := s.listener.Accept()
+	if err != nil {
+		s.t.Fatalf("Accepting connection: %v", err)
+	}
+	s.conn = conn
+	s.lines = bufio.NewReader(conn)
+}
+
+func (s *testServer) say(line string) {
+	fmt.Fprintf(s.conn, "%s\r\n", line)
+}
+
+// expect reads the next line from the robot and checks it
+func (s *testServer) expect(want string) {
+	s.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := s.lines.ReadString('\n')
+	if err != nil {
+		s.t.Fatalf("Reading from robot, want '%s': %v", want, err)
+	}
+	if got := strings.TrimRight(line, "\r\n"); got != want {
+		s.t.Errorf("Line from robot; want '%s', got '%s'", want, got)
+	}
+}
+
+// expectNothing checks the robot doesn't send anything for a while
+func (s *testServer) expectNothing(d time.Duration) {
+	s.conn.SetReadDeadline(time.Now().Add(d))
+	if line, err := s.lines.ReadString('\n'); err == nil {
+		s.t.Errorf("Line from robot; want nothing, got '%s'", strings.TrimRight(line, "\r\n"))
+	}
+}
+
+// register handles registration of a new connection from the robot, up to
+// identifying with NickServ
+func (s *testServer) register() {
+	s.accept()
+	s.expect("PASS serverpass")
+	s.expect("NICK bender")
+	s.say(":irc.test 433 * bender :Nickname is already in use")
+	s.expect("USER bender 0 * :Bender Rodriguez")
+	s.expect("NICK bender_")
+	s.say("PING :irc.test")
+	s.expect("PONG :irc.test")
+	s.say(":irc.test 001 bender_ :Welcome to the test network")
+	s.expect("PRIVMSG NickServ :IDENTIFY bender nickservpass")
+}
+
+func (s *testServer) expectHeard(h *testHandler, want heardMessage) {
+	select {
+	case got := <-h.heard:
+		if got != want {
+			s.t.Errorf("Message to robot; want %+v, got %+v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		s.t.Errorf("Timed out waiting for message to robot: %+v", want)
+	}
+}
+
+func TestConnector(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listening: %v", err)
+	}
+	defer l.Close()
+	s := &testServer{listener: l, t: t}
+	h := &testHandler{
+		config: fmt.Sprintf(`{
+			"Server": "%s",
+			"Password": "serverpass",
+			"Nick": "bender",
+			"RealName": "Bender Rodriguez",
+			"NickServPassword": "nickservpass",
+			"FloodBurst": 2,
+			"FloodDelay": "200ms",
+			"MaxLineLength": 20,
+			"Users": [{"Name": "alice", "Email": "alice@example.com"}]
+		}`, l.Addr().String()),
+		heard: make(chan heardMessage, 4),
+	}
+
+	registered := make(chan struct{})
+	go func() {
+		s.register()
+		s.say(":NickServ!NickServ@services. NOTICE bender_ :You are now identified for \x02bender\x02.")
+		close(registered)
+	}()
+	c := Initialize(h, nil)
+	<-registered
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(stop)
+		close(stopped)
+	}()
+
+	if c.JoinChannel("general") != bot.Ok {
+		t.Error("JoinChannel failed")
+	}
+	s.expect("JOIN #general")
+	if email, ret := c.GetProtocolUserAttribute("Alice", "email"); ret != bot.Ok || email != "alice@example.com" {
+		t.Errorf("GetProtocolUserAttribute for alice; want alice@example.com, got '%s' (%s)", email, ret)
+	}
+	if _, ret := c.GetProtocolUserAttribute("bob", "email"); ret != bot.UserNotFound {
+		t.Errorf("GetProtocolUserAttribute for bob; want UserNotFound, got %s", ret)
+	}
+
+	s.say(":alice!alice@example.com PRIVMSG #general :bender: ping")
+	s.expectHeard(h, heardMessage{"general", "alice", "bender: ping"})
+	s.say("@time=2018-01-01T00:00:00Z :alice!alice@example.com PRIVMSG bender_ :hello there")
+	s.expectHeard(h, heardMessage{"", "alice", "hello there"})
+	// CTCP requests are ignored
+	s.say(":alice!alice@example.com PRIVMSG bender_ :\x01VERSION\x01")
+
+	// Multi-line and long messages are split, and after a burst of two lines
+	// the rest are delayed.
+	start := time.Now()
+	c.SendProtocolUserChannelMessage("alice", "general", "PONG\nthis line is much too long", bot.Raw)
+	s.expect("PRIVMSG #general :alice: PONG")
+	s.expect("PRIVMSG #general :this line is much")
+	s.expect("PRIVMSG #general :too long")
+	if elapsed := time.Since(start); elapsed < 150*time.Millisecond {
+		t.Errorf("Flood control didn't delay the third line, elapsed: %s", elapsed)
+	}
+	c.SendProtocolUserMessage("alice", "hi", bot.Raw)
+	s.expect("PRIVMSG alice :hi")
+
+	// After a disconnect, the robot reconnects and re-joins channels, once
+	// NickServ has confirmed it's identified
+	s.conn.Close()
+	s.register()
+	s.say(":NickServ!NickServ@services. NOTICE bender_ :This nickname is registered. Please identify.")
+	s.expectNothing(200 * time.Millisecond)
+	s.say(":irc.test 900 bender_ bender_!bender@host bender :You are now logged in as bender")
+	s.expect("JOIN #general")
+	s.say(":alice!alice@example.com PRIVMSG #general :welcome back")
+	s.expectHeard(h, heardMessage{"general", "alice", "welcome back"})
+
+	// Channels the robot's kicked from aren't re-joined, whatever case the
+	// server uses for the channel name
+	s.say(":op!op@example.com KICK #General bender_ :bye")
+	time.Sleep(100 * time.Millisecond)
+	s.conn.Close()
+	s.register()
+	s.say(":irc.test 900 bender_ bender_!bender@host bender :You are now logged in as bender")
+	c.JoinChannel("random")
+	s.expect("JOIN #random")
+
+	close(stop)
+	s.expect("QUIT :" + quitMessage)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for connector to stop")
+	}
+}
+
+func TestParseMessage(t *testing.T) {
+	m := parseMessage(":nick!user@host PRIVMSG #chan :hello: world\r\n")
+	if m.Nick() != "nick" || m.Command != "PRIVMSG" || len(m.Params) != 2 || m.Params[0] != "#chan" || m.Params[1] != "hello: world" {
+		t.Errorf("Parsing PRIVMSG, got %+v", m)
+	}
+	m = parseMessage("ping irc.test")
+	if m.Command != "PING" || m.Prefix != "" || len(m.Params) != 1 || m.trailing() != "irc.test" {
+		t.Errorf("Parsing PING, got %+v", m)
+	}
+	if m = parseMessage(":prefixonly"); m != nil {
+		t.Errorf("Parsing prefix only, want nil, got %+v", m)
+	}
+}
@@ -0,0 +1,546 @@
+// Package mattermost implements a connector for Mattermost, using the v4 REST
+// API for looking up users and channels and sending posts, and the websocket
+// API for receiving events.
+package mattermost
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+const optimeout = 1 * time.Minute         // for retrying map updates
+const apiTimeout = 30 * time.Second       // for REST calls and the websocket handshake
+const maxReconnectDelay = 2 * time.Minute // reconnect backoff doubles up to this
+const pageSize = 200                      // users and channels are retrieved in pages of this size
+
+var httpClient = &http.Client{Timeout: apiTimeout}
+
+// mmConnector holds all the relevant data about a connection
+type mmConnector struct {
+	config
+	botID        string            // Mattermost user ID for the robot
+	botName      string            // the robot's username
+	teamID       string            // ID of the configured Team
+	ws           *websocket.Conn   // the current websocket connection
+	seq          int64             // sequence number for websocket actions
+	disconnected chan error        // receives the error when the websocket drops
+	incoming     chan *wsEvent     // events from the websocket
+	running      bool              // set on call to Run
+	wlock        sync.Mutex        // serializes writes to the websocket
+	bot.Handler                    // bot API for connectors
+	sync.RWMutex                   // shared mutex for locking connector data structures
+	channelToID  map[string]string // map from channel names to channel IDs
+	idToChannel  map[string]string // map from channel ID to channel name
+	userInfo     map[string]User   // map from user names to User struct
+	idToUser     map[string]string // map from user ID to user name
+	userIDToDM   map[string]string // map from user ID to direct message channel ID
+	dmToUser     map[string]string // map from direct message channel ID to user name
+}
+
+// User is a Mattermost user, as returned by the REST API
+type User struct {
+	ID        string `json:"id"`
+	Username  string `json:"username"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Nickname  string `json:"nickname"`
+	DeleteAt  int64  `json:"delete_at"`
+}
+
+// Channel is a Mattermost channel, as returned by the REST API
+type Channel struct {
+	ID          string `json:"id"`
+	TeamID      string `json:"team_id"`
+	Type        string `json:"type"` // "O" for open, "P" for private, "D" for direct and "G" for group
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+}
+
+// Post is a Mattermost post; it's passed to tasks as the raw message for the
+// Mattermost protocol.
+type Post struct {
+	ID        string                 `json:"id"`
+	ChannelID string                 `json:"channel_id"`
+	UserID    string                 `json:"user_id"`
+	RootID    string                 `json:"root_id"` // the first post of the thread, if any
+	Message   string                 `json:"message"`
+	Type      string                 `json:"type"`               // empty for user posts, e.g. "system_join_channel" for system messages
+	FileIDs   []string               `json:"file_ids,omitempty"` // files uploaded with the post
+	Props     map[string]interface{} `json:"props,omitempty"`    // e.g. message attachments
+}
+
+// wsEvent is an event or action reply from the websocket
+type wsEvent struct {
+	Event     string                 `json:"event"`
+	Data      map[string]interface{} `json:"data"`
+	Broadcast struct {
+		ChannelID string `json:"channel_id"`
+		UserID    string `json:"user_id"`
+	} `json:"broadcast"`
+	Status   string `json:"status"`
+	SeqReply int64  `json:"seq_reply"`
+}
+
+// api makes a REST API call, decoding the JSON response in to result
+func (mc *mmConnector) api(method, path string, body, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, mc.Server+"/api/v4"+path, r)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return mc.do(req, result)
+}
+
+// do sends an authenticated REST API request, decoding the JSON response in
+// to result
+func (mc *mmConnector) do(req *http.Request, result interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+mc.Token)
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return fmt.Errorf("%s %s: %s (%s)", req.Method, req.URL.Path, resp.Status, apiErr.Message)
+	}
+	if result != nil {
+		return json.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+// getUsers retrieves all users, a page at a time
+func (mc *mmConnector) getUsers() ([]User, error) {
+	users := []User{}
+	for page := 0; ; page++ {
+		var p []User
+		if err := mc.api("GET", fmt.Sprintf("/users?page=%d&per_page=%d", page, pageSize), nil, &p); err != nil {
+			return nil, err
+		}
+		users = append(users, p...)
+		if len(p) < pageSize {
+			return users, nil
+		}
+	}
+}
+
+// getChannels retrieves the channels the robot belongs to, including private
+// and direct message channels, plus all the public channels in the team, so
+// the robot can join them.
+func (mc *mmConnector) getChannels() ([]Channel, error) {
+	var channels []Channel
+	if err := mc.api("GET", "/users/me/teams/"+mc.teamID+"/channels", nil, &channels); err != nil {
+		return nil, err
+	}
+	for page := 0; ; page++ {
+		var p []Channel
+		if err := mc.api("GET", fmt.Sprintf("/teams/%s/channels?page=%d&per_page=%d", mc.teamID, page, pageSize), nil, &p); err != nil {
+			return nil, err
+		}
+		channels = append(channels, p...)
+		if len(p) < pageSize {
+			return channels, nil
+		}
+	}
+}
+
+// update maps is called whenever there are any changes to users or channels,
+// so that plugins can use human-readable names for users and channels.
+func (mc *mmConnector) updateMaps() {
+	mc.Log(bot.Trace, "Updating maps")
+	deadline := time.Now().Add(optimeout)
+	var (
+		err      error
+		userlist []User
+		chanlist []Channel
+	)
+
+	for time.Now().Before(deadline) {
+		if userlist, err = mc.getUsers(); err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		mc.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating users: %v\n", err))
+	}
+	userMap := make(map[string]User)
+	userIDMap := make(map[string]string)
+	for _, user := range userlist {
+		if user.DeleteAt != 0 {
+			continue
+		}
+		mc.Log(bot.Trace, "Mapping user name", user.Username, "to", user.ID)
+		userMap[user.Username] = user
+		userIDMap[user.ID] = user.Username
+	}
+
+	for time.Now().Before(deadline) {
+		if chanlist, err = mc.getChannels(); err == nil {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if err != nil {
+		mc.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating channels: %v\n", err))
+	}
+	chanMap := make(map[string]string)
+	chanIDMap := make(map[string]string)
+	userDMMap := make(map[string]string)
+	dmUserMap := make(map[string]string)
+	for _, channel := range chanlist {
+		if channel.Type == "D" {
+			// Direct message channels are named <userid>__<userid>
+			for _, id := range strings.Split(channel.Name, "__") {
+				if id == mc.botID {
+					continue
+				}
+				mc.Log(bot.Trace, "Mapping user ID", id, "to DM channel", channel.ID)
+				userDMMap[id] = channel.ID
+				dmUserMap[channel.ID] = userIDMap[id]
+			}
+			continue
+		}
+		mc.Log(bot.Trace, "Mapping channel name", channel.Name, "to channel", channel.ID)
+		chanMap[channel.Name] = channel.ID
+		chanIDMap[channel.ID] = channel.Name
+	}
+
+	mc.Lock()
+	mc.userInfo = userMap
+	mc.idToUser = userIDMap
+	mc.channelToID = chanMap
+	mc.idToChannel = chanIDMap
+	mc.userIDToDM = userDMMap
+	mc.dmToUser = dmUserMap
+	mc.Unlock()
+	mc.Log(bot.Info, "User/Channel maps updated")
+}
+
+func (mc *mmConnector) getUser(u string) (user User, ok bool) {
+	mc.RLock()
+	user, ok = mc.userInfo[u]
+	mc.RUnlock()
+	return user, ok
+}
+
+func (mc *mmConnector) userName(i string) (u string, ok bool) {
+	mc.RLock()
+	u, ok = mc.idToUser[i]
+	mc.RUnlock()
+	return u, ok
+}
+
+func (mc *mmConnector) chanID(c string) (i string, ok bool) {
+	mc.RLock()
+	i, ok = mc.channelToID[c]
+	mc.RUnlock()
+	return i, ok
+}
+
+func (mc *mmConnector) channelName(i string) (c string, ok bool) {
+	mc.RLock()
+	c, ok = mc.idToChannel[i]
+	mc.RUnlock()
+	return c, ok
+}
+
+// dmChannel returns the direct message channel ID for a user, creating the
+// channel if needed
+func (mc *mmConnector) dmChannel(u string) (string, bot.RetVal) {
+	user, ok := mc.getUser(u)
+	if !ok {
+		mc.Log(bot.Error, "No user ID found for user:", u)
+		return "", bot.UserNotFound
+	}
+	mc.RLock()
+	dmID, ok := mc.userIDToDM[user.ID]
+	mc.RUnlock()
+	if ok {
+		return dmID, bot.Ok
+	}
+	mc.Log(bot.Debug, "No DM channel found for user:", u, "ID:", user.ID, "creating")
+	var channel Channel
+	if err := mc.api("POST", "/channels/direct", []string{mc.botID, user.ID}, &channel); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Unable to create a DM channel to user '%s': %v", u, err))
+		return "", bot.FailedUserDM
+	}
+	mc.Lock()
+	mc.userIDToDM[user.ID] = channel.ID
+	mc.dmToUser[channel.ID] = u
+	mc.Unlock()
+	return channel.ID, bot.Ok
+}
+
+// connect opens the websocket, authenticating with the token, and waits for
+// the server's hello
+func (mc *mmConnector) connect() error {
+	url := "ws" + strings.TrimPrefix(mc.Server, "http") + "/api/v4/websocket"
+	dialer := websocket.Dialer{HandshakeTimeout: apiTimeout}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+mc.Token)
+	ws, _, err := dialer.Dial(url, header)
+	if err != nil {
+		return err
+	}
+	ws.SetReadDeadline(time.Now().Add(apiTimeout))
+	var hello wsEvent
+	if err := ws.ReadJSON(&hello); err != nil {
+		ws.Close()
+		return fmt.Errorf("waiting for hello: %v", err)
+	}
+	if hello.Event != "hello" {
+		ws.Close()
+		return fmt.Errorf("expected hello from server, got '%s'", hello.Event)
+	}
+	ws.SetReadDeadline(time.Time{})
+	disconnected := make(chan error, 1)
+	mc.Lock()
+	mc.ws = ws
+	mc.disconnected = disconnected
+	mc.Unlock()
+	go mc.readLoop(ws, disconnected)
+	mc.Log(bot.Info, fmt.Sprintf("Connected to Mattermost server '%s' as '%s'", mc.Server, mc.botName))
+	return nil
+}
+
+// reconnect tries to connect until it succeeds or the robot is stopped,
+// returning false if stopped.
+func (mc *mmConnector) reconnect(stop <-chan struct{}) bool {
+	delay := time.Second
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(delay):
+		}
+		err := mc.connect()
+		if err == nil {
+			return true
+		}
+		mc.Log(bot.Error, fmt.Sprintf("Reconnecting to Mattermost server '%s': %v", mc.Server, err))
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// readLoop reads events from a websocket until it fails
+func (mc *mmConnector) readLoop(ws *websocket.Conn, disconnected chan<- error) {
+	for {
+		var ev wsEvent
+		if err := ws.ReadJSON(&ev); err != nil {
+			disconnected <- err
+			return
+		}
+		mc.incoming <- &ev
+	}
+}
+
+// sendAction sends an action like user_typing over the websocket
+func (mc *mmConnector) sendAction(action string, data map[string]interface{}) error {
+	mc.RLock()
+	ws := mc.ws
+	mc.RUnlock()
+	mc.wlock.Lock()
+	defer mc.wlock.Unlock()
+	mc.seq++
+	ws.SetWriteDeadline(time.Now().Add(apiTimeout))
+	err := ws.WriteJSON(map[string]interface{}{
+		"seq":    mc.seq,
+		"action": action,
+		"data":   data,
+	})
+	if err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Sending '%s' action to Mattermost server: %v", action, err))
+	}
+	return err
+}
+
+func optQuote(msg string, f bot.MessageFormat) string {
+	if f == bot.Fixed {
+		return "```\n" + msg + "\n```"
+	}
+	return msg
+}
+
+// mattermostifyMessage handles escaping and formatting, and segments the
+// message if needed. Unlike Slack, Mattermost uses plain @username mentions.
+func (mc *mmConnector) mattermostifyMessage(msg string, f bot.MessageFormat) []string {
+	maxSize := mc.MaxMessageLength
+	if f == bot.Fixed {
+		maxSize -= 8
+	}
+	// 'escape' markdown
+	if f == bot.Variable {
+		for _, c := range []string{`\`, "`", "*", "_", "~", "#"} {
+			msg = strings.Replace(msg, c, `\`+c, -1)
+		}
+	}
+	sbytes := []byte(msg)
+	msgLen := len(sbytes)
+	if msgLen <= maxSize {
+		return []string{optQuote(msg, f)}
+	}
+	// It's too big, gotta chop it up. We will send at most MaxMessageSplit
+	// messages, plus "(message truncated)".
+	msgs := make([]string, 0, mc.MaxMessageSplit+1)
+	mc.Log(bot.Info, fmt.Sprintf("Message too long, segmenting: %d bytes", msgLen))
+	// Chop it up into <=maxSize pieces
+	for len(sbytes) > maxSize && len(msgs) < mc.MaxMessageSplit {
+		lineEnd := bytes.LastIndexByte(sbytes[:maxSize], byte('\n'))
+		if lineEnd == -1 { // no newline in this chunk
+			cut := maxSize
+			// don't split a UTF-8 sequence
+			for cut > 0 && sbytes[cut]&0xC0 == 0x80 {
+				cut--
+			}
+			msgs = append(msgs, optQuote(string(sbytes[:cut]), f))
+			sbytes = sbytes[cut:]
+		} else {
+			msgs = append(msgs, optQuote(string(sbytes[:lineEnd]), f))
+			sbytes = sbytes[lineEnd+1:] // skip over the newline
+		}
+	}
+	if len(msgs) == mc.MaxMessageSplit { // we've maxed out
+		if len(sbytes) > 0 { // if there's anything left, we've truncated
+			msgs = append(msgs, "(message too long, truncated)")
+		}
+	} else { // the last chunk fits
+		msgs = append(msgs, optQuote(string(sbytes), f))
+	}
+	return msgs
+}
+
+// sendPosts sends a series of posts to a channel, in a thread if rootID is
+// set
+func (mc *mmConnector) sendPosts(msgs []string, chanID, rootID string) {
+	for _, msg := range msgs {
+		post := Post{ChannelID: chanID, RootID: rootID, Message: msg}
+		if err := mc.api("POST", "/posts", post, nil); err != nil {
+			mc.Log(bot.Error, fmt.Sprintf("Failed sending message '%s' to channel '%s': %v", msg, chanID, err))
+			return
+		}
+	}
+}
+
+// processPost handles a 'posted' event, routing the message to the robot
+func (mc *mmConnector) processPost(ev *wsEvent) {
+	postJSON, _ := ev.Data["post"].(string)
+	var post Post
+	if err := json.Unmarshal([]byte(postJSON), &post); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Decoding post from Mattermost: %v", err))
+		return
+	}
+	mc.Log(bot.Trace, fmt.Sprintf("Message received: %+v", post))
+	// Ignore the robot's own posts and system messages like joins
+	if post.UserID == mc.botID || len(post.Type) > 0 {
+		return
+	}
+	userName, ok := mc.userName(post.UserID)
+	if !ok {
+		mc.Log(bot.Error, "Couldn't find user name for user ID", post.UserID)
+		userName = post.UserID
+	}
+	if channelType, _ := ev.Data["channel_type"].(string); channelType == "D" {
+		mc.Lock()
+		mc.userIDToDM[post.UserID] = post.ChannelID
+		mc.dmToUser[post.ChannelID] = userName
+		mc.Unlock()
+		mc.IncomingMessage("", userName, post.Message, &post)
+		return
+	}
+	channelName, ok := mc.channelName(post.ChannelID)
+	if !ok {
+		mc.Log(bot.Warn, "Couldn't find channel name for ID", post.ChannelID)
+		channelName = post.ChannelID
+	}
+	// Replies to a post not in a thread start a thread from the post
+	threadID, threaded := post.RootID, len(post.RootID) > 0
+	if !threaded {
+		threadID = post.ID
+	}
+	mc.IncomingThreadMessage(channelName, threadID, threaded, userName, post.Message, &post)
+}
+
+// handle processes an event from the websocket
+func (mc *mmConnector) handle(ev *wsEvent) {
+	mc.Log(bot.Trace, fmt.Sprintf("Event Received: %s; %v", ev.Event, ev.Data))
+	switch ev.Event {
+	case "posted":
+		// Message processing is done concurrently
+		go mc.processPost(ev)
+	case "channel_created", "channel_deleted", "channel_updated", "channel_converted", "direct_added", "group_added", "new_user", "user_updated":
+		mc.updateMaps()
+	case "user_added", "user_removed":
+		// The robot was added to or removed from a private channel
+		if userID, _ := ev.Data["user_id"].(string); userID == mc.botID || ev.Broadcast.UserID == mc.botID {
+			mc.updateMaps()
+		}
+	case "":
+		if ev.Status != "OK" {
+			mc.Log(bot.Warn, fmt.Sprintf("Mattermost server replied '%s' to action #%d: %v", ev.Status, ev.SeqReply, ev.Data))
+		}
+	}
+}
+
+func (mc *mmConnector) Run(stop <-chan struct{}) {
+	mc.Lock()
+	// This should never happen, just a bit of defensive coding
+	if mc.running {
+		mc.Unlock()
+		return
+	}
+	mc.running = true
+	disconnected := mc.disconnected
+	mc.Unlock()
+
+loop:
+	for {
+		select {
+		case <-stop:
+			mc.Log(bot.Debug, "Received stop in connector")
+			mc.RLock()
+			mc.ws.Close()
+			mc.RUnlock()
+			break loop
+		case ev := <-mc.incoming:
+			mc.handle(ev)
+		case err := <-disconnected:
+			mc.Log(bot.Error, fmt.Sprintf("Disconnected from Mattermost server '%s': %v", mc.Server, err))
+			if !mc.reconnect(stop) {
+				break loop
+			}
+			// Catch up on anything that changed while disconnected
+			mc.updateMaps()
+			mc.RLock()
+			disconnected = mc.disconnected
+			mc.RUnlock()
+		}
+	}
+}
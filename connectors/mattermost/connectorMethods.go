@@ -0,0 +1,125 @@
+package mattermost
+
+import (
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// GetProtocolUserAttribute returns a string attribute or nil if Mattermost
+// doesn't have that information
+func (mc *mmConnector) GetProtocolUserAttribute(u, attr string) (value string, ret bot.RetVal) {
+	user, ok := mc.getUser(u)
+	if !ok {
+		return "", bot.UserNotFound
+	}
+	switch attr {
+	case "email":
+		return user.Email, bot.Ok
+	case "internalid":
+		return user.ID, bot.Ok
+	case "realname", "fullname", "real name", "full name":
+		return strings.TrimSpace(user.FirstName + " " + user.LastName), bot.Ok
+	case "firstname", "first name":
+		return user.FirstName, bot.Ok
+	case "lastname", "last name":
+		return user.LastName, bot.Ok
+	// Mattermost doesn't have a phone number for users
+	default:
+		return "", bot.AttributeNotFound
+	}
+}
+
+// Send a typing notifier letting the user know the message has been heard by
+// the robot.
+func (mc *mmConnector) MessageHeard(user, channel string) {
+	var chanID string
+	if len(channel) > 0 {
+		var ok bool
+		chanID, ok = mc.chanID(channel)
+		if !ok {
+			mc.Log(bot.Error, "Channel ID not found for:", channel)
+			return
+		}
+	} else {
+		var ret bot.RetVal
+		if chanID, ret = mc.dmChannel(user); ret != bot.Ok {
+			return
+		}
+	}
+	mc.sendAction("user_typing", map[string]interface{}{
+		"channel_id": chanID,
+		"parent_id":  "",
+	})
+}
+
+// SendProtocolChannelMessage sends a message to a channel
+func (mc *mmConnector) SendProtocolChannelMessage(ch string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	chanID, ok := mc.chanID(ch)
+	if !ok {
+		mc.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	mc.sendPosts(mc.mattermostifyMessage(msg, f), chanID, "")
+	return
+}
+
+// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+func (mc *mmConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	chanID, ok := mc.chanID(ch)
+	if !ok {
+		mc.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	mc.sendPosts(mc.mattermostifyMessage(msg, f), chanID, thr)
+	return
+}
+
+// SendProtocolUserChannelMessage sends a message to a channel, mentioning the
+// user
+func (mc *mmConnector) SendProtocolUserChannelMessage(u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return mc.SendProtocolUserChannelThreadMessage(u, ch, "", msg, f)
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a thread in a
+// channel, mentioning the user
+func (mc *mmConnector) SendProtocolUserChannelThreadMessage(u, ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	chanID, ok := mc.chanID(ch)
+	if !ok {
+		mc.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	if _, ok := mc.getUser(u); !ok {
+		return bot.UserNotFound
+	}
+	msgs := mc.mattermostifyMessage(msg, f)
+	msgs[0] = "@" + u + ": " + msgs[0]
+	mc.sendPosts(msgs, chanID, thr)
+	return
+}
+
+// SendProtocolUserMessage sends a direct message to a user
+func (mc *mmConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	dmID, ret := mc.dmChannel(u)
+	if ret != bot.Ok {
+		return
+	}
+	mc.sendPosts(mc.mattermostifyMessage(msg, f), dmID, "")
+	return bot.Ok
+}
+
+// JoinChannel joins a channel given it's human-readable name, e.g.
+// "town-square"
+func (mc *mmConnector) JoinChannel(c string) (ret bot.RetVal) {
+	chanID, ok := mc.chanID(c)
+	if !ok {
+		mc.Log(bot.Error, "Channel ID not found for:", c)
+		return bot.ChannelNotFound
+	}
+	member := map[string]string{"user_id": mc.botID}
+	if err := mc.api("POST", "/channels/"+chanID+"/members", member, nil); err != nil {
+		mc.Log(bot.Error, "Failed to join channel", c, ":", err)
+		return bot.FailedChannelJoin
+	}
+	return bot.Ok
+}
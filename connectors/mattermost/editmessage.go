@@ -0,0 +1,65 @@
+package mattermost
+
+/* editmessage.go - editing posts and adding reactions, implementing the
+robot's optional MessageEditor and Reactor interfaces.
+*/
+
+import (
+	"fmt"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// editableText returns the text for an editable post; since it can only be
+// a single post, long messages are truncated.
+func (mc *mmConnector) editableText(msg string, f bot.MessageFormat) string {
+	msgs := mc.mattermostifyMessage(msg, f)
+	if len(msgs) > 1 {
+		mc.Log(bot.Warn, fmt.Sprintf("Editable message too long, sending only the first of %d segments", len(msgs)))
+	}
+	return msgs[0]
+}
+
+// SendProtocolEditableMessage creates a post, returning the post ID
+func (mc *mmConnector) SendProtocolEditableMessage(u, ch, thr, msg string, f bot.MessageFormat) (string, bot.RetVal) {
+	chanID, ret := mc.destination(u, ch)
+	if ret != bot.Ok {
+		return "", ret
+	}
+	post := Post{ChannelID: chanID, RootID: thr, Message: mc.editableText(msg, f)}
+	var created Post
+	if err := mc.api("POST", "/posts", post, &created); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Sending editable message to channel '%s': %v", chanID, err))
+		return "", bot.FailedMessageSend
+	}
+	return created.ID, bot.Ok
+}
+
+// UpdateProtocolMessage replaces the text of a post
+func (mc *mmConnector) UpdateProtocolMessage(id, msg string, f bot.MessageFormat) bot.RetVal {
+	patch := map[string]string{"message": mc.editableText(msg, f)}
+	if err := mc.api("PUT", "/posts/"+id+"/patch", patch, nil); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Updating post '%s': %v", id, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// AddProtocolReaction adds a reaction to a post
+func (mc *mmConnector) AddProtocolReaction(raw interface{}, reaction string) bot.RetVal {
+	post, ok := raw.(*Post)
+	if !ok {
+		mc.Log(bot.Error, fmt.Sprintf("Unable to add reaction to raw message of type %T", raw))
+		return bot.ConnectorNotSupported
+	}
+	r := map[string]string{
+		"user_id":    mc.botID,
+		"post_id":    post.ID,
+		"emoji_name": reaction,
+	}
+	if err := mc.api("POST", "/reactions", r, nil); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Adding reaction '%s' to post '%s': %v", reaction, post.ID, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
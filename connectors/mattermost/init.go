@@ -0,0 +1,97 @@
+package mattermost
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+type config struct {
+	Server           string // base URL of the Mattermost server, e.g. https://mattermost.example.com
+	Token            string // personal access token or bot account token
+	Team             string // name of the team the robot works in
+	MaxMessageSplit  int    // the maximum # of posts to split a long message into before truncating
+	MaxMessageLength int    // maximum length of a post in characters, default 16383; use 4000 for servers older than 5.0
+}
+
+var lock sync.Mutex // package var lock
+var started bool    // set when connector is started
+
+func init() {
+	bot.RegisterConnector("mattermost", Initialize)
+}
+
+// Initialize logs in to the Mattermost server, loads the user and channel
+// maps, connects the websocket, and returns a connector object
+func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
+	lock.Lock()
+	if started {
+		lock.Unlock()
+		return nil
+	}
+	started = true
+	lock.Unlock()
+
+	var c config
+
+	err := robot.GetProtocolConfig(&c)
+	if err != nil {
+		robot.Log(bot.Fatal, fmt.Errorf("Unable to retrieve protocol configuration: %v", err))
+	}
+	if len(c.Server) == 0 || len(c.Token) == 0 || len(c.Team) == 0 {
+		robot.Log(bot.Fatal, "Mattermost connector requires Server, Token and Team in ProtocolConfig")
+	}
+	c.Server = strings.TrimRight(c.Server, "/")
+	if c.MaxMessageSplit == 0 {
+		c.MaxMessageSplit = 1
+	}
+	if c.MaxMessageLength <= 0 {
+		c.MaxMessageLength = 16383
+	}
+
+	mc := &mmConnector{
+		config:      c,
+		channelToID: make(map[string]string),
+		idToChannel: make(map[string]string),
+		userInfo:    make(map[string]User),
+		idToUser:    make(map[string]string),
+		userIDToDM:  make(map[string]string),
+		dmToUser:    make(map[string]string),
+		incoming:    make(chan *wsEvent, 64),
+	}
+	mc.Handler = robot
+
+	var me User
+	if err := mc.api("GET", "/users/me", nil, &me); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Logging in to Mattermost server '%s': %v", c.Server, err))
+	}
+	mc.botID = me.ID
+	mc.botName = me.Username
+	mc.Log(bot.Trace, "Set bot ID to", mc.botID)
+	var team struct {
+		ID string `json:"id"`
+	}
+	if err := mc.api("GET", "/teams/name/"+c.Team, nil, &team); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Looking up team '%s': %v", c.Team, err))
+	}
+	mc.teamID = team.ID
+
+	mc.updateMaps()
+	if err := mc.connect(); err != nil {
+		robot.Log(bot.Fatal, fmt.Sprintf("Connecting to Mattermost websocket: %v", err))
+	}
+
+	mc.SetName(mc.botName)
+	mc.Log(bot.Info, "Mattermost setting bot name to", mc.botName)
+	fullName := strings.TrimSpace(me.FirstName + " " + me.LastName)
+	if len(fullName) == 0 {
+		fullName = mc.botName
+	}
+	mc.SetFullName(fullName)
+	mc.Log(bot.Debug, "Set bot full name to", fullName)
+
+	return bot.Connector(mc)
+}
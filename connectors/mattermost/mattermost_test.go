@@ -0,0 +1,408 @@
+package mattermost
+
+/* mattermost_test.go - tests the connector against a minimal Mattermost
+stand-in built on httptest, which serves the REST endpoints the connector uses
+and the websocket, recording posts and actions from the robot.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+const testToken = "testtoken"
+
+type heardMessage struct {
+	channel, user, message string
+	thread                 string // set for messages in a thread
+}
+
+// testHandler stands in for the robot
+type testHandler struct {
+	config string
+	heard  chan heardMessage
+}
+
+func (h *testHandler) IncomingMessage(channel, user, message string, raw interface{}) {
+	h.heard <- heardMessage{channel, user, message, ""}
+}
+func (h *testHandler) IncomingThreadMessage(channel, thread string, threaded bool, user, message string, raw interface{}) {
+	if !threaded {
+		thread = ""
+	}
+	h.heard <- heardMessage{channel, user, message, thread}
+}
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	return json.Unmarshal([]byte(h.config), v)
+}
+func (h *testHandler) GetBrainConfig(v interface{}) error   { return nil }
+func (h *testHandler) GetHistoryConfig(v interface{}) error { return nil }
+func (h *testHandler) SetFullName(n string)                 {}
+func (h *testHandler) SetName(n string)                     {}
+func (h *testHandler) GetLogLevel() bot.LogLevel            { return bot.Debug }
+func (h *testHandler) GetLogToFile() bool                   { return false }
+func (h *testHandler) GetInstallPath() string               { return "" }
+func (h *testHandler) GetConfigPath() string                { return "" }
+func (h *testHandler) Log(l bot.LogLevel, v ...interface{}) {}
+
+// testServer is the Mattermost stand-in
+type testServer struct {
+	*httptest.Server
+	users    []User
+	channels []Channel
+	posts    chan Post
+	uploads  chan string // "name: content" for each uploaded file
+	edits    chan string // "id: message" for each post edit
+	reacts   chan string // "post id: emoji" for each reaction
+	actions  chan map[string]interface{}
+	joins    chan string
+	conns    chan *websocket.Conn
+	t        *testing.T
+	sync.Mutex
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{
+		users: []User{
+			{ID: "botid", Username: "floyd", FirstName: "Floyd", LastName: "Gopherbot"},
+			{ID: "aliceid", Username: "alice", Email: "alice@example.com", FirstName: "Alice", LastName: "User"},
+			{ID: "bobid", Username: "bob", Email: "bob@example.com"},
+			{ID: "goneid", Username: "gone", DeleteAt: 1},
+		},
+		channels: []Channel{
+			{ID: "townid", TeamID: "teamid", Type: "O", Name: "town-square"},
+			{ID: "aliceid__botid", Type: "D", Name: "aliceid__botid"},
+		},
+		posts:   make(chan Post, 8),
+		uploads: make(chan string, 2),
+		edits:   make(chan string, 2),
+		reacts:  make(chan string, 2),
+		actions: make(chan map[string]interface{}, 8),
+		joins:   make(chan string, 8),
+		conns:   make(chan *websocket.Conn, 2),
+		t:       t,
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *testServer) reply(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+testToken {
+		http.Error(w, `{"message": "invalid token"}`, http.StatusUnauthorized)
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	path := strings.TrimPrefix(r.URL.Path, "/api/v4")
+	switch {
+	case path == "/websocket":
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			s.t.Errorf("Upgrading websocket: %v", err)
+			return
+		}
+		ws.WriteJSON(map[string]interface{}{"event": "hello", "data": map[string]string{"server_version": "5.0.0"}})
+		go func() {
+			for {
+				var action map[string]interface{}
+				if err := ws.ReadJSON(&action); err != nil {
+					return
+				}
+				s.actions <- action
+			}
+		}()
+		s.conns <- ws
+	case path == "/users/me":
+		s.reply(w, s.users[0])
+	case path == "/teams/name/ateam":
+		s.reply(w, map[string]string{"id": "teamid"})
+	case path == "/users":
+		if r.URL.Query().Get("page") != "0" {
+			s.reply(w, []User{})
+			return
+		}
+		s.reply(w, s.users)
+	case path == "/users/me/teams/teamid/channels":
+		s.reply(w, s.channels)
+	case path == "/teams/teamid/channels":
+		s.reply(w, []Channel{})
+	case path == "/files" && r.Method == "POST":
+		r.ParseMultipartForm(1 << 20)
+		f, header, err := r.FormFile("files")
+		if err != nil || r.FormValue("channel_id") == "" {
+			http.Error(w, `{"message": "bad upload"}`, http.StatusBadRequest)
+			return
+		}
+		content, _ := ioutil.ReadAll(f)
+		f.Close()
+		s.uploads <- header.Filename + ": " + string(content)
+		s.reply(w, map[string]interface{}{"file_infos": []map[string]string{{"id": "fileid"}}})
+	case path == "/posts" && r.Method == "POST":
+		var p Post
+		json.NewDecoder(r.Body).Decode(&p)
+		s.posts <- p
+		if p.ID == "" {
+			p.ID = "newpostid"
+		}
+		s.reply(w, p)
+	case strings.HasSuffix(path, "/patch") && r.Method == "PUT":
+		var patch map[string]string
+		json.NewDecoder(r.Body).Decode(&patch)
+		s.edits <- strings.Split(path, "/")[2] + ": " + patch["message"]
+		s.reply(w, map[string]string{})
+	case path == "/reactions" && r.Method == "POST":
+		var reaction map[string]string
+		json.NewDecoder(r.Body).Decode(&reaction)
+		s.reacts <- reaction["post_id"] + ": " + reaction["emoji_name"]
+		s.reply(w, reaction)
+	case path == "/channels/direct" && r.Method == "POST":
+		var ids []string
+		json.NewDecoder(r.Body).Decode(&ids)
+		name := strings.Join(ids, "__")
+		c := Channel{ID: name, Type: "D", Name: name}
+		s.channels = append(s.channels, c)
+		s.reply(w, c)
+	case strings.HasSuffix(path, "/members") && r.Method == "POST":
+		s.joins <- strings.Split(path, "/")[2]
+		s.reply(w, map[string]string{})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *testServer) addChannel(c Channel) {
+	s.Lock()
+	s.channels = append(s.channels, c)
+	s.Unlock()
+}
+
+// send sends an event to the robot over the websocket
+func (s *testServer) send(ws *websocket.Conn, event string, data map[string]interface{}) {
+	if err := ws.WriteJSON(map[string]interface{}{"event": event, "data": data}); err != nil {
+		s.t.Fatalf("Sending '%s' event: %v", event, err)
+	}
+}
+
+// sendPost sends a posted event to the robot
+func (s *testServer) sendPost(ws *websocket.Conn, channelType string, post Post) {
+	p, _ := json.Marshal(post)
+	s.send(ws, "posted", map[string]interface{}{"channel_type": channelType, "post": string(p)})
+}
+
+func (s *testServer) conn() *websocket.Conn {
+	select {
+	case ws := <-s.conns:
+		return ws
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("Timed out waiting for websocket connection")
+	}
+	return nil
+}
+
+func (s *testServer) expectPost(want Post) {
+	select {
+	case got := <-s.posts:
+		if got.ChannelID != want.ChannelID || got.RootID != want.RootID || got.Message != want.Message {
+			s.t.Errorf("Post from robot; want %+v, got %+v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		s.t.Errorf("Timed out waiting for post from robot: %+v", want)
+	}
+}
+
+func (s *testServer) expectHeard(h *testHandler, want heardMessage) {
+	select {
+	case got := <-h.heard:
+		if got != want {
+			s.t.Errorf("Message to robot; want %+v, got %+v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		s.t.Errorf("Timed out waiting for message to robot: %+v", want)
+	}
+}
+
+func TestConnector(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	h := &testHandler{
+		config: fmt.Sprintf(`{
+			"Server": "%s/",
+			"Token": "%s",
+			"Team": "ateam",
+			"MaxMessageSplit": 2,
+			"MaxMessageLength": 20
+		}`, s.URL, testToken),
+		heard: make(chan heardMessage, 4),
+	}
+
+	c := Initialize(h, nil)
+	ws := s.conn()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(stop)
+		close(stopped)
+	}()
+
+	if email, ret := c.GetProtocolUserAttribute("alice", "email"); ret != bot.Ok || email != "alice@example.com" {
+		t.Errorf("GetProtocolUserAttribute email for alice; want alice@example.com, got '%s' (%s)", email, ret)
+	}
+	if name, ret := c.GetProtocolUserAttribute("alice", "fullname"); ret != bot.Ok || name != "Alice User" {
+		t.Errorf("GetProtocolUserAttribute fullname for alice; want 'Alice User', got '%s' (%s)", name, ret)
+	}
+	if _, ret := c.GetProtocolUserAttribute("gone", "email"); ret != bot.UserNotFound {
+		t.Errorf("GetProtocolUserAttribute for deleted user; want UserNotFound, got %s", ret)
+	}
+
+	s.sendPost(ws, "O", Post{ChannelID: "townid", UserID: "aliceid", Message: "floyd: ping"})
+	s.expectHeard(h, heardMessage{"town-square", "alice", "floyd: ping", ""})
+	// The robot's own posts and system messages are ignored
+	s.sendPost(ws, "O", Post{ChannelID: "townid", UserID: "botid", Message: "PONG"})
+	s.sendPost(ws, "O", Post{ChannelID: "townid", UserID: "bobid", Type: "system_join_channel"})
+	s.sendPost(ws, "D", Post{ChannelID: "aliceid__botid", UserID: "aliceid", Message: "hello there"})
+	s.expectHeard(h, heardMessage{"", "alice", "hello there", ""})
+
+	c.MessageHeard("alice", "town-square")
+	select {
+	case a := <-s.actions:
+		data, _ := a["data"].(map[string]interface{})
+		if a["action"] != "user_typing" || data["channel_id"] != "townid" {
+			t.Errorf("Action from robot; want user_typing for townid, got %v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for typing indicator")
+	}
+
+	c.SendProtocolUserChannelMessage("alice", "town-square", "*hi*", bot.Variable)
+	s.expectPost(Post{ChannelID: "townid", Message: `@alice: \*hi\*`})
+	// Posts in a thread carry the root post ID, and replies can go to the
+	// thread
+	s.sendPost(ws, "O", Post{ID: "replyid", ChannelID: "townid", UserID: "aliceid", RootID: "rootid", Message: "floyd: ping"})
+	s.expectHeard(h, heardMessage{"town-square", "alice", "floyd: ping", "rootid"})
+	c.SendProtocolUserChannelThreadMessage("alice", "town-square", "rootid", "pong", bot.Raw)
+	s.expectPost(Post{ChannelID: "townid", RootID: "rootid", Message: "@alice: pong"})
+	// bob has no DM channel yet
+	c.SendProtocolUserMessage("bob", "hi bob", bot.Raw)
+	s.expectPost(Post{ChannelID: "botid__bobid", Message: "hi bob"})
+	// Long messages are split, then truncated after MaxMessageSplit posts
+	c.SendProtocolChannelMessage("town-square", "first line\n"+strings.Repeat("x", 50), bot.Raw)
+	s.expectPost(Post{ChannelID: "townid", Message: "first line"})
+	s.expectPost(Post{ChannelID: "townid", Message: strings.Repeat("x", 20)})
+	s.expectPost(Post{ChannelID: "townid", Message: "(message too long, truncated)"})
+	if ret := c.SendProtocolChannelMessage("nowhere", "hello", bot.Raw); ret != bot.ChannelNotFound {
+		t.Errorf("SendProtocolChannelMessage to unknown channel; want ChannelNotFound, got %s", ret)
+	}
+
+	// Files are uploaded, then posted with the comment
+	if ret := c.(bot.FileSender).SendProtocolFile("alice", "town-square", "rootid", &bot.File{Name: "report.txt", Comment: "the report", Content: []byte("all good")}); ret != bot.Ok {
+		t.Errorf("SendProtocolFile; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.uploads:
+		if got != "report.txt: all good" {
+			t.Errorf("File upload from robot; want 'report.txt: all good', got '%s'", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for file upload")
+	}
+	select {
+	case got := <-s.posts:
+		if got.ChannelID != "townid" || got.RootID != "rootid" || got.Message != "the report" || len(got.FileIDs) != 1 || got.FileIDs[0] != "fileid" {
+			t.Errorf("Post with file from robot; got %+v", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for post with file")
+	}
+	// Attachments go in the post props, by DM when there's no channel
+	attachment := &bot.Attachment{Title: "Build", Text: "passed", Fields: []bot.AttachmentField{{Title: "Branch", Value: "master"}}}
+	if ret := c.(bot.AttachmentSender).SendProtocolAttachment("alice", "", "", attachment); ret != bot.Ok {
+		t.Errorf("SendProtocolAttachment; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.posts:
+		attachments, _ := got.Props["attachments"].([]interface{})
+		if got.ChannelID != "aliceid__botid" || len(attachments) != 1 {
+			t.Errorf("Post with attachment from robot; got %+v", got)
+		} else if a, _ := attachments[0].(map[string]interface{}); a["title"] != "Build" || a["fallback"] != "Build\npassed\nBranch: master" {
+			t.Errorf("Attachment from robot; got %v", a)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for post with attachment")
+	}
+
+	// Editable messages return the post ID for updating the post
+	editor := c.(bot.MessageEditor)
+	id, ret := editor.SendProtocolEditableMessage("alice", "town-square", "", "Starting", bot.Raw)
+	if ret != bot.Ok || id != "newpostid" {
+		t.Errorf("SendProtocolEditableMessage; want Ok and newpostid, got %s and '%s'", ret, id)
+	}
+	s.expectPost(Post{ChannelID: "townid", Message: "Starting"})
+	if ret := editor.UpdateProtocolMessage(id, "Finished", bot.Raw); ret != bot.Ok {
+		t.Errorf("UpdateProtocolMessage; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.edits:
+		if got != "newpostid: Finished" {
+			t.Errorf("Post edit from robot; want 'newpostid: Finished', got '%s'", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for post edit")
+	}
+	// Reactions go on the post the robot heard
+	if ret := c.(bot.Reactor).AddProtocolReaction(&Post{ID: "replyid", ChannelID: "townid"}, "thumbsup"); ret != bot.Ok {
+		t.Errorf("AddProtocolReaction; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.reacts:
+		if got != "replyid: thumbsup" {
+			t.Errorf("Reaction from robot; want 'replyid: thumbsup', got '%s'", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for reaction")
+	}
+
+	// New channels are picked up when the robot hears about them
+	s.addChannel(Channel{ID: "newid", TeamID: "teamid", Type: "O", Name: "new-channel"})
+	s.send(ws, "channel_created", map[string]interface{}{"channel_id": "newid", "team_id": "teamid"})
+	s.sendPost(ws, "O", Post{ChannelID: "newid", UserID: "bobid", Message: "anybody here?"})
+	s.expectHeard(h, heardMessage{"new-channel", "bob", "anybody here?", ""})
+	if ret := c.JoinChannel("new-channel"); ret != bot.Ok {
+		t.Errorf("JoinChannel; want Ok, got %s", ret)
+	}
+	select {
+	case id := <-s.joins:
+		if id != "newid" {
+			t.Errorf("JoinChannel; want newid, got %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for channel join")
+	}
+
+	// After a disconnect, the robot reconnects
+	ws.Close()
+	ws = s.conn()
+	s.sendPost(ws, "O", Post{ChannelID: "townid", UserID: "aliceid", Message: "welcome back"})
+	s.expectHeard(h, heardMessage{"town-square", "alice", "welcome back", ""})
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for connector to stop")
+	}
+}
@@ -0,0 +1,118 @@
+package mattermost
+
+/* richmessage.go - file uploads and message attachments, implementing the
+robot's optional FileSender and AttachmentSender interfaces.
+*/
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+
+	"github.com/lnxjedi/gopherbot/bot"
+)
+
+// destination returns the channel ID for a file or attachment; the channel
+// if given, or else the DM channel with the user.
+func (mc *mmConnector) destination(u, ch string) (string, bot.RetVal) {
+	if len(ch) == 0 {
+		return mc.dmChannel(u)
+	}
+	chanID, ok := mc.chanID(ch)
+	if !ok {
+		mc.Log(bot.Error, "Channel ID not found for:", ch)
+		return "", bot.ChannelNotFound
+	}
+	return chanID, bot.Ok
+}
+
+// uploadFile uploads a file to a channel, returning the file ID for
+// attaching it to a post
+func (mc *mmConnector) uploadFile(chanID string, f *bot.File) (string, error) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	w.WriteField("channel_id", chanID)
+	part, err := w.CreateFormFile("files", f.Name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := part.Write(f.Content); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", mc.Server+"/api/v4/files", &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	var uploaded struct {
+		FileInfos []struct {
+			ID string `json:"id"`
+		} `json:"file_infos"`
+	}
+	if err := mc.do(req, &uploaded); err != nil {
+		return "", err
+	}
+	if len(uploaded.FileInfos) == 0 {
+		return "", fmt.Errorf("no file info returned uploading '%s'", f.Name)
+	}
+	return uploaded.FileInfos[0].ID, nil
+}
+
+// SendProtocolFile uploads a file and posts it with the comment, if any
+func (mc *mmConnector) SendProtocolFile(u, ch, thr string, f *bot.File) bot.RetVal {
+	chanID, ret := mc.destination(u, ch)
+	if ret != bot.Ok {
+		return ret
+	}
+	fileID, err := mc.uploadFile(chanID, f)
+	if err == nil {
+		post := Post{ChannelID: chanID, RootID: thr, Message: f.Comment, FileIDs: []string{fileID}}
+		err = mc.api("POST", "/posts", post, nil)
+	}
+	if err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Sending file '%s' to channel '%s': %v", f.Name, chanID, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// SendProtocolAttachment posts a message attachment; Mattermost doesn't
+// support Slack's Block Kit, so any Blocks are ignored.
+func (mc *mmConnector) SendProtocolAttachment(u, ch, thr string, a *bot.Attachment) bot.RetVal {
+	chanID, ret := mc.destination(u, ch)
+	if ret != bot.Ok {
+		return ret
+	}
+	fields := make([]map[string]interface{}, 0, len(a.Fields))
+	for _, field := range a.Fields {
+		fields = append(fields, map[string]interface{}{
+			"title": field.Title,
+			"value": field.Value,
+			"short": field.Short,
+		})
+	}
+	attachment := map[string]interface{}{
+		"fallback":   a.FallbackText(),
+		"color":      a.Color,
+		"pretext":    a.Pretext,
+		"title":      a.Title,
+		"title_link": a.TitleLink,
+		"text":       a.Text,
+		"fields":     fields,
+		"footer":     a.Footer,
+	}
+	post := Post{
+		ChannelID: chanID,
+		RootID:    thr,
+		Props:     map[string]interface{}{"attachments": []interface{}{attachment}},
+	}
+	if err := mc.api("POST", "/posts", post, nil); err != nil {
+		mc.Log(bot.Error, fmt.Sprintf("Sending attachment to channel '%s': %v", chanID, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
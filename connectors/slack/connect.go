@@ -14,6 +14,7 @@ import (
 
 type config struct {
 	SlackToken      string // the 'bot token for connecting to Slack
+	AppToken        string // an app-level token with connections:write, for Socket Mode instead of RTM
 	MaxMessageSplit int    // the maximum # of ~4000 byte messages to split a large message into
 }
 
@@ -39,7 +40,7 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 	lock.Unlock()
 
 	var c config
-	var tok string
+	var tok, appTok string
 
 	err := robot.GetProtocolConfig(&c)
 	if err != nil {
@@ -59,6 +60,14 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 	} else {
 		tok = c.SlackToken
 	}
+	if len(c.AppToken) == 0 {
+		appTok = os.Getenv("SLACK_APP_TOKEN")
+		if len(appTok) > 0 {
+			robot.Log(bot.Debug, "Using SLACK_APP_TOKEN environment variable")
+		}
+	} else {
+		appTok = c.AppToken
+	}
 
 	api := slack.New(tok)
 	// This spits out a lot of extra stuff, so we only enable it when tracing
@@ -69,14 +78,51 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 
 	sc := &slackConnector{
 		api:             api,
-		conn:            api.NewRTM(),
+		token:           tok,
+		appToken:        appTok,
 		maxMessageSplit: c.MaxMessageSplit,
 		name:            "slack",
 	}
-	go sc.conn.ManageConnection()
-
 	sc.Handler = robot
 
+	if sc.socketMode() {
+		sc.connectSocketMode()
+	} else {
+		sc.connectRTM()
+	}
+
+	sc.updateMaps()
+	sc.botFullName = sc.userInfo[sc.botName].RealName
+	sc.SetFullName(sc.botFullName)
+	sc.Log(bot.Debug, "Set bot full name to", sc.botFullName)
+	go sc.startSendLoop()
+
+	return bot.Connector(sc)
+}
+
+// connectSocketMode looks up the robot's identity with the bot token, then
+// opens the Socket Mode websocket
+func (sc *slackConnector) connectSocketMode() {
+	auth, err := sc.api.AuthTest()
+	if err != nil {
+		sc.Log(bot.Fatal, fmt.Sprintf("Invalid credentials: %v", err))
+	}
+	sc.botName = auth.User
+	sc.SetName(sc.botName)
+	sc.Log(bot.Info, "Slack setting bot name to", sc.botName)
+	sc.botID = auth.UserID
+	sc.Log(bot.Trace, "Set bot ID to", sc.botID)
+	sc.socketEvents = make(chan *socketEnvelope, 64)
+	if err := sc.openSocket(); err != nil {
+		sc.Log(bot.Fatal, fmt.Sprintf("Connecting to Slack in Socket Mode: %v", err))
+	}
+}
+
+// connectRTM starts the RTM connection and waits for the connected event
+func (sc *slackConnector) connectRTM() {
+	sc.conn = sc.api.NewRTM()
+	go sc.conn.ManageConnection()
+
 Loop:
 	for {
 		select {
@@ -106,14 +152,6 @@ Loop:
 			}
 		}
 	}
-
-	sc.updateMaps()
-	sc.botFullName = sc.userInfo[sc.botName].RealName
-	sc.SetFullName(sc.botFullName)
-	sc.Log(bot.Debug, "Set bot full name to", sc.botFullName)
-	go sc.startSendLoop()
-
-	return bot.Connector(sc)
 }
 
 func (sc *slackConnector) Run(stop <-chan struct{}) {
@@ -125,6 +163,10 @@ func (sc *slackConnector) Run(stop <-chan struct{}) {
 	}
 	sc.running = true
 	sc.Unlock()
+	if sc.socketMode() {
+		sc.runSocketMode(stop)
+		return
+	}
 loop:
 	for {
 		select {
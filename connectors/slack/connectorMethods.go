@@ -48,14 +48,18 @@ func (s *slackConnector) GetProtocolUserAttribute(u, attr string) (value string,
 
 type sendMessage struct {
 	message, channel string
+	thread           string // timestamp of the thread to reply in, if any
 	format           bot.MessageFormat
 }
 
 var messages = make(chan *sendMessage)
 
 // Send a typing notifier letting the user know the message has been heard by
-// the robot.
+// the robot. Typing indicators are only available with RTM.
 func (s *slackConnector) MessageHeard(user, channel string) {
+	if s.conn == nil {
+		return
+	}
 	var userID, chanID string
 	var ok bool
 	var err error
@@ -74,7 +78,7 @@ func (s *slackConnector) MessageHeard(user, channel string) {
 		chanID, ok = s.userIMID(userID)
 		if !ok {
 			s.Log(bot.Warn, "No IM channel found for user:", user, "ID:", userID, "trying to open IM")
-			_, _, chanID, err = s.conn.OpenIMChannel(userID)
+			chanID, err = s.openIM(userID)
 			if err != nil {
 				s.Log(bot.Error, "Unable to open an IM channel to user:", user, "ID:", userID)
 				return
@@ -105,8 +109,9 @@ func (s *slackConnector) startSendLoop() {
 		// s.conn.SendMessage(s.conn.NewTypingMessage(send.channel))
 		time.Sleep(typingDelay)
 		params := slack.PostMessageParameters{
-			AsUser:      true,
-			UnfurlMedia: true,
+			AsUser:          true,
+			UnfurlMedia:     true,
+			ThreadTimestamp: send.thread,
 		}
 		sent := false
 		for p := range []int{1, 2, 4} {
@@ -122,8 +127,12 @@ func (s *slackConnector) startSendLoop() {
 			}
 		}
 		if !sent {
-			s.Log(bot.Error, fmt.Sprintf("Failed sending message '%s' to channel '%s' after 3 tries, attempting fallback to RTM", send.message, send.channel))
-			s.conn.SendMessage(s.conn.NewOutgoingMessage(send.message, send.channel))
+			if s.conn != nil {
+				s.Log(bot.Error, fmt.Sprintf("Failed sending message '%s' to channel '%s' after 3 tries, attempting fallback to RTM", send.message, send.channel))
+				s.conn.SendMessage(s.conn.NewOutgoingMessage(send.message, send.channel))
+			} else {
+				s.Log(bot.Error, fmt.Sprintf("Failed sending message '%s' to channel '%s' after 3 tries", send.message, send.channel))
+			}
 		}
 		timeSinceBurst := msgTime.Sub(burstTime)
 		if msgTime.Sub(mtimes[windowStartMsg]) < burstWindow || timeSinceBurst < coolDown {
@@ -138,11 +147,12 @@ func (s *slackConnector) startSendLoop() {
 	}
 }
 
-func (s *slackConnector) sendMessages(msgs []string, chanID string, f bot.MessageFormat) {
+func (s *slackConnector) sendMessages(msgs []string, chanID, thread string, f bot.MessageFormat) {
 	for _, msg := range msgs {
 		messages <- &sendMessage{
 			message: msg,
 			channel: chanID,
+			thread:  thread,
 			format:  f,
 		}
 	}
@@ -156,12 +166,31 @@ func (s *slackConnector) SendProtocolChannelMessage(ch string, msg string, f bot
 		return bot.ChannelNotFound
 	}
 	msgs := s.slackifyMessage(msg, f)
-	s.sendMessages(msgs, chanID, f)
+	s.sendMessages(msgs, chanID, "", f)
 	return
 }
 
-// SendProtocolChannelMessage sends a message to a channel
+// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+func (s *slackConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	chanID, ok := s.chanID(ch)
+	if !ok {
+		s.Log(bot.Error, "Channel ID not found for:", ch)
+		return bot.ChannelNotFound
+	}
+	msgs := s.slackifyMessage(msg, f)
+	s.sendMessages(msgs, chanID, thr, f)
+	return
+}
+
+// SendProtocolUserChannelMessage sends a message to a channel, mentioning the
+// user
 func (s *slackConnector) SendProtocolUserChannelMessage(u, ch, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return s.SendProtocolUserChannelThreadMessage(u, ch, "", msg, f)
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a thread in a
+// channel, mentioning the user
+func (s *slackConnector) SendProtocolUserChannelThreadMessage(u, ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
 	chanID, ok := s.chanID(ch)
 	if !ok {
 		s.Log(bot.Error, "Channel ID not found for:", ch)
@@ -174,7 +203,7 @@ func (s *slackConnector) SendProtocolUserChannelMessage(u, ch, msg string, f bot
 	}
 	msg = "@" + u + ": " + msg
 	msgs := s.slackifyMessage(msg, f)
-	s.sendMessages(msgs, chanID, f)
+	s.sendMessages(msgs, chanID, thr, f)
 	return
 }
 
@@ -190,7 +219,7 @@ func (s *slackConnector) SendProtocolUserMessage(u string, msg string, f bot.Mes
 	userIMchan, ok = s.userIMID(userID)
 	if !ok {
 		s.Log(bot.Warn, "No IM channel found for user:", u, "ID:", userID, "trying to open IM")
-		_, _, userIMchan, err = s.conn.OpenIMChannel(userID)
+		userIMchan, err = s.openIM(userID)
 		if err != nil {
 			s.Log(bot.Error, "Unable to open an IM channel to user:", u, "ID:", userID)
 			ret = bot.FailedUserDM
@@ -200,7 +229,7 @@ func (s *slackConnector) SendProtocolUserMessage(u string, msg string, f bot.Mes
 		return
 	}
 	msgs := s.slackifyMessage(msg, f)
-	s.sendMessages(msgs, userIMchan, f)
+	s.sendMessages(msgs, userIMchan, "", f)
 	return bot.Ok
 }
 
@@ -211,7 +240,12 @@ func (s *slackConnector) JoinChannel(c string) (ret bot.RetVal) {
 		s.Log(bot.Error, "Channel ID not found for:", c)
 		return bot.ChannelNotFound
 	}
-	_, err := s.api.JoinChannel(chanID)
+	var err error
+	if s.socketMode() {
+		_, _, _, err = s.api.JoinConversation(chanID)
+	} else {
+		_, err = s.api.JoinChannel(chanID)
+	}
 	if err != nil {
 		s.Log(bot.Error, "Failed to join channel", c, ":", err, "(try inviting the bot)")
 		return bot.FailedChannelJoin
@@ -0,0 +1,94 @@
+package slack
+
+/* editmessage.go - editing messages and adding reactions, implementing the
+robot's optional MessageEditor and Reactor interfaces with the Web API.
+*/
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/lnxjedi/gopherbot/bot"
+	"github.com/nlopes/slack"
+)
+
+// editableText returns the text for an editable message; since it can only
+// be a single message, long messages are truncated.
+func (s *slackConnector) editableText(msg string, f bot.MessageFormat) string {
+	msgs := s.slackifyMessage(msg, f)
+	if len(msgs) > 1 {
+		s.Log(bot.Warn, fmt.Sprintf("Editable message too long, sending only the first of %d segments", len(msgs)))
+	}
+	return msgs[0]
+}
+
+// SendProtocolEditableMessage posts a message with chat.postMessage, which
+// returns the timestamp Slack uses as a message ID. The returned ID is
+// "<channel ID>:<timestamp>", since chat.update needs both.
+func (s *slackConnector) SendProtocolEditableMessage(u, ch, thr, msg string, f bot.MessageFormat) (string, bot.RetVal) {
+	chanID, ret := s.destination(u, ch)
+	if ret != bot.Ok {
+		return "", ret
+	}
+	values := url.Values{
+		"channel": {chanID},
+		"text":    {s.editableText(msg, f)},
+		"as_user": {"true"},
+	}
+	if len(thr) > 0 {
+		values.Set("thread_ts", thr)
+	}
+	var posted struct {
+		Channel   string `json:"channel"`
+		Timestamp string `json:"ts"`
+	}
+	if err := webAPI("chat.postMessage", s.token, values, &posted); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Sending editable message to channel '%s': %v", chanID, err))
+		return "", bot.FailedMessageSend
+	}
+	return posted.Channel + ":" + posted.Timestamp, bot.Ok
+}
+
+// UpdateProtocolMessage replaces the text of a message with chat.update
+func (s *slackConnector) UpdateProtocolMessage(id, msg string, f bot.MessageFormat) bot.RetVal {
+	idParts := strings.SplitN(id, ":", 2)
+	if len(idParts) != 2 {
+		s.Log(bot.Error, "Invalid message ID for update:", id)
+		return bot.FailedMessageSend
+	}
+	values := url.Values{
+		"channel": {idParts[0]},
+		"ts":      {idParts[1]},
+		"text":    {s.editableText(msg, f)},
+		"as_user": {"true"},
+	}
+	if err := webAPI("chat.update", s.token, values, nil); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Updating message '%s': %v", id, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// AddProtocolReaction adds a reaction to a message with reactions.add
+func (s *slackConnector) AddProtocolReaction(raw interface{}, reaction string) bot.RetVal {
+	msg, ok := raw.(*slack.MessageEvent)
+	if !ok {
+		s.Log(bot.Error, fmt.Sprintf("Unable to add reaction to raw message of type %T", raw))
+		return bot.ConnectorNotSupported
+	}
+	timestamp := msg.Timestamp
+	if msg.SubType == "message_changed" && msg.SubMessage != nil {
+		timestamp = msg.SubMessage.Timestamp
+	}
+	values := url.Values{
+		"channel":   {msg.Channel},
+		"timestamp": {timestamp},
+		"name":      {reaction},
+	}
+	if err := webAPI("reactions.add", s.token, values, nil); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Adding reaction '%s' to message in channel '%s': %v", reaction, msg.Channel, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
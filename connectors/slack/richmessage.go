@@ -0,0 +1,133 @@
+package slack
+
+/* richmessage.go - file uploads and attachments, implementing the robot's
+optional FileSender and AttachmentSender interfaces with the Web API.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+
+	"github.com/lnxjedi/gopherbot/bot"
+	"github.com/nlopes/slack"
+)
+
+// destination returns the channel ID for a file or attachment; the channel
+// if given, or else an IM with the user.
+func (s *slackConnector) destination(u, ch string) (string, bot.RetVal) {
+	if len(ch) > 0 {
+		chanID, ok := s.chanID(ch)
+		if !ok {
+			s.Log(bot.Error, "Channel ID not found for:", ch)
+			return "", bot.ChannelNotFound
+		}
+		return chanID, bot.Ok
+	}
+	userID, ok := s.userID(u)
+	if !ok {
+		s.Log(bot.Error, "No user ID found for user:", u)
+		return "", bot.UserNotFound
+	}
+	imID, ok := s.userIMID(userID)
+	if !ok {
+		var err error
+		if imID, err = s.openIM(userID); err != nil {
+			s.Log(bot.Error, "Unable to open an IM channel to user:", u, "ID:", userID)
+			return "", bot.FailedUserDM
+		}
+	}
+	return imID, bot.Ok
+}
+
+// SendProtocolFile uploads a file with files.upload
+func (s *slackConnector) SendProtocolFile(u, ch, thr string, f *bot.File) bot.RetVal {
+	chanID, ret := s.destination(u, ch)
+	if ret != bot.Ok {
+		return ret
+	}
+	fields := map[string]string{
+		"token":    s.token,
+		"channels": chanID,
+		"filename": f.Name,
+		"title":    f.Name,
+	}
+	if len(f.Comment) > 0 {
+		fields["initial_comment"] = f.Comment
+	}
+	if len(thr) > 0 {
+		fields["thread_ts"] = thr
+	}
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		w.WriteField(name, value)
+	}
+	part, err := w.CreateFormFile("file", f.Name)
+	if err == nil {
+		_, err = part.Write(f.Content)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		client := &http.Client{Timeout: apiTimeout}
+		var resp *http.Response
+		if resp, err = client.Post(slack.SLACK_API+"files.upload", w.FormDataContentType(), &body); err == nil {
+			err = apiResult("files.upload", resp, nil)
+		}
+	}
+	if err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Uploading file '%s' to channel '%s': %v", f.Name, chanID, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
+
+// SendProtocolAttachment posts a message with an attachment, or with Block
+// Kit blocks if the attachment has them.
+func (s *slackConnector) SendProtocolAttachment(u, ch, thr string, a *bot.Attachment) bot.RetVal {
+	chanID, ret := s.destination(u, ch)
+	if ret != bot.Ok {
+		return ret
+	}
+	values := url.Values{
+		"channel": {chanID},
+		"as_user": {"true"},
+	}
+	if len(thr) > 0 {
+		values.Set("thread_ts", thr)
+	}
+	if len(a.Blocks) > 0 {
+		// The text is shown in notifications
+		values.Set("blocks", string(a.Blocks))
+		values.Set("text", a.FallbackText())
+	} else {
+		attachment := slack.Attachment{
+			Color:     a.Color,
+			Fallback:  a.FallbackText(),
+			Pretext:   a.Pretext,
+			Title:     a.Title,
+			TitleLink: a.TitleLink,
+			Text:      a.Text,
+			Footer:    a.Footer,
+		}
+		for _, field := range a.Fields {
+			attachment.Fields = append(attachment.Fields, slack.AttachmentField{
+				Title: field.Title,
+				Value: field.Value,
+				Short: field.Short,
+			})
+		}
+		attachments, _ := json.Marshal([]slack.Attachment{attachment})
+		values.Set("attachments", string(attachments))
+	}
+	if err := webAPI("chat.postMessage", s.token, values, nil); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Sending attachment to channel '%s': %v", chanID, err))
+		return bot.FailedMessageSend
+	}
+	return bot.Ok
+}
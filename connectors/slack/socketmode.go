@@ -0,0 +1,276 @@
+package slack
+
+/* socketmode.go - support for Slack's Socket Mode, which delivers Events API
+events over a websocket opened with an app-level token. Newer Slack apps can't
+use RTM, so when an AppToken is configured the connector uses Socket Mode for
+events and the Web API for everything else.
+*/
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/bot"
+	"github.com/nlopes/slack"
+)
+
+const apiTimeout = 30 * time.Second       // for Web API calls and the websocket handshake
+const maxReconnectDelay = 2 * time.Minute // reconnect backoff doubles up to this
+
+// socketEnvelope wraps every event sent over the Socket Mode websocket
+type socketEnvelope struct {
+	Type       string `json:"type"`
+	EnvelopeID string `json:"envelope_id"`
+	Reason     string `json:"reason"`
+	Payload    struct {
+		Event json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+// eventHeader has the fields common to all Events API events
+type eventHeader struct {
+	Type        string `json:"type"`
+	ChannelType string `json:"channel_type"`
+}
+
+// webAPI calls a Web API method that the slack library doesn't provide,
+// returning an error if the call fails or the response isn't ok.
+func webAPI(method, token string, values url.Values, result interface{}) error {
+	if values == nil {
+		values = url.Values{}
+	}
+	values.Set("token", token)
+	client := &http.Client{Timeout: apiTimeout}
+	resp, err := client.PostForm(slack.SLACK_API+method, values)
+	if err != nil {
+		return err
+	}
+	return apiResult(method, resp, result)
+}
+
+// apiResult checks the response from a Web API call, decoding it in to result
+// if it isn't nil.
+func apiResult(method string, resp *http.Response, result interface{}) error {
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", method, resp.Status)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return err
+	}
+	var status struct {
+		Ok    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &status); err != nil {
+		return err
+	}
+	if !status.Ok {
+		return errors.New(status.Error)
+	}
+	if result != nil {
+		return json.Unmarshal(raw, result)
+	}
+	return nil
+}
+
+// getConversations retrieves the channels and IMs the robot can see with
+// conversations.list, which replaces the channel, group and IM list methods
+// for newer apps.
+func (s *slackConnector) getConversations() (chanlist []slack.Channel, imlist []slack.IM, err error) {
+	for _, types := range []string{"public_channel,private_channel", "im"} {
+		cursor := ""
+		for {
+			var page struct {
+				Channels json.RawMessage `json:"channels"`
+				Metadata struct {
+					NextCursor string `json:"next_cursor"`
+				} `json:"response_metadata"`
+			}
+			values := url.Values{
+				"types":            {types},
+				"exclude_archived": {"true"},
+				"limit":            {"200"},
+			}
+			if len(cursor) > 0 {
+				values.Set("cursor", cursor)
+			}
+			if err = webAPI("conversations.list", s.token, values, &page); err != nil {
+				return
+			}
+			if types == "im" {
+				var ims []slack.IM
+				if err = json.Unmarshal(page.Channels, &ims); err != nil {
+					return
+				}
+				imlist = append(imlist, ims...)
+			} else {
+				var channels []slack.Channel
+				if err = json.Unmarshal(page.Channels, &channels); err != nil {
+					return
+				}
+				chanlist = append(chanlist, channels...)
+			}
+			cursor = page.Metadata.NextCursor
+			if len(cursor) == 0 {
+				break
+			}
+		}
+	}
+	return
+}
+
+// openSocket asks Slack for a Socket Mode URL, connects the websocket and
+// waits for the hello event.
+func (s *slackConnector) openSocket() error {
+	var conn struct {
+		URL string `json:"url"`
+	}
+	if err := webAPI("apps.connections.open", s.appToken, nil, &conn); err != nil {
+		return fmt.Errorf("opening connection: %v", err)
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: apiTimeout}
+	ws, _, err := dialer.Dial(conn.URL, nil)
+	if err != nil {
+		return err
+	}
+	ws.SetReadDeadline(time.Now().Add(apiTimeout))
+	var hello socketEnvelope
+	if err := ws.ReadJSON(&hello); err != nil {
+		ws.Close()
+		return fmt.Errorf("waiting for hello: %v", err)
+	}
+	if hello.Type != "hello" {
+		ws.Close()
+		return fmt.Errorf("expected hello from Slack, got '%s'", hello.Type)
+	}
+	ws.SetReadDeadline(time.Time{})
+	disconnected := make(chan error, 1)
+	s.Lock()
+	s.socket = ws
+	s.disconnected = disconnected
+	s.Unlock()
+	go s.socketReadLoop(ws, disconnected)
+	s.Log(bot.Info, "Connected to Slack in Socket Mode as", s.botName)
+	return nil
+}
+
+// reconnect tries to open a new socket until it succeeds or the robot is
+// stopped, returning false if stopped.
+func (s *slackConnector) reconnect(stop <-chan struct{}) bool {
+	delay := time.Second
+	for {
+		select {
+		case <-stop:
+			return false
+		case <-time.After(delay):
+		}
+		err := s.openSocket()
+		if err == nil {
+			return true
+		}
+		s.Log(bot.Error, fmt.Sprintf("Reconnecting to Slack in Socket Mode: %v", err))
+		delay *= 2
+		if delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// socketReadLoop reads envelopes from the websocket until it fails,
+// acknowledging each one as it arrives; Slack re-sends events that aren't
+// acknowledged within a few seconds.
+func (s *slackConnector) socketReadLoop(ws *websocket.Conn, disconnected chan<- error) {
+	for {
+		var env socketEnvelope
+		if err := ws.ReadJSON(&env); err != nil {
+			disconnected <- err
+			return
+		}
+		if len(env.EnvelopeID) > 0 {
+			ws.SetWriteDeadline(time.Now().Add(apiTimeout))
+			if err := ws.WriteJSON(map[string]string{"envelope_id": env.EnvelopeID}); err != nil {
+				s.Log(bot.Error, fmt.Sprintf("Acknowledging Socket Mode envelope: %v", err))
+			}
+		}
+		s.socketEvents <- &env
+	}
+}
+
+// handleSocketEvent handles a single Socket Mode envelope
+func (s *slackConnector) handleSocketEvent(env *socketEnvelope) {
+	s.Log(bot.Trace, fmt.Sprintf("Socket Mode envelope received (type, event): %s; %s", env.Type, env.Payload.Event))
+	switch env.Type {
+	case "events_api":
+	case "disconnect":
+		// Slack is about to close the connection; closing it ourselves
+		// triggers a reconnect.
+		s.Log(bot.Debug, "Received disconnect from Slack, reason:", env.Reason)
+		s.RLock()
+		ws := s.socket
+		s.RUnlock()
+		ws.Close()
+		return
+	default:
+		// Ignore interactive and slash command envelopes
+		return
+	}
+	var header eventHeader
+	if err := json.Unmarshal(env.Payload.Event, &header); err != nil {
+		s.Log(bot.Error, fmt.Sprintf("Decoding Slack event: %v", err))
+		return
+	}
+	switch header.Type {
+	case "message":
+		var msg slack.MessageEvent
+		if err := json.Unmarshal(env.Payload.Event, &msg); err != nil {
+			s.Log(bot.Error, fmt.Sprintf("Decoding Slack message event: %v", err))
+			return
+		}
+		if header.ChannelType == "im" {
+			if _, ok := s.imUser(msg.Channel); !ok {
+				// A new IM; update the maps so processMessage can find the user
+				s.updateMaps()
+			}
+		}
+		// Message processing is done concurrently
+		go s.processMessage(&msg)
+	case "channel_archive", "channel_created", "channel_deleted", "channel_rename", "group_rename", "member_joined_channel", "team_join", "user_change", "im_created":
+		s.updateMaps()
+	}
+}
+
+// runSocketMode is the Run loop for Socket Mode
+func (s *slackConnector) runSocketMode(stop <-chan struct{}) {
+	for {
+		s.RLock()
+		disconnected := s.disconnected
+		s.RUnlock()
+		select {
+		case <-stop:
+			s.Log(bot.Debug, "Received stop in connector")
+			s.RLock()
+			s.socket.Close()
+			s.RUnlock()
+			return
+		case env := <-s.socketEvents:
+			s.handleSocketEvent(env)
+		case err := <-disconnected:
+			s.Log(bot.Warn, fmt.Sprintf("Lost Socket Mode connection to Slack: %v", err))
+			if !s.reconnect(stop) {
+				return
+			}
+		}
+	}
+}
+
+// socketMode reports whether the connector is using Socket Mode
+func (s *slackConnector) socketMode() bool {
+	return len(s.appToken) > 0
+}
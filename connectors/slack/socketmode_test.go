@@ -0,0 +1,415 @@
+package slack
+
+/* socketmode_test.go - tests Socket Mode against a minimal Slack stand-in
+built on httptest, which serves the Web API methods the connector uses and the
+Socket Mode websocket, recording messages and acknowledgements from the robot.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/lnxjedi/gopherbot/bot"
+	"github.com/nlopes/slack"
+)
+
+const (
+	testToken    = "xoxb-testtoken"
+	testAppToken = "xapp-testtoken"
+)
+
+type heardMessage struct {
+	channel, user, message string
+	thread                 string // set for messages in a thread
+}
+
+// testHandler stands in for the robot
+type testHandler struct {
+	config string
+	heard  chan heardMessage
+}
+
+func (h *testHandler) IncomingMessage(channel, user, message string, raw interface{}) {
+	h.heard <- heardMessage{channel, user, message, ""}
+}
+func (h *testHandler) IncomingThreadMessage(channel, thread string, threaded bool, user, message string, raw interface{}) {
+	if !threaded {
+		thread = ""
+	}
+	h.heard <- heardMessage{channel, user, message, thread}
+}
+func (h *testHandler) GetProtocolConfig(v interface{}) error {
+	return json.Unmarshal([]byte(h.config), v)
+}
+func (h *testHandler) GetBrainConfig(v interface{}) error   { return nil }
+func (h *testHandler) GetHistoryConfig(v interface{}) error { return nil }
+func (h *testHandler) SetFullName(n string)                 {}
+func (h *testHandler) SetName(n string)                     {}
+func (h *testHandler) GetLogLevel() bot.LogLevel            { return bot.Debug }
+func (h *testHandler) GetLogToFile() bool                   { return false }
+func (h *testHandler) GetInstallPath() string               { return "" }
+func (h *testHandler) GetConfigPath() string                { return "" }
+func (h *testHandler) Log(l bot.LogLevel, v ...interface{}) {}
+
+// postedMessage is a chat.postMessage call from the robot
+type postedMessage struct {
+	channel, text, thread string
+}
+
+// uploadedFile is a files.upload call from the robot
+type uploadedFile struct {
+	channel, name, comment, thread, content string
+}
+
+// testServer is the Slack stand-in
+type testServer struct {
+	*httptest.Server
+	posts       chan postedMessage
+	uploads     chan uploadedFile
+	attachments chan string        // attachments or blocks JSON posted with chat.postMessage
+	edits       chan postedMessage // chat.update calls, with the timestamp as the thread
+	reacts      chan string        // "channel ts name" for reactions.add calls
+	acks        chan string
+	joins       chan string
+	conns       chan *websocket.Conn
+	ims         []map[string]interface{}
+	t           *testing.T
+	sync.Mutex
+}
+
+func newTestServer(t *testing.T) *testServer {
+	s := &testServer{
+		posts:       make(chan postedMessage, 8),
+		uploads:     make(chan uploadedFile, 2),
+		attachments: make(chan string, 2),
+		edits:       make(chan postedMessage, 2),
+		reacts:      make(chan string, 2),
+		acks:        make(chan string, 8),
+		joins:       make(chan string, 8),
+		conns:       make(chan *websocket.Conn, 2),
+		ims: []map[string]interface{}{
+			{"id": "DALICE001", "is_im": true, "user": "UALICE001"},
+		},
+		t: t,
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+func (s *testServer) reply(w http.ResponseWriter, v map[string]interface{}) {
+	v["ok"] = true
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/socket" {
+		ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			s.t.Errorf("Upgrading websocket: %v", err)
+			return
+		}
+		ws.WriteJSON(map[string]interface{}{"type": "hello", "num_connections": 1})
+		go func() {
+			for {
+				var ack map[string]string
+				if err := ws.ReadJSON(&ack); err != nil {
+					return
+				}
+				s.acks <- ack["envelope_id"]
+			}
+		}()
+		s.conns <- ws
+		return
+	}
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+		r.ParseMultipartForm(1 << 20)
+	} else {
+		r.ParseForm()
+	}
+	method := strings.TrimPrefix(r.URL.Path, "/api/")
+	token := testToken
+	if method == "apps.connections.open" {
+		token = testAppToken
+	}
+	if r.Form.Get("token") != token {
+		s.reply(w, map[string]interface{}{"ok": false, "error": "invalid_auth"})
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	switch method {
+	case "auth.test":
+		s.reply(w, map[string]interface{}{"user": "floyd", "user_id": "UFLOYD001"})
+	case "users.list":
+		s.reply(w, map[string]interface{}{"members": []map[string]interface{}{
+			{"id": "UFLOYD001", "name": "floyd", "real_name": "Floyd Gopherbot"},
+			{"id": "UALICE001", "name": "alice", "real_name": "Alice User", "profile": map[string]string{"email": "alice@example.com"}},
+			{"id": "UBOB00001", "name": "bob"},
+		}})
+	case "conversations.list":
+		if r.Form.Get("types") == "im" {
+			s.reply(w, map[string]interface{}{"channels": s.ims})
+			return
+		}
+		// Return channels a page at a time
+		if r.Form.Get("cursor") == "" {
+			s.reply(w, map[string]interface{}{
+				"channels":          []map[string]interface{}{{"id": "CGENERAL1", "name": "general", "is_channel": true}},
+				"response_metadata": map[string]string{"next_cursor": "page2"},
+			})
+			return
+		}
+		s.reply(w, map[string]interface{}{
+			"channels": []map[string]interface{}{{"id": "GPRIVATE1", "name": "private", "is_private": true}},
+		})
+	case "apps.connections.open":
+		s.reply(w, map[string]interface{}{"url": "ws" + strings.TrimPrefix(s.URL, "http") + "/socket"})
+	case "chat.postMessage":
+		if a := r.Form.Get("attachments") + r.Form.Get("blocks"); len(a) > 0 {
+			s.attachments <- a
+		}
+		s.posts <- postedMessage{r.Form.Get("channel"), r.Form.Get("text"), r.Form.Get("thread_ts")}
+		s.reply(w, map[string]interface{}{"channel": r.Form.Get("channel"), "ts": "1500000000.000100"})
+	case "chat.update":
+		s.edits <- postedMessage{r.Form.Get("channel"), r.Form.Get("text"), r.Form.Get("ts")}
+		s.reply(w, map[string]interface{}{"channel": r.Form.Get("channel"), "ts": r.Form.Get("ts")})
+	case "reactions.add":
+		s.reacts <- strings.Join([]string{r.Form.Get("channel"), r.Form.Get("timestamp"), r.Form.Get("name")}, " ")
+		s.reply(w, map[string]interface{}{})
+	case "files.upload":
+		var content []byte
+		if f, _, err := r.FormFile("file"); err == nil {
+			content, _ = ioutil.ReadAll(f)
+			f.Close()
+		}
+		s.uploads <- uploadedFile{r.Form.Get("channels"), r.Form.Get("filename"), r.Form.Get("initial_comment"), r.Form.Get("thread_ts"), string(content)}
+		s.reply(w, map[string]interface{}{"file": map[string]string{"id": "F00000001"}})
+	case "conversations.open":
+		id := "D" + strings.TrimPrefix(r.Form.Get("users"), "U")
+		s.ims = append(s.ims, map[string]interface{}{"id": id, "is_im": true, "user": r.Form.Get("users")})
+		s.reply(w, map[string]interface{}{"channel": map[string]string{"id": id}})
+	case "conversations.join":
+		s.joins <- r.Form.Get("channel")
+		s.reply(w, map[string]interface{}{"channel": map[string]string{"id": r.Form.Get("channel")}})
+	default:
+		s.reply(w, map[string]interface{}{"ok": false, "error": "unknown_method"})
+	}
+}
+
+// sendEvent sends an Events API envelope to the robot and checks that it's
+// acknowledged
+func (s *testServer) sendEvent(ws *websocket.Conn, id string, event map[string]interface{}) {
+	env := map[string]interface{}{
+		"envelope_id": id,
+		"type":        "events_api",
+		"payload":     map[string]interface{}{"type": "event_callback", "event": event},
+	}
+	if err := ws.WriteJSON(env); err != nil {
+		s.t.Fatalf("Sending event '%s': %v", id, err)
+	}
+	select {
+	case ack := <-s.acks:
+		if ack != id {
+			s.t.Errorf("Acknowledgement from robot; want %s, got %s", id, ack)
+		}
+	case <-time.After(2 * time.Second):
+		s.t.Errorf("Timed out waiting for acknowledgement of %s", id)
+	}
+}
+
+func (s *testServer) conn() *websocket.Conn {
+	select {
+	case ws := <-s.conns:
+		return ws
+	case <-time.After(5 * time.Second):
+		s.t.Fatal("Timed out waiting for websocket connection")
+	}
+	return nil
+}
+
+func (s *testServer) expectPost(want postedMessage) {
+	select {
+	case got := <-s.posts:
+		if got != want {
+			s.t.Errorf("Message from robot; want %+v, got %+v", want, got)
+		}
+	case <-time.After(3 * time.Second):
+		s.t.Errorf("Timed out waiting for message from robot: %+v", want)
+	}
+}
+
+func (s *testServer) expectHeard(h *testHandler, want heardMessage) {
+	select {
+	case got := <-h.heard:
+		if got != want {
+			s.t.Errorf("Message to robot; want %+v, got %+v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		s.t.Errorf("Timed out waiting for message to robot: %+v", want)
+	}
+}
+
+func TestSocketMode(t *testing.T) {
+	s := newTestServer(t)
+	defer s.Close()
+	saved := slack.SLACK_API
+	slack.SLACK_API = s.URL + "/api/"
+	defer func() { slack.SLACK_API = saved }()
+	h := &testHandler{
+		config: fmt.Sprintf(`{"SlackToken": "%s", "AppToken": "%s"}`, testToken, testAppToken),
+		heard:  make(chan heardMessage, 4),
+	}
+
+	c := Initialize(h, nil)
+	ws := s.conn()
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		c.Run(stop)
+		close(stopped)
+	}()
+
+	if email, ret := c.GetProtocolUserAttribute("alice", "email"); ret != bot.Ok || email != "alice@example.com" {
+		t.Errorf("GetProtocolUserAttribute email for alice; want alice@example.com, got '%s' (%s)", email, ret)
+	}
+
+	// Mentions of users are rewritten to @name
+	s.sendEvent(ws, "env1", map[string]interface{}{
+		"type": "message", "channel": "CGENERAL1", "channel_type": "channel",
+		"user": "UALICE001", "text": "<@UFLOYD001> ping", "ts": "1500000000.000001",
+	})
+	s.expectHeard(h, heardMessage{"general", "alice", "@floyd ping", ""})
+	// Edits arriving right after the original message are ignored
+	s.sendEvent(ws, "env2", map[string]interface{}{
+		"type": "message", "subtype": "message_changed", "channel": "CGENERAL1", "channel_type": "channel",
+		"message": map[string]string{"user": "UALICE001", "text": "<@UFLOYD001> ping", "ts": "1500000000.000001"},
+		"ts":      "1500000000.000002",
+	})
+	// Channels from the second page of conversations.list are mapped
+	s.sendEvent(ws, "env3", map[string]interface{}{
+		"type": "message", "channel": "GPRIVATE1", "channel_type": "group",
+		"user": "UBOB00001", "text": "anybody here?", "ts": "1500000000.000003",
+	})
+	s.expectHeard(h, heardMessage{"private", "bob", "anybody here?", ""})
+	s.sendEvent(ws, "env4", map[string]interface{}{
+		"type": "message", "channel": "DALICE001", "channel_type": "im",
+		"user": "UALICE001", "text": "hello there", "ts": "1500000000.000004",
+	})
+	s.expectHeard(h, heardMessage{"", "alice", "hello there", ""})
+
+	c.SendProtocolChannelMessage("general", "hello everybody", bot.Raw)
+	s.expectPost(postedMessage{"CGENERAL1", "hello everybody", ""})
+	// Messages in a thread carry the thread timestamp, and replies can go
+	// to the thread
+	s.sendEvent(ws, "env5", map[string]interface{}{
+		"type": "message", "channel": "CGENERAL1", "channel_type": "channel",
+		"user": "UALICE001", "text": "floyd, ping", "ts": "1500000000.000006", "thread_ts": "1500000000.000005",
+	})
+	s.expectHeard(h, heardMessage{"general", "alice", "floyd, ping", "1500000000.000005"})
+	c.SendProtocolUserChannelThreadMessage("alice", "general", "1500000000.000005", "pong", bot.Raw)
+	s.expectPost(postedMessage{"CGENERAL1", "<@UALICE001>: pong", "1500000000.000005"})
+	c.SendProtocolUserChannelMessage("alice", "general", "pong", bot.Raw)
+	s.expectPost(postedMessage{"CGENERAL1", "<@UALICE001>: pong", ""})
+	// bob has no IM channel yet
+	c.SendProtocolUserMessage("bob", "hi bob", bot.Raw)
+	s.expectPost(postedMessage{"DBOB00001", "hi bob", ""})
+
+	if ret := c.JoinChannel("general"); ret != bot.Ok {
+		t.Errorf("JoinChannel; want Ok, got %s", ret)
+	}
+	select {
+	case id := <-s.joins:
+		if id != "CGENERAL1" {
+			t.Errorf("JoinChannel; want CGENERAL1, got %s", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for channel join")
+	}
+
+	// Files are uploaded to the channel or thread
+	if ret := c.(bot.FileSender).SendProtocolFile("alice", "general", "1500000000.000005", &bot.File{Name: "report.txt", Comment: "the report", Content: []byte("all good")}); ret != bot.Ok {
+		t.Errorf("SendProtocolFile; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.uploads:
+		want := uploadedFile{"CGENERAL1", "report.txt", "the report", "1500000000.000005", "all good"}
+		if got != want {
+			t.Errorf("File upload from robot; want %+v, got %+v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for file upload")
+	}
+	// Attachments go to the user by DM when there's no channel
+	attachment := &bot.Attachment{Title: "Build", Text: "passed", Color: "good", Fields: []bot.AttachmentField{{Title: "Branch", Value: "master", Short: true}}}
+	if ret := c.(bot.AttachmentSender).SendProtocolAttachment("alice", "", "", attachment); ret != bot.Ok {
+		t.Errorf("SendProtocolAttachment; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.attachments:
+		var posted []slack.Attachment
+		json.Unmarshal([]byte(got), &posted)
+		if len(posted) != 1 || posted[0].Title != "Build" || posted[0].Color != "good" || len(posted[0].Fields) != 1 || posted[0].Fields[0].Value != "master" || posted[0].Fallback != "Build\npassed\nBranch: master" {
+			t.Errorf("Attachment from robot; got %s", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for attachment")
+	}
+	s.expectPost(postedMessage{"DALICE001", "", ""})
+
+	// Editable messages are identified by channel and timestamp
+	editor := c.(bot.MessageEditor)
+	id, ret := editor.SendProtocolEditableMessage("alice", "general", "", "Starting", bot.Raw)
+	if ret != bot.Ok || id != "CGENERAL1:1500000000.000100" {
+		t.Errorf("SendProtocolEditableMessage; want Ok and CGENERAL1:1500000000.000100, got %s and '%s'", ret, id)
+	}
+	s.expectPost(postedMessage{"CGENERAL1", "Starting", ""})
+	if ret := editor.UpdateProtocolMessage(id, "Finished", bot.Raw); ret != bot.Ok {
+		t.Errorf("UpdateProtocolMessage; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.edits:
+		if want := (postedMessage{"CGENERAL1", "Finished", "1500000000.000100"}); got != want {
+			t.Errorf("Message update from robot; want %+v, got %+v", want, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for message update")
+	}
+	// Reactions go on the message the robot heard
+	raw := &slack.MessageEvent{Msg: slack.Msg{Channel: "CGENERAL1", Timestamp: "1500000000.000006"}}
+	if ret := c.(bot.Reactor).AddProtocolReaction(raw, "thumbsup"); ret != bot.Ok {
+		t.Errorf("AddProtocolReaction; want Ok, got %s", ret)
+	}
+	select {
+	case got := <-s.reacts:
+		if got != "CGENERAL1 1500000000.000006 thumbsup" {
+			t.Errorf("Reaction from robot; want 'CGENERAL1 1500000000.000006 thumbsup', got '%s'", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for reaction")
+	}
+
+	// When Slack asks the robot to disconnect, it reconnects
+	ws.WriteJSON(map[string]string{"type": "disconnect", "reason": "refresh_requested"})
+	ws = s.conn()
+	s.sendEvent(ws, "env6", map[string]interface{}{
+		"type": "message", "channel": "CGENERAL1", "channel_type": "channel",
+		"user": "UBOB00001", "text": "welcome back", "ts": "1500000000.000007",
+	})
+	s.expectHeard(h, heardMessage{"general", "bob", "welcome back", ""})
+
+	close(stop)
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Error("Timed out waiting for connector to stop")
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/gorilla/websocket"
 	"github.com/lnxjedi/gopherbot/bot"
 	"github.com/nlopes/slack"
 )
@@ -20,7 +21,12 @@ const optimeout = 1 * time.Minute
 // slackConnector holds all the relevant data about a connection
 type slackConnector struct {
 	api             *slack.Client
-	conn            *slack.RTM
+	conn            *slack.RTM            // RTM connection, nil in Socket Mode
+	token           string                // bot token for the Web API
+	appToken        string                // app-level token, set for Socket Mode
+	socket          *websocket.Conn       // the current Socket Mode websocket
+	socketEvents    chan *socketEnvelope  // events from the Socket Mode websocket
+	disconnected    chan error            // receives the error when the Socket Mode websocket drops
 	maxMessageSplit int                   // The maximum # of ~4000 byte messages to send before truncating
 	running         bool                  // set on call to Run
 	botName         string                // human-readable name of bot
@@ -110,6 +116,21 @@ func (s *slackConnector) imUser(c string) (u string, ok bool) {
 	return u, ok
 }
 
+// openIM opens (or finds) the IM channel for a user
+func (s *slackConnector) openIM(userID string) (string, error) {
+	channel, _, _, err := s.api.OpenConversation(&slack.OpenConversationParameters{
+		Users: []string{userID},
+	})
+	if err != nil {
+		return "", err
+	}
+	s.Lock()
+	s.userIDToIM[userID] = channel.ID
+	s.imToUser[channel.ID] = s.idToUser[userID]
+	s.Unlock()
+	return channel.ID, nil
+}
+
 func optQuote(msg string, f bot.MessageFormat) string {
 	if f == bot.Fixed {
 		return "```" + msg + "```"
@@ -221,6 +242,12 @@ func (s *slackConnector) processMessage(msg *slack.MessageEvent) {
 		lastmsgtime.m[lastlookup] = timestamp
 		lastmsgtime.Unlock()
 	}
+	// Replies to a message not in a thread start a thread from the message
+	threadID := message.ThreadTimestamp
+	threaded := len(threadID) > 0
+	if !threaded {
+		threadID = message.Timestamp
+	}
 	text := message.Text
 	// some bot messages don't have any text, so check for a fallback
 	if text == "" && len(msg.Attachments) > 0 {
@@ -268,10 +295,10 @@ func (s *slackConnector) processMessage(msg *slack.MessageEvent) {
 		channelName, ok := s.channelName(chanID)
 		if !ok {
 			s.Log(bot.Warn, "Coudln't find channel name for ID", chanID)
-			s.IncomingMessage(chanID, userName, text, msg)
+			s.IncomingThreadMessage(chanID, threadID, threaded, userName, text, msg)
 			return
 		}
-		s.IncomingMessage(channelName, userName, text, msg)
+		s.IncomingThreadMessage(channelName, threadID, threaded, userName, text, msg)
 	}
 }
 
@@ -312,14 +339,45 @@ func (s *slackConnector) updateMaps() {
 		userIDMap[botID] = botName
 	}
 
-	for tries := uint(0); time.Now().Before(deadline); tries++ {
-		userIMlist, err = s.api.GetIMChannels()
-		if err == nil {
-			break
+	if s.socketMode() {
+		// Newer apps can only use the conversations API
+		for tries := uint(0); time.Now().Before(deadline); tries++ {
+			chanlist, userIMlist, err = s.getConversations()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			s.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating conversations: %v\n", err))
+		}
+	} else {
+		for tries := uint(0); time.Now().Before(deadline); tries++ {
+			userIMlist, err = s.api.GetIMChannels()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			s.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating IMchannels: %v\n", err))
+		}
+		for tries := uint(0); time.Now().Before(deadline); tries++ {
+			chanlist, err = s.api.GetChannels(true)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			s.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating channels: %v\n", err))
+		}
+		for tries := uint(0); time.Now().Before(deadline); tries++ {
+			grouplist, err = s.api.GetGroups(true)
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			s.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating groups: %v\n", err))
 		}
-	}
-	if err != nil {
-		s.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating IMchannels: %v\n", err))
 	}
 	userIMMap := make(map[string]string)
 	userNameMap := make(map[string]string)
@@ -330,24 +388,6 @@ func (s *slackConnector) updateMaps() {
 		userNameMap[userIM.ID] = userIDMap[userIM.User]
 	}
 
-	for tries := uint(0); time.Now().Before(deadline); tries++ {
-		chanlist, err = s.api.GetChannels(true)
-		if err == nil {
-			break
-		}
-	}
-	if err != nil {
-		s.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating channels: %v\n", err))
-	}
-	for tries := uint(0); time.Now().Before(deadline); tries++ {
-		grouplist, err = s.api.GetGroups(true)
-		if err == nil {
-			break
-		}
-	}
-	if err != nil {
-		s.Log(bot.Fatal, fmt.Sprintf("Protocol timeout updating groups: %v\n", err))
-	}
 	chanMap := make(map[string]string)
 	chanIDMap := make(map[string]string)
 	for _, channel := range chanlist {
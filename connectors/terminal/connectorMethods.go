@@ -48,6 +48,18 @@ func (tc *termConnector) SendProtocolUserChannelMessage(u, ch, msg string, f bot
 	return tc.sendMessage(ch, msg, f)
 }
 
+// SendProtocolChannelThreadMessage sends a message to a channel; the
+// terminal doesn't have threads
+func (tc *termConnector) SendProtocolChannelThreadMessage(ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return tc.SendProtocolChannelMessage(ch, msg, f)
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a channel,
+// mentioning the user; the terminal doesn't have threads
+func (tc *termConnector) SendProtocolUserChannelThreadMessage(u, ch, thr, msg string, f bot.MessageFormat) (ret bot.RetVal) {
+	return tc.SendProtocolUserChannelMessage(u, ch, msg, f)
+}
+
 // SendProtocolUserMessage sends a direct message to a user
 func (tc *termConnector) SendProtocolUserMessage(u string, msg string, f bot.MessageFormat) (ret bot.RetVal) {
 	return tc.sendMessage(fmt.Sprintf("(dm:%s)", u), msg, f)
@@ -16,18 +16,26 @@ type TestMessage struct {
 	User, Channel, Message string
 }
 
+// ThreadMessage is a TestMessage in a thread. For messages sent to the robot
+// with Threaded false, Thread is the ID of a thread started from the message.
+type ThreadMessage struct {
+	TestMessage
+	Thread   string
+	Threaded bool
+}
+
 // TestConnector holds all the relevant data about a connection
 type TestConnector struct {
-	botName      string            // human-readable name of bot
-	botFullName  string            // human-readble full name of the bot
-	botID        string            // slack internal bot ID
-	users        []testUser        // configured users
-	channels     []string          // the channels the robot is in
-	listener     chan *TestMessage // input channel for test functions to send messages from a user
-	speaking     chan *TestMessage // output channel for test functions to get messages from the bot
-	test         *testing.T        // for the connector to log
-	bot.Handler                    // bot API for connectors
-	sync.RWMutex                   // shared mutex for locking connector data structures
+	botName      string              // human-readable name of bot
+	botFullName  string              // human-readble full name of the bot
+	botID        string              // slack internal bot ID
+	users        []testUser          // configured users
+	channels     []string            // the channels the robot is in
+	listener     chan *ThreadMessage // input channel for test functions to send messages from a user
+	speaking     chan *ThreadMessage // output channel for test functions to get messages from the bot
+	test         *testing.T          // for the connector to log
+	bot.Handler                      // bot API for connectors
+	sync.RWMutex                     // shared mutex for locking connector data structures
 }
 
 func (tc *TestConnector) Run(stop <-chan struct{}) {
@@ -40,7 +48,11 @@ loop:
 			tc.test.Log("Received stop in connector")
 			break loop
 		case msg := <-tc.listener:
-			tc.IncomingMessage(msg.Channel, msg.User, msg.Message, msg)
+			if len(msg.Thread) == 0 {
+				tc.IncomingMessage(msg.Channel, msg.User, msg.Message, &msg.TestMessage)
+			} else {
+				tc.IncomingThreadMessage(msg.Channel, msg.Thread, msg.Threaded, msg.User, msg.Message, &msg.TestMessage)
+			}
 		}
 	}
 }
@@ -81,9 +93,13 @@ func (tc *TestConnector) sendMessage(msg *BotMessage) (ret bot.RetVal) {
 			return bot.UserNotFound
 		}
 	}
-	spoken := &TestMessage{
-		User:    msg.User,
-		Channel: msg.Channel,
+	spoken := &ThreadMessage{
+		TestMessage: TestMessage{
+			User:    msg.User,
+			Channel: msg.Channel,
+		},
+		Thread:   msg.Thread,
+		Threaded: len(msg.Thread) > 0,
 	}
 	switch msg.Format {
 	case bot.Fixed:
@@ -7,6 +7,7 @@ import (
 // BotMessage is for receiving messages from the robot
 type BotMessage struct {
 	User, Channel, Message string
+	Thread                 string // for messages sent to a thread
 	Format                 bot.MessageFormat
 }
 
@@ -63,6 +64,31 @@ func (tc *TestConnector) SendProtocolUserChannelMessage(u, ch, mesg string, f bo
 	return tc.sendMessage(msg)
 }
 
+// SendProtocolChannelThreadMessage sends a message to a thread in a channel
+func (tc *TestConnector) SendProtocolChannelThreadMessage(ch, thr, mesg string, f bot.MessageFormat) (ret bot.RetVal) {
+	msg := &BotMessage{
+		User:    "",
+		Channel: ch,
+		Thread:  thr,
+		Message: mesg,
+		Format:  f,
+	}
+	return tc.sendMessage(msg)
+}
+
+// SendProtocolUserChannelThreadMessage sends a message to a user in a thread
+// in a channel
+func (tc *TestConnector) SendProtocolUserChannelThreadMessage(u, ch, thr, mesg string, f bot.MessageFormat) (ret bot.RetVal) {
+	msg := &BotMessage{
+		User:    u,
+		Channel: ch,
+		Thread:  thr,
+		Message: mesg,
+		Format:  f,
+	}
+	return tc.sendMessage(msg)
+}
+
 // SendProtocolUserMessage sends a direct message to a user
 func (tc *TestConnector) SendProtocolUserMessage(u string, mesg string, f bot.MessageFormat) (ret bot.RetVal) {
 	msg := &BotMessage{
@@ -46,8 +46,8 @@ func Initialize(robot bot.Handler, l *log.Logger) bot.Connector {
 		botID:       "deadbeef", // yes - hex in a string
 		users:       c.Users,
 		channels:    c.Channels,
-		listener:    make(chan *TestMessage),
-		speaking:    make(chan *TestMessage),
+		listener:    make(chan *ThreadMessage),
+		speaking:    make(chan *ThreadMessage),
 	}
 
 	tc.Handler = robot
@@ -16,6 +16,11 @@ func (tc *TestConnector) SetTest(t *testing.T) {
 
 // SendBotMessage, for tests to send messages to the 'bot
 func (tc *TestConnector) SendBotMessage(msg *TestMessage) {
+	tc.SendBotThreadMessage(&ThreadMessage{TestMessage: *msg})
+}
+
+// SendBotThreadMessage, for tests to send messages to the 'bot in a thread
+func (tc *TestConnector) SendBotThreadMessage(msg *ThreadMessage) {
 	tc.RLock()
 	if msg.Channel != "" {
 		exists := false
@@ -53,13 +58,22 @@ func (tc *TestConnector) SendBotMessage(msg *TestMessage) {
 
 // GetBotMessage, for tests to get replies
 func (tc *TestConnector) GetBotMessage() (*TestMessage, error) {
+	incoming, err := tc.GetBotThreadMessage()
+	if err != nil {
+		return nil, err
+	}
+	return &incoming.TestMessage, nil
+}
+
+// GetBotThreadMessage, for tests to get replies that may be in a thread
+func (tc *TestConnector) GetBotThreadMessage() (*ThreadMessage, error) {
 	select {
 	case incoming := <-tc.speaking:
 		message := incoming.Message
 		if len(incoming.Message) > 16 {
 			message = incoming.Message[0:16] + " ..."
 		}
-		tc.test.Logf("Reply received from robot: u:%s, c:%s, m:%s", incoming.User, incoming.Channel, message)
+		tc.test.Logf("Reply received from robot: u:%s, c:%s, t:%s, m:%s", incoming.User, incoming.Channel, incoming.Thread, message)
 		return incoming, nil
 	case <-time.After(4 * time.Second):
 		return nil, errors.New("Timeout waiting for reply from robot")
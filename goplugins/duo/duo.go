@@ -403,18 +403,23 @@ func duocommands(r *bot.Robot, command string, args ...string) (retval bot.TaskR
 	auth = authapi.NewAuthApi(*duo)
 	var duouser string
 
-	switch cfg.DuoUserString {
-	case "handle":
-		duouser = r.User
-	case "email":
-		duouser = r.GetSenderAttribute("email").Attribute
-	case "emailUser", "emailuser":
-		mailattr := r.GetSenderAttribute("email")
-		email := mailattr.Attribute
-		duouser = strings.Split(email, "@")[0]
-	default:
-		r.Log(bot.Error, "No DuoUserString configured for Duo elevator plugin")
-		return bot.ConfigurationError
+	// A DuoUser in the UserRoster takes precedence over DuoUserString
+	if rosterattr := r.GetSenderAttribute("duoUser"); rosterattr.RetVal == bot.Ok && len(rosterattr.Attribute) > 0 {
+		duouser = rosterattr.Attribute
+	} else {
+		switch cfg.DuoUserString {
+		case "handle":
+			duouser = r.User
+		case "email":
+			duouser = r.GetSenderAttribute("email").Attribute
+		case "emailUser", "emailuser":
+			mailattr := r.GetSenderAttribute("email")
+			email := mailattr.Attribute
+			duouser = strings.Split(email, "@")[0]
+		default:
+			r.Log(bot.Error, "No DuoUserString configured for Duo elevator plugin")
+			return bot.ConfigurationError
+		}
 	}
 	if len(duouser) == 0 {
 		r.Log(bot.Error, fmt.Sprintf("Couldn't extract a Duo user name for %s with DuoUserString: %s", r.User, cfg.DuoUserString))
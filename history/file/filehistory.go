@@ -8,6 +8,9 @@ import (
 	"log"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/lnxjedi/gopherbot/bot"
 )
@@ -82,6 +85,41 @@ func (fhc *historyConfig) NewHistory(tag string, index, maxHistories int) (bot.H
 	}
 }
 
+// ListHistories returns the indexes of history files stored for a tag.
+func (fhc *historyConfig) ListHistories(tag string) ([]int, error) {
+	dirPath := path.Join(fhc.Directory, tag)
+	files, err := ioutil.ReadDir(dirPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []int{}, nil
+		}
+		return nil, fmt.Errorf("Error reading history directory '%s': %v", dirPath, err)
+	}
+	prefix := tag + "-"
+	indexes := make([]int, 0, len(files))
+	for _, f := range files {
+		name := f.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ".log") {
+			continue
+		}
+		if index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, prefix), ".log")); err == nil {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+	return indexes, nil
+}
+
+// GetHistory reads a history file and returns the lines.
+func (fhc *historyConfig) GetHistory(tag string, index int) ([]string, error) {
+	filePath := path.Join(fhc.Directory, tag, fmt.Sprintf("%s-%d.log", tag, index))
+	contents, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading history file '%s': %v", filePath, err)
+	}
+	return strings.Split(strings.TrimRight(string(contents), "\n"), "\n"), nil
+}
+
 // The file brain doesn't need the logger, but other brains might
 func provider(r bot.Handler) bot.HistoryProvider {
 	robot = r
@@ -0,0 +1,20 @@
+#!/bin/bash
+
+# hello.sh - trivial shell job example for Gopherbot; jobs are called with
+# command "run", and receive their parameters as environment variables.
+
+# START Boilerplate
+[ -z "$GOPHER_INSTALLDIR" ] && { echo "GOPHER_INSTALLDIR not set" >&2; exit 1; }
+source $GOPHER_INSTALLDIR/lib/gopherbot_v1.sh
+
+command=$1
+shift
+# END Boilerplate
+
+case "$command" in
+	"run")
+		[ -n "$DELAY" ] && sleep $DELAY
+		Say "${GREETING:-Hello}, ${TARGET:-World}!"
+		exit ${EXIT:-0}
+		;;
+esac
@@ -1,4 +1,5 @@
 import os
+import base64
 import json
 import random
 import subprocess
@@ -61,6 +62,11 @@ class Robot:
     NoBotEmail = 21
     MailError = 22
     InvalidCallerID = 23
+    ReplyPending = 26
+    FailedMessageSend = 27
+    ConnectorNotSupported = 28
+    NotAllowedInParallel = 29
+    TaskNotAuthorized = 30
 
     # Plugin return values / exit codes
     Normal = 0
@@ -76,6 +82,9 @@ class Robot:
         self.plugin_id = os.getenv("GOPHER_CALLER_ID")
         self.format = ""
         self.protocol = os.getenv("GOPHER_PROTOCOL")
+        self.thread_id = os.getenv("GOPHER_THREAD_ID", "")
+        self.threaded = os.getenv("GOPHER_THREADED_MESSAGE", "") != ""
+        self.reply_timeout = 0
 
     def Direct(self):
         "Get a direct messaging instance of the robot"
@@ -85,11 +94,24 @@ class Robot:
         "Get a bot with a non-default message format"
         return FormattedBot(self, format)
 
+    def Threaded(self):
+        "Get a bot that replies in a thread"
+        return ThreadedBot(self)
+
+    def ReplyTimeout(self, seconds):
+        "Get a bot whose prompts wait the given number of seconds for a reply"
+        return TimeoutBot(self, seconds)
+
+    def Conversation(self):
+        "Start a wizard-style conversation with the user"
+        return Conversation(self)
+
     def Call(self, func_name, func_args, format=""):
         if len(format) == 0:
             format = self.format
         func_call = { "FuncName": func_name, "User": self.user,
-                    "Channel": self.channel, "Format": format,
+                    "Channel": self.channel, "ThreadID": self.thread_id,
+                    "Threaded": self.threaded, "Format": format,
                     "Protocol": self.protocol, "CallerID": self.plugin_id,
                     "FuncArgs": func_args }
         func_json = json.dumps(func_call)
@@ -124,6 +146,20 @@ class Robot:
     def AddTask(self, name, args)
         return self.Call("AddTask", { "Name": name, "CmdArgs": args })
 
+    def AddFailTask(self, name, args)
+        return self.Call("AddFailTask", { "Name": name, "CmdArgs": args })
+
+    def AddFinalTask(self, name, args)
+        return self.Call("AddFinalTask", { "Name": name, "CmdArgs": args })
+
+    def AddParallelTasks(self, limit, tasks)
+        return self.Call("AddParallelTasks", { "Limit": limit, "Tasks": tasks })
+
+    def ScheduleTask(self, at, name, args)
+        if hasattr(at, "isoformat"):
+            at = at.isoformat()
+        return self.Call("ScheduleTask", { "At": at, "Name": name, "CmdArgs": args })
+
     def SetParameter(self, name, value)
         return self.Call("SetParameter", { "Name": name, "Value": value })
 
@@ -157,9 +193,14 @@ class Robot:
         return self.PromptUserChannelForReply(regex_id, user, "", prompt, format)
 
     def PromptUserChannelForReply(self, regex_id, user, channel, prompt, format=""):
-        for i in range(0, 3):
-            rep = self.Call("PromptUserChannelForReply", { "RegexID": regex_id, "User": user, "Channel": channel, "Prompt": prompt }, format)
+        i = 0
+        while i < 3:
+            rep = self.Call("PromptUserChannelForReply", { "RegexID": regex_id, "User": user, "Channel": channel, "Prompt": prompt, "Timeout": self.reply_timeout }, format)
+            # The prompt is still waiting for a reply; call again to keep waiting
+            if rep["RetVal"] == self.ReplyPending:
+                continue
             if rep["RetVal"] == self.RetryPrompt:
+                i += 1
                 continue
             return Reply(rep)
         if rep["RetVal"] == self.RetryPrompt:
@@ -181,18 +222,63 @@ class Robot:
         "Channel": channel, "Message": message }, format)
         return ret["RetVal"]
 
+    def SendThreadMessage(self, channel, thread, message, format=""):
+        ret = self.Call("SendThreadMessage", { "Channel": channel,
+        "Thread": thread, "Message": message }, format)
+        return ret["RetVal"]
+
+    def SendUserThreadMessage(self, user, channel, thread, message, format=""):
+        ret = self.Call("SendUserThreadMessage", { "User": user,
+        "Channel": channel, "Thread": thread, "Message": message }, format)
+        return ret["RetVal"]
+
     def Say(self, message, format=""):
         if self.channel == '':
             return self.SendUserMessage(self.user, message, format)
+        elif self.threaded:
+            return self.SendThreadMessage(self.channel, self.thread_id, message, format)
         else:
             return self.SendChannelMessage(self.channel, message, format)
 
     def Reply(self, message, format=""):
         if self.channel == '':
             return self.SendUserMessage(self.user, message, format)
+        elif self.threaded:
+            return self.SendUserThreadMessage(self.user, self.channel, self.thread_id, message, format)
         else:
             return self.SendUserChannelMessage(self.user, self.channel, message, format)
 
+    def SendFile(self, name, content, comment=""):
+        """Upload a file to the user or channel; for connectors that can't
+        upload files, a text file is sent as a message"""
+        ret = self.Call("SendFile", { "Name": name, "Comment": comment,
+        "Content": base64.b64encode(content) })
+        return ret["RetVal"]
+
+    def SendAttachment(self, attachment):
+        """Send a structured message, given as a dict with any of: Color,
+        Pretext, Title, TitleLink, Text, Fields, Footer and Blocks"""
+        ret = self.Call("SendAttachment", attachment)
+        return ret["RetVal"]
+
+    def SendEditableMessage(self, message, format=""):
+        """Send a message like Say, returning a dict with the message
+        reference and RetVal; pass it to UpdateMessage to update the message
+        in place"""
+        return self.Call("SendEditableMessage", { "Message": message }, format)
+
+    def UpdateMessage(self, ref, message, format=""):
+        """Update a message sent with SendEditableMessage; for connectors
+        that can't edit messages, the update is sent as a new message"""
+        ret = self.Call("UpdateMessage", { "Protocol": ref["Protocol"],
+        "ID": ref["ID"], "Message": message }, format)
+        return ret["RetVal"]
+
+    def React(self, reaction):
+        "Add an emoji reaction to the message that triggered the task"
+        ret = self.Call("React", { "Reaction": reaction })
+        return ret["RetVal"]
+
 class DirectBot(Robot):
     "Instantiate a robot for direct messaging with the user"
     def __init__(self, bot):
@@ -201,6 +287,9 @@ class DirectBot(Robot):
         self.protocol = bot.protocol
         self.format = bot.format
         self.plugin_id = bot.plugin_id
+        self.thread_id = ""
+        self.threaded = False
+        self.reply_timeout = bot.reply_timeout
 
 class FormattedBot(Robot):
     "Instantiate a robot with a non-default message format"
@@ -210,3 +299,64 @@ class FormattedBot(Robot):
         self.protocol = bot.protocol
         self.format = format
         self.plugin_id = bot.plugin_id
+        self.thread_id = bot.thread_id
+        self.threaded = bot.threaded
+        self.reply_timeout = bot.reply_timeout
+
+class ThreadedBot(Robot):
+    "Instantiate a robot that replies in a thread"
+    def __init__(self, bot):
+        self.channel = bot.channel
+        self.user = bot.user
+        self.protocol = bot.protocol
+        self.format = bot.format
+        self.plugin_id = bot.plugin_id
+        self.thread_id = bot.thread_id
+        self.threaded = bot.channel != ""
+        self.reply_timeout = bot.reply_timeout
+
+class TimeoutBot(Robot):
+    "Instantiate a robot with a non-default reply timeout"
+    def __init__(self, bot, seconds):
+        self.channel = bot.channel
+        self.user = bot.user
+        self.protocol = bot.protocol
+        self.format = bot.format
+        self.plugin_id = bot.plugin_id
+        self.thread_id = bot.thread_id
+        self.threaded = bot.threaded
+        self.reply_timeout = seconds
+
+class Conversation:
+    """Keep the answers for a series of prompts, like a wizard; a reply of '='
+    keeps the current answer for a question"""
+    Retries = 2
+
+    def __init__(self, bot):
+        self.bot = bot
+        self.answers = {}
+
+    def Ask(self, name, regex_id, prompt):
+        """Prompt for a reply and store it as the answer for name; returns
+        Ok, or the RetVal that ended the conversation"""
+        has_default = name in self.answers
+        if has_default:
+            prompt = "%s (or '=' for '%s')" % (prompt, self.answers[name])
+        for i in range(0, self.Retries + 1):
+            rep = self.bot.PromptForReply(regex_id, prompt)
+            if rep.ret == Robot.Ok:
+                self.answers[name] = rep.reply
+                return Robot.Ok
+            if rep.ret == Robot.UseDefaultValue and has_default:
+                return Robot.Ok
+            if rep.ret not in (Robot.UseDefaultValue, Robot.ReplyNotMatched):
+                return rep.ret
+            if i < self.Retries:
+                self.bot.Reply("Sorry, I didn't understand that - try again, or reply '-' to cancel")
+        return Robot.ReplyNotMatched
+
+    def Get(self, name):
+        return self.answers.get(name, "")
+
+    def Set(self, name, value):
+        self.answers[name] = value
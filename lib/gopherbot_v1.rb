@@ -1,5 +1,7 @@
+require 'base64'
 require 'json'
 require 'net/http'
+require 'time'
 require 'uri'
 
 class Attribute
@@ -67,6 +69,11 @@ class BaseBot
 	NoBotEmail = 21
 	MailError = 22
 	InvalidCallerID = 23
+	ReplyPending = 26
+	FailedMessageSend = 27
+	ConnectorNotSupported = 28
+	NotAllowedInParallel = 29
+	TaskNotAuthorized = 30
 
 	# Plugin return values / exit codes
 	Normal = 0
@@ -99,6 +106,23 @@ class BaseBot
 	def AddTask(name, args)
 		return callBotFunc("AddTask", { "Name" => name, "CmdArgs" => args })
 	end
+
+	def AddFailTask(name, args)
+		return callBotFunc("AddFailTask", { "Name" => name, "CmdArgs" => args })
+	end
+
+	def AddFinalTask(name, args)
+		return callBotFunc("AddFinalTask", { "Name" => name, "CmdArgs" => args })
+	end
+
+	def AddParallelTasks(limit, tasks)
+		return callBotFunc("AddParallelTasks", { "Limit" => limit, "Tasks" => tasks })
+	end
+
+	def ScheduleTask(at, name, args)
+		at = at.iso8601 if at.respond_to?(:iso8601)
+		return callBotFunc("ScheduleTask", { "At" => at, "Name" => name, "CmdArgs" => args })
+	end
 	
 	def SetParameter(name, value)
 		return callBotFunc("SetParameter", { "Name" => name, "Value" => value })
@@ -187,10 +211,26 @@ class BaseBot
 		return ret["RetVal"]
 	end
 
+	def SendThreadMessage(channel, thread, message, format="")
+		format = format.to_s if format.class == Symbol
+		args = { "Channel" => channel, "Thread" => thread, "Message" => message }
+		ret = callBotFunc("SendThreadMessage", args, format)
+		return ret["RetVal"]
+	end
+
+	def SendUserThreadMessage(user, channel, thread, message, format="")
+		format = format.to_s if format.class == Symbol
+		args = { "User" => user, "Channel" => channel, "Thread" => thread, "Message" => message }
+		ret = callBotFunc("SendUserThreadMessage", args, format)
+		return ret["RetVal"]
+	end
+
 	def Say(message, format="")
 		format = format.to_s if format.class == Symbol
 		if @channel.empty?
 			return SendUserMessage(@user, message, format)
+		elsif @threaded
+			return SendThreadMessage(@channel, @thread_id, message, format)
 		else
 			return SendChannelMessage(@channel, message, format)
 		end
@@ -204,11 +244,50 @@ class BaseBot
 		format = format.to_s if format.class == Symbol
 		if @channel.empty?
 			return SendUserMessage(@user, message, format)
+		elsif @threaded
+			return SendUserThreadMessage(@user, @channel, @thread_id, message, format)
 		else
 			return SendUserChannelMessage(@user, @channel, message, format)
 		end
 	end
 
+	# SendFile uploads a file to the user or channel; for connectors that
+	# can't upload files, a text file is sent as a message
+	def SendFile(name, content, comment="")
+		args = { "Name" => name, "Comment" => comment, "Content" => Base64.strict_encode64(content) }
+		ret = callBotFunc("SendFile", args)
+		return ret["RetVal"]
+	end
+
+	# SendAttachment sends a structured message, given as a hash with any of:
+	# Color, Pretext, Title, TitleLink, Text, Fields, Footer and Blocks
+	def SendAttachment(attachment)
+		ret = callBotFunc("SendAttachment", attachment)
+		return ret["RetVal"]
+	end
+
+	# SendEditableMessage sends a message like Say, returning a hash with the
+	# message reference and RetVal, for updating the message with UpdateMessage
+	def SendEditableMessage(message, format="")
+		format = format.to_s if format.class == Symbol
+		return callBotFunc("SendEditableMessage", { "Message" => message }, format)
+	end
+
+	# UpdateMessage updates a message sent with SendEditableMessage; for
+	# connectors that can't edit messages, the update is sent as a new message
+	def UpdateMessage(ref, message, format="")
+		format = format.to_s if format.class == Symbol
+		args = { "Protocol" => ref["Protocol"], "ID" => ref["ID"], "Message" => message }
+		ret = callBotFunc("UpdateMessage", args, format)
+		return ret["RetVal"]
+	end
+
+	# React adds an emoji reaction to the message that triggered the task
+	def React(reaction)
+		ret = callBotFunc("React", { "Reaction" => reaction })
+		return ret["RetVal"]
+	end
+
 	def PromptForReply(regex_id, prompt)
 		return PromptUserChannelForReply(regex_id, @user, @channel, prompt)
 	end
@@ -218,10 +297,16 @@ class BaseBot
 	end
 
 	def PromptUserChannelForReply(regex_id, user, channel, prompt)
-		args = { "RegexID" => regex_id, "User" => user, "Channel" => channel, "Prompt" => prompt }
-		for i in 1..3
+		args = { "RegexID" => regex_id, "User" => user, "Channel" => channel, "Prompt" => prompt, "Timeout" => @reply_timeout.to_i }
+		tries = 0
+		while tries < 3
 			ret = callBotFunc("PromptUserChannelForReply", args)
-			next if ret["RetVal"] == RetryPrompt
+			# The prompt is still waiting for a reply; call again to keep waiting
+			next if ret["RetVal"] == ReplyPending
+			if ret["RetVal"] == RetryPrompt
+				tries += 1
+				next
+			end
 			return Reply.new(ret["Reply"], ret["RetVal"])
 		end
 		if ret == RetryPrompt
@@ -231,6 +316,10 @@ class BaseBot
 		end
 	end
 
+	def Conversation()
+		return Conversation.new(self)
+	end
+
 	def callBotFunc(funcname, args, format="")
 		if format.size == 0
 			format = @format
@@ -239,6 +328,8 @@ class BaseBot
 			"FuncName" => funcname,
 			"User" => @user,
 			"Channel" => @channel,
+			"ThreadID" => @thread_id,
+			"Threaded" => @threaded,
 			"Protocol" => @protocol,
 			"Format" => format,
 			"CallerID" => @plugin_id,
@@ -264,6 +355,8 @@ class Robot < BaseBot
 		@user = ENV["GOPHER_USER"]
 		@plugin_id = ENV["GOPHER_CALLER_ID"]
 		@protocol = ENV["GOPHER_PROTOCOL"]
+		@thread_id = ENV["GOPHER_THREAD_ID"].to_s
+		@threaded = !ENV["GOPHER_THREADED_MESSAGE"].to_s.empty?
 		@format = ""
 		@prng = Random.new
 	end
@@ -273,7 +366,15 @@ class Robot < BaseBot
 	end
 
 	def MessageFormat(format)
-		FormattedBot.new(@user, @channel, @plugin_id, @protocol, format, @prng)
+		FormattedBot.new(@user, @channel, @plugin_id, @protocol, format, @prng, @thread_id, @threaded)
+	end
+
+	def Threaded()
+		FormattedBot.new(@user, @channel, @plugin_id, @protocol, @format, @prng, @thread_id, !@channel.empty?)
+	end
+
+	def ReplyTimeout(seconds)
+		FormattedBot.new(@user, @channel, @plugin_id, @protocol, @format, @prng, @thread_id, @threaded, seconds)
 	end
 end
 
@@ -286,19 +387,71 @@ class DirectBot < BaseBot
 		@protocol = protocol
 		@format = format
 		@prng = prng
+		@thread_id = ""
+		@threaded = false
 	end
 
 end
 
 class FormattedBot < BaseBot
 
-	def initialize(user, channel, plugin_id, protocol, format, prng)
+	def initialize(user, channel, plugin_id, protocol, format, prng, thread_id, threaded, reply_timeout=0)
 		@channel = channel
 		@user = user
 		@plugin_id = plugin_id
 		@protocol = protocol
 		@format = format
 		@prng = prng
+		@thread_id = thread_id
+		@threaded = threaded
+		@reply_timeout = reply_timeout
 	end
 
 end
+
+# Conversation keeps the answers for a series of prompts, like a wizard; a
+# reply of '=' keeps the current answer for a question
+class Conversation
+	Retries = 2
+
+	def initialize(bot)
+		@bot = bot
+		@answers = {}
+	end
+
+	attr_reader :answers
+
+	# Ask prompts for a reply and stores it as the answer for name; returns
+	# Ok, or the RetVal that ended the conversation
+	def Ask(name, regex_id, prompt)
+		has_default = @answers.key?(name)
+		if has_default
+			prompt = "#{prompt} (or '=' for '#{@answers[name]}')"
+		end
+		for i in 0..Retries
+			rep = @bot.PromptForReply(regex_id, prompt)
+			case rep.ret
+			when BaseBot::Ok
+				@answers[name] = rep.reply
+				return BaseBot::Ok
+			when BaseBot::UseDefaultValue
+				return BaseBot::Ok if has_default
+			when BaseBot::ReplyNotMatched
+			else
+				return rep.ret
+			end
+			if i < Retries
+				@bot.Reply("Sorry, I didn't understand that - try again, or reply '-' to cancel")
+			end
+		end
+		return BaseBot::ReplyNotMatched
+	end
+
+	def Get(name)
+		return @answers.fetch(name, "")
+	end
+
+	def Set(name, value)
+		@answers[name] = value
+	end
+end
@@ -25,6 +25,11 @@ GBRET_NoUserEmail=20
 GBRET_NoBotEmail=21
 GBRET_MailError=22
 GBRET_InvalidCallerID=23
+GBRET_ReplyPending=26
+GBRET_FailedMessageSend=27
+GBRET_ConnectorNotSupported=28
+GBRET_NotAllowedInParallel=29
+GBRET_TaskNotAuthorized=30
 
 # Plugin return values / exit codes
 PLUGRET_Normal=0
@@ -46,12 +51,16 @@ gbPostJSON(){
 	local GB_FUNCARGS="$2"
 	local FORMAT=${3:-$GB_FORMAT}
 	local JSON JSONRET
+	local THREADED="false"
+	[ -n "$GOPHER_THREADED_MESSAGE" ] && THREADED="true"
 	#local GB_DEBUG="true"
 	JSON=$(cat <<EOF
 {
 	"FuncName": "$GB_FUNCNAME",
 	"User": "$GOPHER_USER",
 	"Channel": "$GOPHER_CHANNEL",
+	"ThreadID": "$GOPHER_THREAD_ID",
+	"Threaded": $THREADED,
 	"Format": "$FORMAT",
 	"Protocol": "$GOPHER_PROTOCOL",
 	"CallerID": "$GOPHER_CALLER_ID",
@@ -199,6 +208,120 @@ EOF
 	gbBotRet "$GB_RET"
 }
 
+# AddFailTask adds a task to run if the pipeline fails; the failed task and
+# it's exit code are in $GOPHER_FAILED_TASK and $GOPHER_FAILED_EXIT_CODE
+AddFailTask(){
+	local JSTR
+	local TNAME="$1"
+	shift
+	for ARG in "$@"
+	do
+		JSTR="$JSTR \"$ARG\""
+	done
+	if [ -n "$JSTR" ]
+	then
+		JSTR=$(echo ${JSTR//\" \"/\", \"})
+	fi
+	local GB_FUNCARGS=$(cat <<EOF
+{
+	"Name": "$TNAME",
+	"CmdArgs": [ $JSTR ]
+}
+EOF
+)
+	local GB_FUNCNAME="AddFailTask"
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
+# AddFinalTask adds a task to run when the pipeline finishes, even if it
+# failed
+AddFinalTask(){
+	local JSTR
+	local TNAME="$1"
+	shift
+	for ARG in "$@"
+	do
+		JSTR="$JSTR \"$ARG\""
+	done
+	if [ -n "$JSTR" ]
+	then
+		JSTR=$(echo ${JSTR//\" \"/\", \"})
+	fi
+	local GB_FUNCARGS=$(cat <<EOF
+{
+	"Name": "$TNAME",
+	"CmdArgs": [ $JSTR ]
+}
+EOF
+)
+	local GB_FUNCNAME="AddFinalTask"
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
+# AddParallelTasks adds a group of tasks to the pipeline that run at the same
+# time, at most LIMIT at once (0 for no limit); each task is a quoted string
+# with the task name and it's command and args or job parameters, e.g.:
+# AddParallelTasks 2 "deploy REGION=us-east" "deploy REGION=eu-west"
+AddParallelTasks(){
+	local JSTR
+	local TLIMIT="$1"
+	shift
+	for TASK in "$@"
+	do
+		local TSTR=""
+		for ARG in $TASK
+		do
+			TSTR="$TSTR \"$ARG\""
+		done
+		TSTR=$(echo ${TSTR//\" \"/\", \"})
+		JSTR="$JSTR [ $TSTR ]"
+	done
+	if [ -n "$JSTR" ]
+	then
+		JSTR=$(echo ${JSTR//\] \[/\], \[})
+	fi
+	local GB_FUNCARGS=$(cat <<EOF
+{
+	"Limit": ${TLIMIT:-0},
+	"Tasks": [ $JSTR ]
+}
+EOF
+)
+	local GB_FUNCNAME="AddParallelTasks"
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
+# ScheduleTask runs a job or plugin once at a later time, given in RFC3339
+# format, e.g.: ScheduleTask "$(date -Iseconds -d '+20 minutes')" backup TARGET=home
+ScheduleTask(){
+	local JSTR
+	local TAT="$1"
+	local TNAME="$2"
+	shift 2
+	for ARG in "$@"
+	do
+		JSTR="$JSTR \"$ARG\""
+	done
+	if [ -n "$JSTR" ]
+	then
+		JSTR=$(echo ${JSTR//\" \"/\", \"})
+	fi
+	local GB_FUNCARGS=$(cat <<EOF
+{
+	"At": "$TAT",
+	"Name": "$TNAME",
+	"CmdArgs": [ $JSTR ]
+}
+EOF
+)
+	local GB_FUNCNAME="ScheduleTask"
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
 Elevate(){
 	IMMEDIATE="false"
 	if [ -n "$1" ]
@@ -302,31 +425,32 @@ PromptUserChannelForReply(){
 	"User": "$PUSER",
 	"Channel": "$PCHANNEL",
 	"Prompt": "$PROMPT",
+	"Timeout": ${GB_REPLY_TIMEOUT:-0},
 	"Base64" : true
 }
 EOF
 )
 	local RETVAL
-	for TRY in 0 1 2
+	local TRY=0
+	while [ $TRY -lt 3 ]
 	do
 		GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
 		gbBotRet "$GB_RET"
 		RETVAL=$?
+		# The prompt is still waiting for a reply; call again to keep waiting
+		if [ $RETVAL -eq $GBRET_ReplyPending ]
+		then
+			continue
+		fi
 		if [ $RETVAL -eq $GBRET_RetryPrompt ]
 		then
+			TRY=$((TRY + 1))
 			continue
 		fi
 		gbExtract "$GB_RET" Reply
 		return $RETVAL
 	done
-	gbBotRet "$GB_RET"
-	RETVAL=$?
-	if [ $RETVAL -eq $GBRET_RetryPrompt ]
-	then
-		return $GBRET_Interrupted
-	else
-		return $RETVAL
-	fi
+	return $GBRET_Interrupted
 }
 
 PromptForReply(){
@@ -353,6 +477,62 @@ MessageFormat(){
 	fi
 }
 
+# ReplyTimeout sets how many seconds prompts wait for a reply, overriding the
+# task and robot ReplyTimeout; for a single prompt, use e.g.:
+# GB_REPLY_TIMEOUT=300 PromptForReply ...
+ReplyTimeout(){
+	if [ -n "$1" ]
+	then
+		export GB_REPLY_TIMEOUT="$1"
+	fi
+}
+
+# Ask is a helper for wizard-style conversations. It prompts for a reply
+# matching REGEX and stores it in the shell variable NAME; if NAME already
+# has a value, the user can reply '=' to keep it. Replies that don't match
+# are asked again a couple of times. Don't call Ask in a subshell, or the
+# answer is lost.
+# Usage: Ask NAME REGEX PROMPT
+Ask(){
+	# Locals are prefixed so they don't hide the caller's NAME
+	local GB_ASK_NAME="$1"
+	local GB_ASK_REGEX="$2"
+	local GB_ASK_PROMPT="$3"
+	local GB_ASK_CURRENT="${!GB_ASK_NAME}"
+	local GB_ASK_ANSWER GB_ASK_RET GB_ASK_TRY
+	[ -n "$GB_ASK_CURRENT" ] && GB_ASK_PROMPT="$GB_ASK_PROMPT (or '=' for '$GB_ASK_CURRENT')"
+	for GB_ASK_TRY in 0 1 2
+	do
+		GB_ASK_ANSWER=$(PromptForReply "$GB_ASK_REGEX" "$GB_ASK_PROMPT")
+		GB_ASK_RET=$?
+		case $GB_ASK_RET in
+		$GBRET_Ok)
+			printf -v "$GB_ASK_NAME" '%s' "$GB_ASK_ANSWER"
+			return $GBRET_Ok
+			;;
+		$GBRET_UseDefaultValue)
+			[ -n "$GB_ASK_CURRENT" ] && return $GBRET_Ok
+			;;
+		$GBRET_ReplyNotMatched)
+			;;
+		*)
+			return $GB_ASK_RET
+			;;
+		esac
+		[ $GB_ASK_TRY -lt 2 ] && Reply "Sorry, I didn't understand that - try again, or reply '-' to cancel"
+	done
+	return $GBRET_ReplyNotMatched
+}
+
+# Threaded makes Say, Reply and PromptForReply use a thread, starting one
+# from the user's message if it wasn't already in a thread
+Threaded(){
+	if [ -n "$GOPHER_CHANNEL" ]
+	then
+		export GOPHER_THREADED_MESSAGE="true"
+	fi
+}
+
 getFormat(){
 	case "$1" in
 	"-f")
@@ -435,11 +615,64 @@ EOF
 	gbBotRet "$GB_RET"
 }
 
+SendThreadMessage(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$(getFormat $1); shift; fi
+	local GB_FUNCARGS GB_RET
+	local GB_FUNCNAME="SendThreadMessage"
+	local STM_CHANNEL=$1
+	local STM_THREAD=$2
+	shift 2
+	MESSAGE="$*"
+	MESSAGE=$(base64_encode "$MESSAGE")
+
+	GB_FUNCARGS=$(cat <<EOF
+{
+	"Channel": "$STM_CHANNEL",
+	"Thread": "$STM_THREAD",
+	"Message": "$MESSAGE",
+	"Base64" : true
+}
+EOF
+)
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
+SendUserThreadMessage(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$(getFormat $1); shift; fi
+	local GB_FUNCARGS GB_RET
+	local GB_FUNCNAME="SendUserThreadMessage"
+	local SUTM_USER=$1
+	local SUTM_CHANNEL=$2
+	local SUTM_THREAD=$3
+	shift 3
+	MESSAGE="$*"
+	MESSAGE=$(base64_encode "$MESSAGE")
+
+	GB_FUNCARGS=$(cat <<EOF
+{
+	"User": "$SUTM_USER",
+	"Channel": "$SUTM_CHANNEL",
+	"Thread": "$SUTM_THREAD",
+	"Message": "$MESSAGE",
+	"Base64" : true
+}
+EOF
+)
+	GB_RET=$(gbPostJSON $GB_FUNCNAME "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
 # Convenience functions so that copies of this logic don't wind up in a bunch of plugins
 Say(){
 	local FARG
 	[[ $1 == -? ]] && { FARG=$1; shift; }
-	if [ -n "$GOPHER_CHANNEL" ]
+	if [ -n "$GOPHER_CHANNEL" -a -n "$GOPHER_THREADED_MESSAGE" ]
+	then
+		SendThreadMessage $FARG "$GOPHER_CHANNEL" "$GOPHER_THREAD_ID" "$*"
+	elif [ -n "$GOPHER_CHANNEL" ]
 	then
 		SendChannelMessage $FARG "$GOPHER_CHANNEL" "$*"
 	else
@@ -450,10 +683,78 @@ Say(){
 Reply(){
 	local FARG
 	[[ $1 == -? ]] && { FARG=$1; shift; }
-	if [ -n "$GOPHER_CHANNEL" ]
+	if [ -n "$GOPHER_CHANNEL" -a -n "$GOPHER_THREADED_MESSAGE" ]
+	then
+		SendUserThreadMessage $FARG "$GOPHER_USER" "$GOPHER_CHANNEL" "$GOPHER_THREAD_ID" "$*"
+	elif [ -n "$GOPHER_CHANNEL" ]
 	then
 		SendUserChannelMessage $FARG "$GOPHER_USER" "$GOPHER_CHANNEL" "$*"
 	else
 		SendUserMessage $FARG "$GOPHER_USER" "$*"
 	fi
 }
+
+# SendFile uploads a file to the user or channel; for connectors that can't
+# upload files, a text file is sent as a message.
+# Usage: SendFile PATH [COMMENT]
+SendFile(){
+	local SF_PATH="$1"
+	local SF_COMMENT="$2"
+	local GB_FUNCARGS GB_RET
+	if [ ! -r "$SF_PATH" ]
+	then
+		echo "SendFile: unable to read '$SF_PATH'" >&2
+		return $GBRET_FailedMessageSend
+	fi
+	GB_FUNCARGS=$(jq -n --arg name "$(basename "$SF_PATH")" --arg comment "$SF_COMMENT" \
+		--arg content "$(base64 < "$SF_PATH" | tr -d '\n')" \
+		'{ "Name": $name, "Comment": $comment, "Content": $content }')
+	GB_RET=$(gbPostJSON "SendFile" "$GB_FUNCARGS")
+	gbBotRet "$GB_RET"
+}
+
+# SendAttachment sends a structured message, given as a JSON object with
+# any of: Color, Pretext, Title, TitleLink, Text, Fields (a list of Title,
+# Value, Short), Footer and Blocks; see doc/Message-Sending-API.md.
+# Usage: SendAttachment JSON
+SendAttachment(){
+	local GB_RET
+	GB_RET=$(gbPostJSON "SendAttachment" "$1")
+	gbBotRet "$GB_RET"
+}
+
+# SendEditableMessage sends a message like Say, printing a reference to the
+# message for UpdateMessage.
+# Usage: STATUS=$(SendEditableMessage [-f|-v] MESSAGE)
+SendEditableMessage(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$(getFormat $1); shift; fi
+	local GB_FUNCARGS GB_RET
+	GB_FUNCARGS=$(jq -n --arg message "$*" '{ "Message": $message }')
+	GB_RET=$(gbPostJSON "SendEditableMessage" "$GB_FUNCARGS" $FORMAT)
+	echo "$GB_RET" | jq -c '{ Protocol, ID }'
+	gbBotRet "$GB_RET"
+}
+
+# UpdateMessage updates a message sent with SendEditableMessage; for
+# connectors that can't edit messages, the update is sent as a new message.
+# Usage: UpdateMessage [-f|-v] "$STATUS" MESSAGE
+UpdateMessage(){
+	local FORMAT
+	if [[ $1 = -? ]]; then FORMAT=$(getFormat $1); shift; fi
+	local UM_REF="$1"
+	shift
+	local GB_FUNCARGS GB_RET
+	GB_FUNCARGS=$(echo "$UM_REF" | jq -c --arg message "$*" '{ Protocol, ID, "Message": $message }')
+	GB_RET=$(gbPostJSON "UpdateMessage" "$GB_FUNCARGS" $FORMAT)
+	gbBotRet "$GB_RET"
+}
+
+# React adds an emoji reaction to the message that triggered the task.
+# Usage: React REACTION
+React(){
+	local GB_FUNCARGS GB_RET
+	GB_FUNCARGS=$(jq -n --arg reaction "$1" '{ "Reaction": $reaction }')
+	GB_RET=$(gbPostJSON "React" "$GB_FUNCARGS")
+	gbBotRet "$GB_RET"
+}
@@ -10,6 +10,8 @@ import (
 
 	// *** Included connectors
 
+	_ "github.com/lnxjedi/gopherbot/connectors/irc"
+	_ "github.com/lnxjedi/gopherbot/connectors/mattermost"
 	_ "github.com/lnxjedi/gopherbot/connectors/slack"
 	// NOTE: if you build with '-tags test', the terminal connector will also
 	// show emitted events.
@@ -17,8 +19,10 @@ import (
 
 	// *** Included brain implementations
 
+	_ "github.com/lnxjedi/gopherbot/brains/bolt"
 	_ "github.com/lnxjedi/gopherbot/brains/dynamodb"
 	_ "github.com/lnxjedi/gopherbot/brains/file"
+	_ "github.com/lnxjedi/gopherbot/brains/redis"
 
 	// *** Included history implementations
 	_ "github.com/lnxjedi/gopherbot/history/file"
@@ -38,11 +38,11 @@ do
 	then
 		GOOS=$BUILDOS go build -o gopherbot.exe 
 		echo "Creating $OUTFILE"
-		zip -r $OUTFILE gopherbot.exe LICENSE README.md brain/ conf/ doc/ cfg/ lib/ licenses/ misc/ plugins/ --exclude *.swp --exclude conf/*.yaml --exclude conf/*/*.yaml
+		zip -r $OUTFILE gopherbot.exe LICENSE README.md brain/ conf/ doc/ cfg/ lib/ licenses/ misc/ plugins/ jobs/ --exclude *.swp --exclude conf/*.yaml --exclude conf/*/*.yaml
 	else
 		GOOS=$BUILDOS go build
 		echo "Creating $OUTFILE"
-		zip -r $OUTFILE gopherbot LICENSE README.md brain/ conf/ doc/ cfg/ lib/ licenses/ misc/ plugins/ --exclude *.swp --exclude conf/*.yaml --exclude conf/*/*.yaml
+		zip -r $OUTFILE gopherbot LICENSE README.md brain/ conf/ doc/ cfg/ lib/ licenses/ misc/ plugins/ jobs/ --exclude *.swp --exclude conf/*.yaml --exclude conf/*/*.yaml
 	fi
 
 done
@@ -19,6 +19,26 @@ CommandMatchers:
   Regex: '(?i:waitask)'
 - Command: "asknow"
   Regex: '(?i:asknow)'
+- Command: "relay"
+  Regex: '(?i:relay ([\w:-]+) (.*))'
+- Command: "thread"
+  Regex: '(?i:thread)'
+- Command: "wizard"
+  Regex: '(?i:wizard)'
+- Command: "quick"
+  Regex: '(?i:quick)'
+- Command: "abandon"
+  Regex: '(?i:abandon)'
+- Command: "report"
+  Regex: '(?i:report)'
+- Command: "attach"
+  Regex: '(?i:attach)'
+- Command: "progress"
+  Regex: '(?i:progress)'
+- Command: "react"
+  Regex: '(?i:react)'
+- Command: "whois"
+  Regex: '(?i:whois)'
 EOF
 }
 
@@ -39,4 +59,49 @@ case "$command" in
 		REPLY=$(PromptForReply YesNo "Do you like puppies?")
 		sleep 1
 		Say "I like puppies too!"		
+		;;
+	"relay")
+		SendChannelMessage "$1" "$2"
+		;;
+	"thread")
+		Threaded
+		Reply "replying in a thread"
+		;;
+	"wizard")
+		COLOR="blue"
+		Ask NAME SimpleString "What's your name?" || exit 0
+		Ask COLOR SimpleString "What's your favorite color?" || exit 0
+		Say "$NAME likes $COLOR"
+		;;
+	"quick")
+		ReplyTimeout 2
+		PromptForReply YesNo "Quick - yes or no?" >/dev/null
+		[ $? -eq $GBRET_TimeoutExpired ] && Say "Too slow!"
+		;;
+	"abandon")
+		# Exit without waiting for the reply
+		PromptForReply YesNo "Are you still there?" >/dev/null 2>&1 &
+		sleep 1
+		Say "Never mind"
+		;;
+	"report")
+		REPORTDIR=$(mktemp -d)
+		echo "build passed" > $REPORTDIR/report.txt
+		SendFile $REPORTDIR/report.txt "Here's the report"
+		rm -rf $REPORTDIR
+		;;
+	"attach")
+		SendAttachment '{ "Title": "Build", "Text": "passed", "Fields": [ { "Title": "Branch", "Value": "master" } ] }'
+		;;
+	"progress")
+		STATUS=$(SendEditableMessage "Working...")
+		UpdateMessage "$STATUS" "Done!"
+		;;
+	"react")
+		React thumbsup
+		[ $? -eq $GBRET_ConnectorNotSupported ] && Say "No reactions here"
+		;;
+	"whois")
+		Say "email: $(GetSenderAttribute email), time zone: $(GetSenderAttribute timeZone), team: $(GetSenderAttribute team)"
+		;;
 esac